@@ -1,55 +1,323 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/broker"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/metrics"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/routes"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/tracing"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/websocket"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal("Failed to load configuration:", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if err := logging.Init(cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		log.Fatal("Invalid logging configuration:", err)
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatal("Failed to initialize tracing:", err)
 	}
 
-	mongoURI := os.Getenv("MONGODB_URI")
-	if err := database.ConnectDB(mongoURI); err != nil {
-		log.Fatal("Failed to connect to MongoDB:", err)
+	// "migrate" applies schema migrations and exits; "migrate status" lists them.
+	if len(args) > 0 && args[0] == "migrate" {
+		action := ""
+		if len(args) > 1 {
+			action = args[1]
+		}
+		runMigrate(cfg, action)
+		return
 	}
 
-	// Initialize Auth0 JWKS
-	if err := utils.InitJWKS(); err != nil {
-		log.Fatal("Failed to initialize Auth0 JWKS:", err)
+	var st *store.Store
+	switch cfg.Storage {
+	case "mongo":
+		if err := database.Connect(mongoOptions(cfg.Mongo)); err != nil {
+			log.Fatal("Failed to connect to MongoDB:", err)
+		}
+		if cfg.Mongo.AutoMigrate {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			err := database.Migrate(ctx, database.DB)
+			cancel()
+			if err != nil {
+				log.Fatal("Failed to apply MongoDB migrations:", err)
+			}
+		}
+		st = store.NewMongo(database.DB)
+	case "postgres", "sqlite":
+		s, err := store.OpenSQL(cfg.Storage, cfg.DatabaseURL)
+		if err != nil {
+			log.Fatalf("Failed to open %s database: %v", cfg.Storage, err)
+		}
+		st = s
+	case "memory":
+		slog.Warn("Using in-memory storage, data will be lost on restart")
+		st = store.NewMemory()
+	}
+
+	// Initialize the token provider (Auth0 JWKS by default)
+	auth, err := utils.NewAuthenticator(cfg.Auth)
+	if err != nil {
+		log.Fatal("Failed to initialize auth provider:", err)
+	}
+
+	provisioner := users.NewProvisioner(cfg.Auth, st)
+	origins := middleware.NewOriginPolicy(cfg.CORS.AllowedOrigins, cfg.Development())
+
+	bus := broker.Broker(broker.NewMemory())
+	if cfg.Broker.Kind == "redis" {
+		r, err := broker.NewRedis(cfg.Broker.RedisURL, cfg.Broker.Prefix)
+		if err != nil {
+			log.Fatal("Failed to connect to Redis:", err)
+		}
+		bus = r
+	}
+	sess := session.New()
+	hub, err := websocket.NewHub(websocket.Options{
+		Config:  cfg.WebSocket,
+		Broker:  bus,
+		Store:   st,
+		Session: sess,
+		Auth:    auth,
+		Origins: origins,
+		Users:   provisioner,
+	})
+	if err != nil {
+		log.Fatal("Failed to configure broker:", err)
 	}
+	hub.StartScheduler()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	rateStore := broker.Broker(broker.NewMemory())
+	if cfg.RateLimit.Store == "broker" {
+		rateStore = bus
+	}
+	limits, err := middleware.NewRateLimiter(cfg.RateLimit, rateStore)
+	if err != nil {
+		log.Fatal("Invalid RATE_LIMIT_POLICIES:", err)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+	r.Use(gin.Recovery(), middleware.RequestID(), tracing.Middleware(), middleware.AccessLog())
+	r.Use(metrics.Middleware())
+	r.Use(middleware.CORS(origins))
+	r.Use(middleware.BodyLimit(cfg.RateLimit.MaxBodyBytes))
 
 	r.Static("/static", "./static")
 
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"success": true,
-			"data":    gin.H{"status": "Server is running"},
+	if cfg.Metrics.Enabled {
+		metrics.ActiveSessions(func() float64 {
+			if sess.Get() != nil {
+				return 1
+			}
+			return 0
 		})
+		r.GET(cfg.Metrics.Path, metrics.Handler())
+	}
+
+	deps := routes.Deps{
+		Config:  cfg,
+		Store:   st,
+		Session: sess,
+		Auth:    auth,
+		Users:   provisioner,
+		Limits:  limits,
+		Hub:     hub,
+	}
+	routes.HealthRoutes(r, deps)
+	routes.AuthRoutes(r, deps)
+	routes.ClassRoutes(r, deps)
+	routes.AttendanceRoutes(r, deps)
+	routes.DebugRoutes(r, deps)
+	routes.AdminRoutes(r, deps)
+
+	r.GET("/ws", middleware.RateLimit(limits, "ws"), hub.HandleWebSocket)
+	ticket := middleware.Authenticated(limits, "ws", middleware.AuthMiddleware(auth, provisioner))
+	r.POST("/ws/ticket", append(ticket, hub.IssueTicket)...)
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: r,
+	}
+
+	go func() {
+		slog.Info("Server running", "port", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	slog.Info("Shutting down", "signal", sig.String(), "deadline", cfg.Shutdown.Timeout.String())
+
+	shutdown(srv, hub, st, cfg.Shutdown)
+	bus.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Flushing traces failed", "error", err)
+	}
+}
+
+// shutdown stops accepting connections, drains HTTP requests and WebSocket
+// clients, saves the active session and closes storage, all within
+// cfg.Timeout.
+func shutdown(srv *http.Server, hub *websocket.Hub, st *store.Store, cfg config.Shutdown) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	// Shutdown closes the listeners immediately, then waits for in-flight
+	// requests. Hijacked WebSocket connections are drained separately.
+	httpDone := make(chan error, 1)
+	go func() { httpDone <- srv.Shutdown(ctx) }()
+
+	err := hub.Shutdown(ctx, websocket.ShutdownOptions{
+		ReconnectAfter: cfg.ReconnectAfter,
+		SessionMode:    cfg.SessionMode,
 	})
+	if err != nil {
+		slog.Error("WebSocket drain failed", "error", err)
+	}
+	if err := <-httpDone; err != nil {
+		slog.Error("HTTP shutdown failed", "error", err)
+	}
+
+	closeStorage(st)
+	slog.Info("Server stopped")
+}
 
-	routes.AuthRoutes(r)
-	routes.ClassRoutes(r)
-	routes.AttendanceRoutes(r)
-	routes.DebugRoutes(r)
+func closeStorage(st *store.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	r.GET("/ws", websocket.HandleWebSocket)
+	if err := database.Disconnect(ctx); err != nil {
+		slog.Error("MongoDB disconnect failed", "error", err)
+	}
+	if st != nil {
+		if err := st.Close(); err != nil {
+			slog.Error("Storage close failed", "error", err)
+		}
+	}
+}
+
+func runMigrate(cfg *config.Config, action string) {
+	switch cfg.Storage {
+	case "mongo":
+		if err := database.Connect(mongoOptions(cfg.Mongo)); err != nil {
+			log.Fatal("Failed to connect to MongoDB:", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		if action != "status" {
+			if err := database.Migrate(ctx, database.DB); err != nil {
+				log.Fatal("Migration failed:", err)
+			}
+		}
+
+		states, err := database.MigrationStatus(ctx, database.DB)
+		if err != nil {
+			log.Fatal("Failed to read migration status:", err)
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%3d  %-25s  %s\n", s.Version, applied, s.Description)
+		}
+		database.Disconnect(ctx)
+	case "postgres", "sqlite":
+		if action == "status" {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
 
-	log.Printf("Server running on port %s", port)
-	r.Run(":" + port)
+			states, err := store.SQLMigrationStatus(ctx, cfg.Storage, cfg.DatabaseURL)
+			if err != nil {
+				log.Fatal("Failed to read migration status:", err)
+			}
+			for _, s := range states {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Printf("%3d  %s\n", s.Version, applied)
+			}
+			return
+		}
+
+		// OpenSQL applies pending migrations before returning.
+		s, err := store.OpenSQL(cfg.Storage, cfg.DatabaseURL)
+		if err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		s.Close()
+		slog.Info("SQL schema is up to date")
+	default:
+		log.Fatalf("Storage backend %q has no migrations", cfg.Storage)
+	}
+}
+
+// mongoOptions maps the MONGO_* settings onto the client options.
+func mongoOptions(c config.Mongo) database.Options {
+	return database.Options{
+		URI:                    c.URI,
+		Database:               c.Database,
+		AppName:                c.AppName,
+		MaxPoolSize:            c.MaxPoolSize,
+		MinPoolSize:            c.MinPoolSize,
+		MaxConnIdleTime:        c.MaxConnIdleTime,
+		ConnectTimeout:         c.ConnectTimeout,
+		ServerSelectionTimeout: c.ServerSelectionTimeout,
+		SocketTimeout:          c.SocketTimeout,
+		ReadConcern:            c.ReadConcern,
+		ReadPreference:         c.ReadPreference,
+		WriteConcern:           c.WriteConcern,
+		WriteJournal:           c.WriteJournal,
+		WriteTimeout:           c.WriteTimeout,
+		RetryWrites:            c.RetryWrites,
+		RetryReads:             c.RetryReads,
+		TLS:                    c.TLS,
+		TLSCAFile:              c.TLSCAFile,
+		TLSCertFile:            c.TLSCertFile,
+		TLSKeyFile:             c.TLSKeyFile,
+		TLSInsecure:            c.TLSInsecure,
+	}
 }
@@ -0,0 +1,49 @@
+// Package broker lets several server instances share WebSocket rooms. It
+// carries published messages between instances and holds the small pieces of
+// state they must agree on, such as the active session and resume tokens.
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("broker: key not found")
+
+// Broker is a topic-based pub/sub channel plus a key-value store. Every
+// instance subscribes to the same topics; a message published by one
+// instance is delivered to all of them, including itself.
+type Broker interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe registers handler for topic. Handlers run on the broker's
+	// delivery goroutine and should not block.
+	Subscribe(topic string, handler func(payload []byte)) error
+
+	// Incr atomically increments the counter at key and returns the new
+	// value. A counter created with a non-zero ttl expires that long after
+	// its first increment.
+	Incr(ctx context.Context, key string, ttl time.Duration) (uint64, error)
+	// Set stores value at key. A zero ttl keeps it until deleted.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Take atomically reads and deletes key.
+	Take(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+
+	// HUpdate sets the fields in set and deletes the fields in del of the
+	// hash at key in one atomic step, so values that change independently
+	// can be written without overwriting each other.
+	HUpdate(ctx context.Context, key string, set map[string][]byte, del []string) error
+	// HReplace atomically replaces the hash at key with fields.
+	HReplace(ctx context.Context, key string, fields map[string][]byte) error
+	// HGetAll returns every field of the hash at key, or an empty map if it
+	// does not exist. Delete removes a hash like any other key.
+	HGetAll(ctx context.Context, key string) (map[string][]byte, error)
+
+	// Distributed reports whether other instances may be listening, i.e.
+	// whether a client not connected here may be connected elsewhere.
+	Distributed() bool
+	Ping(ctx context.Context) error
+	Close() error
+}
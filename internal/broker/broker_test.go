@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// The suite runs each Broker through the same checks. advance moves the
+// broker's clock past a TTL: Memory uses the wall clock, miniredis has its
+// own.
+
+func TestMemory(t *testing.T) {
+	testBroker(t, NewMemory(), time.Sleep)
+}
+
+func TestRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newTestRedis(t, mr, "test")
+	testBroker(t, r, mr.FastForward)
+}
+
+func TestRedisSharesTopicsAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestRedis(t, mr, "test")
+	b := newTestRedis(t, mr, "test")
+	other := newTestRedis(t, mr, "other")
+
+	got := subscribe(t, b, "room")
+	leaked := subscribe(t, other, "room")
+	publishUntil(t, a, "room", "hello", got)
+	select {
+	case p := <-leaked:
+		t.Errorf("other prefix received %q", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ctx := context.Background()
+	if err := a.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Take(ctx, "k"); err != nil || string(v) != "v" {
+		t.Errorf("Take from second instance = %q, %v", v, err)
+	}
+	if _, err := other.Get(ctx, "k"); err != ErrNotFound {
+		t.Errorf("other prefix Get err = %v, want ErrNotFound", err)
+	}
+}
+
+func newTestRedis(t *testing.T, mr *miniredis.Miniredis, prefix string) *Redis {
+	t.Helper()
+	r, err := NewRedis("redis://"+mr.Addr(), prefix)
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func testBroker(t *testing.T, b Broker, advance func(time.Duration)) {
+	t.Run("PublishSubscribe", func(t *testing.T) { testPublishSubscribe(t, b) })
+	t.Run("Take", func(t *testing.T) { testTake(t, b) })
+	t.Run("TTL", func(t *testing.T) { testTTL(t, b, advance) })
+	t.Run("Incr", func(t *testing.T) { testIncr(t, b, advance) })
+	t.Run("Hash", func(t *testing.T) { testHash(t, b) })
+}
+
+func subscribe(t *testing.T, b Broker, topic string) <-chan string {
+	t.Helper()
+	ch := make(chan string, 16)
+	if err := b.Subscribe(topic, func(p []byte) { ch <- string(p) }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return ch
+}
+
+// publishUntil publishes until the message arrives on got. Redis
+// subscriptions become active asynchronously, so the first publish may
+// reach no one.
+func publishUntil(t *testing.T, b Broker, topic, payload string, got <-chan string) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		if err := b.Publish(context.Background(), topic, []byte(payload)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		select {
+		case p := <-got:
+			if p != payload {
+				t.Fatalf("received %q, want %q", p, payload)
+			}
+			return
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("%q was never delivered on %s", payload, topic)
+		}
+	}
+}
+
+func testPublishSubscribe(t *testing.T, b Broker) {
+	first := subscribe(t, b, "pubsub")
+	second := subscribe(t, b, "pubsub")
+	unrelated := subscribe(t, b, "pubsub-other")
+
+	publishUntil(t, b, "pubsub", "ready", first)
+	drain(second)
+	drain(first)
+
+	if err := b.Publish(context.Background(), "pubsub", []byte("msg")); err != nil {
+		t.Fatal(err)
+	}
+	for i, ch := range []<-chan string{first, second} {
+		select {
+		case p := <-ch:
+			if p != "msg" {
+				t.Errorf("handler %d received %q, want msg", i, p)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("handler %d received nothing", i)
+		}
+	}
+	select {
+	case p := <-unrelated:
+		t.Errorf("unrelated topic received %q", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// drain discards messages already delivered, waiting briefly for any still
+// in flight.
+func drain(ch <-chan string) {
+	for {
+		select {
+		case <-ch:
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func testTake(t *testing.T, b Broker) {
+	ctx := context.Background()
+	if err := b.Set(ctx, "take", []byte("once"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Get(ctx, "take"); err != nil || string(v) != "once" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+	if v, err := b.Take(ctx, "take"); err != nil || string(v) != "once" {
+		t.Errorf("Take = %q, %v", v, err)
+	}
+	if _, err := b.Take(ctx, "take"); err != ErrNotFound {
+		t.Errorf("second Take err = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Get(ctx, "take"); err != ErrNotFound {
+		t.Errorf("Get after Take err = %v, want ErrNotFound", err)
+	}
+
+	if err := b.Set(ctx, "deleted", []byte("x"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, "deleted"); err != ErrNotFound {
+		t.Errorf("Get after Delete err = %v, want ErrNotFound", err)
+	}
+}
+
+func testHash(t *testing.T, b Broker) {
+	ctx := context.Background()
+	if fields, err := b.HGetAll(ctx, "hash"); err != nil || len(fields) != 0 {
+		t.Fatalf("HGetAll of missing hash = %v, %v", fields, err)
+	}
+	set := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")}
+	if err := b.HUpdate(ctx, "hash", set, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.HUpdate(ctx, "hash", map[string][]byte{"a": []byte("4")}, []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := b.HGetAll(ctx, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || string(fields["a"]) != "4" || string(fields["c"]) != "3" {
+		t.Errorf("HGetAll = %q, want a=4 c=3", fields)
+	}
+
+	if err := b.HReplace(ctx, "hash", map[string][]byte{"d": []byte("5")}); err != nil {
+		t.Fatal(err)
+	}
+	if fields, _ := b.HGetAll(ctx, "hash"); len(fields) != 1 || string(fields["d"]) != "5" {
+		t.Errorf("HGetAll after HReplace = %q, want d=5", fields)
+	}
+
+	if err := b.Delete(ctx, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if fields, err := b.HGetAll(ctx, "hash"); err != nil || len(fields) != 0 {
+		t.Errorf("HGetAll after Delete = %q, %v", fields, err)
+	}
+}
+
+func testTTL(t *testing.T, b Broker, advance func(time.Duration)) {
+	ctx := context.Background()
+	if err := b.Set(ctx, "ttl", []byte("short"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(ctx, "ttl-take", []byte("short"), 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Set(ctx, "no-ttl", []byte("kept"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Get(ctx, "ttl"); err != nil || string(v) != "short" {
+		t.Fatalf("Get before expiry = %q, %v", v, err)
+	}
+
+	advance(150 * time.Millisecond)
+
+	if _, err := b.Get(ctx, "ttl"); err != ErrNotFound {
+		t.Errorf("Get after expiry err = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Take(ctx, "ttl-take"); err != ErrNotFound {
+		t.Errorf("Take after expiry err = %v, want ErrNotFound", err)
+	}
+	if v, err := b.Get(ctx, "no-ttl"); err != nil || string(v) != "kept" {
+		t.Errorf("Get without TTL = %q, %v", v, err)
+	}
+}
+
+func testIncr(t *testing.T, b Broker, advance func(time.Duration)) {
+	ctx := context.Background()
+	for want := uint64(1); want <= 3; want++ {
+		if n, err := b.Incr(ctx, "counter", 100*time.Millisecond); err != nil || n != want {
+			t.Fatalf("Incr = %d, %v, want %d", n, err, want)
+		}
+	}
+	for want := uint64(1); want <= 2; want++ {
+		if n, err := b.Incr(ctx, "forever", 0); err != nil || n != want {
+			t.Fatalf("Incr without TTL = %d, %v, want %d", n, err, want)
+		}
+	}
+
+	advance(150 * time.Millisecond)
+
+	if n, err := b.Incr(ctx, "counter", 100*time.Millisecond); err != nil || n != 1 {
+		t.Errorf("Incr after expiry = %d, %v, want 1", n, err)
+	}
+	if n, err := b.Incr(ctx, "forever", 0); err != nil || n != 3 {
+		t.Errorf("Incr without TTL after wait = %d, %v, want 3", n, err)
+	}
+}
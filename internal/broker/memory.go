@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type memoryCounter struct {
+	n         uint64
+	expiresAt time.Time
+}
+
+// Memory is an in-process Broker for single-instance deployments. Publish
+// delivers synchronously to local subscribers.
+type Memory struct {
+	mu       sync.RWMutex
+	handlers map[string][]func([]byte)
+	values   map[string]memoryEntry
+	counters map[string]memoryCounter
+	hashes   map[string]map[string][]byte
+	writes   int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		handlers: map[string][]func([]byte){},
+		values:   map[string]memoryEntry{},
+		counters: map[string]memoryCounter{},
+		hashes:   map[string]map[string][]byte{},
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, topic string, payload []byte) error {
+	m.mu.RLock()
+	handlers := m.handlers[topic]
+	m.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(payload)
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, handler func([]byte)) error {
+	m.mu.Lock()
+	m.handlers[topic] = append(m.handlers[topic], handler)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	c, ok := m.counters[key]
+	if !ok || (!c.expiresAt.IsZero() && now.After(c.expiresAt)) {
+		c = memoryCounter{}
+		if ttl > 0 {
+			c.expiresAt = now.Add(ttl)
+		}
+	}
+	c.n++
+	m.counters[key] = c
+	m.sweep(now)
+	return c.n, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	m.values[key] = e
+	m.sweep(time.Now())
+	return nil
+}
+
+// sweep drops expired keys every so often, so tokens that are never redeemed
+// and stale counters do not accumulate. The caller must hold mu.
+func (m *Memory) sweep(now time.Time) {
+	m.writes++
+	if m.writes%128 != 0 {
+		return
+	}
+	for k, e := range m.values {
+		if e.expired(now) {
+			delete(m.values, k)
+		}
+	}
+	for k, c := range m.counters {
+		if !c.expiresAt.IsZero() && now.After(c.expiresAt) {
+			delete(m.counters, k)
+		}
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.values[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (m *Memory) Take(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.values[key]
+	delete(m.values, key)
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.values, key)
+	delete(m.hashes, key)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) HUpdate(ctx context.Context, key string, set map[string][]byte, del []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.hashes[key]
+	if !ok {
+		h = map[string][]byte{}
+	}
+	for f, v := range set {
+		h[f] = v
+	}
+	for _, f := range del {
+		delete(h, f)
+	}
+	m.setHash(key, h)
+	return nil
+}
+
+func (m *Memory) HReplace(ctx context.Context, key string, fields map[string][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := make(map[string][]byte, len(fields))
+	for f, v := range fields {
+		h[f] = v
+	}
+	m.setHash(key, h)
+	return nil
+}
+
+// setHash stores h at key, dropping the key once h is empty as Redis does.
+// The caller must hold mu.
+func (m *Memory) setHash(key string, h map[string][]byte) {
+	if len(h) == 0 {
+		delete(m.hashes, key)
+		return
+	}
+	m.hashes[key] = h
+}
+
+func (m *Memory) HGetAll(ctx context.Context, key string) (map[string][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	fields := make(map[string][]byte, len(m.hashes[key]))
+	for f, v := range m.hashes[key] {
+		fields[f] = v
+	}
+	return fields, nil
+}
+
+func (m *Memory) Distributed() bool {
+	return false
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
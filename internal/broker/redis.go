@@ -0,0 +1,186 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis shares messages and state through a Redis server. Every key and
+// channel is namespaced with prefix so several deployments can share one
+// server.
+type Redis struct {
+	client *redis.Client
+	prefix string
+
+	mu       sync.RWMutex
+	pubsub   *redis.PubSub
+	handlers map[string][]func([]byte)
+	done     chan struct{}
+}
+
+// NewRedis connects to the server at url (redis://[:password@]host:port/db)
+// and verifies it with a PING.
+func NewRedis(url, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	r := &Redis{
+		client:   client,
+		prefix:   prefix + ":",
+		handlers: map[string][]func([]byte){},
+		done:     make(chan struct{}),
+	}
+	r.pubsub = client.Subscribe(context.Background())
+	go r.receive()
+	return r, nil
+}
+
+func (r *Redis) receive() {
+	defer close(r.done)
+
+	for msg := range r.pubsub.Channel() {
+		topic := msg.Channel[len(r.prefix):]
+		r.mu.RLock()
+		handlers := r.handlers[topic]
+		r.mu.RUnlock()
+
+		for _, h := range handlers {
+			h([]byte(msg.Payload))
+		}
+	}
+}
+
+func (r *Redis) Publish(ctx context.Context, topic string, payload []byte) error {
+	return r.client.Publish(ctx, r.prefix+topic, payload).Err()
+}
+
+func (r *Redis) Subscribe(topic string, handler func([]byte)) error {
+	r.mu.Lock()
+	_, subscribed := r.handlers[topic]
+	r.handlers[topic] = append(r.handlers[topic], handler)
+	r.mu.Unlock()
+
+	if subscribed {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.pubsub.Subscribe(ctx, r.prefix+topic)
+}
+
+// incrScript sets the expiry in the same step as the first increment, so a
+// failure between the two cannot leave a counter that never expires.
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (uint64, error) {
+	return incrScript.Run(ctx, r.client, []string{r.prefix + key}, ttl.Milliseconds()).Uint64()
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	return notFound(r.client.Get(ctx, r.prefix+key).Bytes())
+}
+
+func (r *Redis) Take(ctx context.Context, key string) ([]byte, error) {
+	return notFound(r.client.GetDel(ctx, r.prefix+key).Bytes())
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+func (r *Redis) HUpdate(ctx context.Context, key string, set map[string][]byte, del []string) error {
+	if len(set) == 0 && len(del) == 0 {
+		return nil
+	}
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		if len(set) > 0 {
+			p.HSet(ctx, r.prefix+key, hashValues(set))
+		}
+		if len(del) > 0 {
+			p.HDel(ctx, r.prefix+key, del...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) HReplace(ctx context.Context, key string, fields map[string][]byte) error {
+	_, err := r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, r.prefix+key)
+		if len(fields) > 0 {
+			p.HSet(ctx, r.prefix+key, hashValues(fields))
+		}
+		return nil
+	})
+	return err
+}
+
+func hashValues(fields map[string][]byte) map[string]interface{} {
+	values := make(map[string]interface{}, len(fields))
+	for f, v := range fields {
+		values[f] = v
+	}
+	return values
+}
+
+func (r *Redis) HGetAll(ctx context.Context, key string) (map[string][]byte, error) {
+	values, err := r.client.HGetAll(ctx, r.prefix+key).Result()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string][]byte, len(values))
+	for f, v := range values {
+		fields[f] = []byte(v)
+	}
+	return fields, nil
+}
+
+func (r *Redis) Distributed() bool {
+	return true
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *Redis) Close() error {
+	err := r.pubsub.Close()
+	<-r.done
+	if cerr := r.client.Close(); err == nil {
+		err = cerr
+	}
+	slog.Info("Redis broker closed")
+	return err
+}
+
+func notFound(b []byte, err error) ([]byte, error) {
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every server setting. Values are resolved in increasing order
+// of precedence: `default` tags, the YAML file, the environment (including
+// .env) and command-line flags.
+type Config struct {
+	Env         string `yaml:"env" env:"APP_ENV" default:"production"`
+	Port        int    `yaml:"port" env:"PORT" default:"3000"`
+	Storage     string `yaml:"storage" env:"STORAGE" default:"mongo"`
+	DatabaseURL string `yaml:"databaseUrl" env:"DATABASE_URL" secret:"url"`
+	// TrustedProxies may set X-Forwarded-For; with none the client IP is
+	// the peer address.
+	TrustedProxies []string `yaml:"trustedProxies" env:"TRUSTED_PROXIES"`
+
+	Mongo     Mongo     `yaml:"mongo"`
+	Auth      Auth      `yaml:"auth"`
+	CORS      CORS      `yaml:"cors"`
+	WebSocket WebSocket `yaml:"websocket"`
+	Broker    Broker    `yaml:"broker"`
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Admin     Admin     `yaml:"admin"`
+	Shutdown  Shutdown  `yaml:"shutdown"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Logging   Logging   `yaml:"logging"`
+	Tracing   Tracing   `yaml:"tracing"`
+}
+
+// Mongo configures the Mongo store. The client settings are passed to
+// database.Connect as they are.
+type Mongo struct {
+	AutoMigrate bool `yaml:"autoMigrate" env:"MONGO_AUTO_MIGRATE" default:"true"`
+
+	URI      string `yaml:"uri" env:"MONGODB_URI" secret:"url"`
+	Database string `yaml:"database" env:"MONGO_DB_NAME"`
+	AppName  string `yaml:"appName" env:"MONGO_APP_NAME"`
+
+	MaxPoolSize     uint64        `yaml:"maxPoolSize" env:"MONGO_MAX_POOL_SIZE"`
+	MinPoolSize     uint64        `yaml:"minPoolSize" env:"MONGO_MIN_POOL_SIZE"`
+	MaxConnIdleTime time.Duration `yaml:"maxConnIdleTime" env:"MONGO_MAX_CONN_IDLE_TIME"`
+
+	ConnectTimeout         time.Duration `yaml:"connectTimeout" env:"MONGO_CONNECT_TIMEOUT"`
+	ServerSelectionTimeout time.Duration `yaml:"serverSelectionTimeout" env:"MONGO_SERVER_SELECTION_TIMEOUT"`
+	SocketTimeout          time.Duration `yaml:"socketTimeout" env:"MONGO_SOCKET_TIMEOUT"`
+
+	ReadConcern    string        `yaml:"readConcern" env:"MONGO_READ_CONCERN"`       // local, majority, available, linearizable, snapshot
+	ReadPreference string        `yaml:"readPreference" env:"MONGO_READ_PREFERENCE"` // primary, primaryPreferred, secondary, ...
+	WriteConcern   string        `yaml:"writeConcern" env:"MONGO_WRITE_CONCERN"`     // "majority" or a number of nodes
+	WriteJournal   *bool         `yaml:"writeJournal" env:"MONGO_WRITE_JOURNAL"`
+	WriteTimeout   time.Duration `yaml:"writeConcernTimeout" env:"MONGO_WRITE_CONCERN_TIMEOUT"`
+
+	RetryWrites *bool `yaml:"retryWrites" env:"MONGO_RETRY_WRITES"`
+	RetryReads  *bool `yaml:"retryReads" env:"MONGO_RETRY_READS"`
+
+	TLS         bool   `yaml:"tls" env:"MONGO_TLS"`
+	TLSCAFile   string `yaml:"tlsCaFile" env:"MONGO_TLS_CA_FILE"`
+	TLSCertFile string `yaml:"tlsCertFile" env:"MONGO_TLS_CERT_FILE"`
+	TLSKeyFile  string `yaml:"tlsKeyFile" env:"MONGO_TLS_KEY_FILE"`
+	TLSInsecure bool   `yaml:"tlsInsecure" env:"MONGO_TLS_INSECURE"`
+}
+
+type Auth struct {
+	// Provider is one of auth0, oidc, jwks_file, local or dev.
+	Provider    string `yaml:"provider" env:"AUTH_PROVIDER" default:"auth0"`
+	Issuer      string `yaml:"issuer" env:"AUTH_ISSUER"`
+	Audience    string `yaml:"audience" env:"AUTH_AUDIENCE"`
+	RoleClaim   string `yaml:"roleClaim" env:"AUTH_ROLE_CLAIM" default:"role"`
+	JWKSFile    string `yaml:"jwksFile" env:"AUTH_JWKS_FILE"`
+	LocalSecret string `yaml:"localSecret" env:"AUTH_LOCAL_SECRET" secret:"true"`
+	DevSecret   string `yaml:"devSecret" env:"AUTH_DEV_SECRET" secret:"true"`
+
+	Auth0 Auth0 `yaml:"auth0"`
+}
+
+type Auth0 struct {
+	Domain        string `yaml:"domain" env:"AUTH0_DOMAIN"`
+	Audience      string `yaml:"audience" env:"AUTH0_AUDIENCE"`
+	Namespace     string `yaml:"namespace" env:"AUTH0_NAMESPACE"`
+	ClientID      string `yaml:"clientId" env:"AUTH0_CLIENT_ID"`
+	ClientSecret  string `yaml:"clientSecret" env:"AUTH0_CLIENT_SECRET" secret:"true"`
+	Connection    string `yaml:"connection" env:"AUTH0_CONNECTION" default:"Username-Password-Authentication"`
+	WebhookSecret string `yaml:"webhookSecret" env:"AUTH0_WEBHOOK_SECRET" secret:"true"`
+	FetchUserInfo bool   `yaml:"fetchUserInfo" env:"AUTH0_FETCH_USERINFO"`
+}
+
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowedOrigins" env:"CORS_ALLOWED_ORIGINS"`
+}
+
+type WebSocket struct {
+	AllowQueryToken bool `yaml:"allowQueryToken" env:"WS_ALLOW_QUERY_TOKEN"`
+	// ReplayBuffer is how many broadcasts each room keeps for clients that
+	// resume after a disconnect.
+	ReplayBuffer int `yaml:"replayBuffer" env:"WS_REPLAY_BUFFER" default:"256"`
+	// ResumeWindow is how long a resume token stays valid after the
+	// connection drops.
+	ResumeWindow time.Duration `yaml:"resumeWindow" env:"WS_RESUME_WINDOW" default:"2m"`
+	// PollTimeout is how long a long poll for class events waits for one
+	// to arrive before answering with none.
+	PollTimeout time.Duration `yaml:"pollTimeout" env:"WS_POLL_TIMEOUT" default:"25s"`
+	// DevicePolicy decides what happens when a user connects from more
+	// devices than MaxDevices: multiple (no limit), replace (close the
+	// oldest connection) or reject (refuse the new one).
+	DevicePolicy string `yaml:"devicePolicy" env:"WS_DEVICE_POLICY" default:"multiple"`
+	MaxDevices   int    `yaml:"maxDevices" env:"WS_MAX_DEVICES" default:"1"`
+	// MaxMessageBytes caps inbound frames; larger ones close the connection
+	// with 1009.
+	MaxMessageBytes int64 `yaml:"maxMessageBytes" env:"WS_MAX_MESSAGE_BYTES" default:"65536"`
+	// MaxConnsPerUser and MaxConnsPerIP bound concurrent connections on this
+	// instance. Zero disables the limit.
+	MaxConnsPerUser int `yaml:"maxConnsPerUser" env:"WS_MAX_CONNS_PER_USER" default:"10"`
+	MaxConnsPerIP   int `yaml:"maxConnsPerIp" env:"WS_MAX_CONNS_PER_IP" default:"100"`
+
+	RateLimit WSRateLimit `yaml:"rateLimit"`
+	Chat      WSChat      `yaml:"chat"`
+}
+
+// WSChat limits in-class chat. The default chat filter masks BlockedWords
+// with asterisks.
+type WSChat struct {
+	MaxLength    int      `yaml:"maxLength" env:"CHAT_MAX_LENGTH" default:"1000"`
+	BlockedWords []string `yaml:"blockedWords" env:"CHAT_BLOCKED_WORDS"`
+}
+
+// WSRateLimit throttles inbound events with token buckets. Limits are lists
+// of EVENT:rate:burst, where rate is events per second; events without their
+// own entry share the bucket of the event *.
+type WSRateLimit struct {
+	PerConnection []string `yaml:"perConnection" env:"WS_RATE_LIMIT_CONNECTION" default:"*:10:20,WEBRTC_ICE_CANDIDATE:50:100,TODAY_SUMMARY:0.2:2,CHAT_MESSAGE:1:5"`
+	PerUser       []string `yaml:"perUser" env:"WS_RATE_LIMIT_USER" default:"*:20:40,WEBRTC_ICE_CANDIDATE:100:200,TODAY_SUMMARY:0.2:2,CHAT_MESSAGE:2:10"`
+	// A connection that is throttled MaxViolations times within
+	// ViolationWindow is closed with 4005.
+	MaxViolations   int           `yaml:"maxViolations" env:"WS_RATE_LIMIT_MAX_VIOLATIONS" default:"20"`
+	ViolationWindow time.Duration `yaml:"violationWindow" env:"WS_RATE_LIMIT_VIOLATION_WINDOW" default:"1m"`
+}
+
+// EventLimit is a token bucket refilled at Rate per second up to Burst.
+type EventLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseEventLimits parses EVENT:rate:burst entries keyed by event.
+func ParseEventLimits(specs []string) (map[string]EventLimit, error) {
+	limits := make(map[string]EventLimit, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not EVENT:rate:burst", spec)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%q: rate must be a positive number", spec)
+		}
+		burst, err := strconv.Atoi(parts[2])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("%q: burst must be at least 1", spec)
+		}
+		limits[parts[0]] = EventLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+// Broker connects server instances so they share WebSocket rooms. The
+// memory broker keeps everything in process and supports a single instance.
+type Broker struct {
+	Kind     string `yaml:"kind" env:"BROKER" default:"memory"` // memory or redis
+	RedisURL string `yaml:"redisUrl" env:"REDIS_URL" secret:"url"`
+	// Prefix namespaces Redis keys and channels.
+	Prefix string `yaml:"prefix" env:"BROKER_PREFIX" default:"liveclassroom"`
+}
+
+// RateLimit throttles REST requests per route group.
+type RateLimit struct {
+	Enabled bool `yaml:"enabled" env:"RATE_LIMIT_ENABLED" default:"true"`
+	// Store is memory (counters per instance) or broker (counters shared
+	// through the configured broker).
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE" default:"memory"`
+	// Policies are GROUP:perUser:perIP:window entries; a zero limit is
+	// unlimited. Groups without an entry use the default policy.
+	Policies []string `yaml:"policies" env:"RATE_LIMIT_POLICIES" default:"default:120:600:1m,auth:60:300:1m,attendance:20:300:1m,ws:30:300:1m"`
+	// MaxBodyBytes caps request bodies; larger ones get 413.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes" env:"HTTP_MAX_BODY_BYTES" default:"1048576"`
+}
+
+// RoutePolicy allows PerUser requests per authenticated user and PerIP
+// requests per client address in each Window.
+type RoutePolicy struct {
+	PerUser int
+	PerIP   int
+	Window  time.Duration
+}
+
+// ParseRoutePolicies parses GROUP:perUser:perIP:window entries keyed by
+// group.
+func ParseRoutePolicies(specs []string) (map[string]RoutePolicy, error) {
+	policies := make(map[string]RoutePolicy, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 4 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not GROUP:perUser:perIP:window", spec)
+		}
+		perUser, err1 := strconv.Atoi(parts[1])
+		perIP, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || perUser < 0 || perIP < 0 {
+			return nil, fmt.Errorf("%q: limits must be non-negative integers", spec)
+		}
+		window, err := time.ParseDuration(parts[3])
+		if err != nil || window < time.Second {
+			return nil, fmt.Errorf("%q: window must be a duration of at least 1s", spec)
+		}
+		policies[parts[0]] = RoutePolicy{PerUser: perUser, PerIP: perIP, Window: window}
+	}
+	return policies, nil
+}
+
+type Admin struct {
+	// UserIDs may read admin endpoints in addition to users with the admin
+	// role.
+	UserIDs []string `yaml:"userIds" env:"ADMIN_USER_IDS"`
+}
+
+type Shutdown struct {
+	// Timeout bounds the whole shutdown, after which the process exits anyway.
+	Timeout        time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT" default:"30s"`
+	ReconnectAfter time.Duration `yaml:"reconnectAfter" env:"SHUTDOWN_RECONNECT_AFTER" default:"5s"`
+	// SessionMode is finalize (end the active session) or checkpoint (save
+	// marked attendance and leave it open for the next instance, which
+	// needs a distributed broker).
+	SessionMode string `yaml:"sessionMode" env:"SHUTDOWN_SESSION_MODE" default:"finalize"`
+}
+
+type Metrics struct {
+	Enabled bool   `yaml:"enabled" env:"METRICS_ENABLED" default:"true"`
+	Path    string `yaml:"path" env:"METRICS_PATH" default:"/metrics"`
+}
+
+type Logging struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" default:"info"`   // debug, info, warn or error
+	Format string `yaml:"format" env:"LOG_FORMAT" default:"json"` // json or text
+}
+
+// Tracing uses the standard OpenTelemetry variable names.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" default:"none"` // none, stdout or otlp
+	Endpoint    string  `yaml:"endpoint" env:"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" env:"OTEL_EXPORTER_OTLP_INSECURE"`
+	ServiceName string  `yaml:"serviceName" env:"OTEL_SERVICE_NAME" default:"liveclassroom"`
+	SampleRatio float64 `yaml:"sampleRatio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1"`
+}
+
+// Development reports whether APP_ENV is development.
+func (c *Config) Development() bool {
+	return c.Env == "development"
+}
+
+// Load builds the configuration from args (normally os.Args[1:]) and returns
+// the positional arguments left after the flags.
+func Load(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	envFile := fs.String("env-file", ".env", "dotenv file to load into the environment")
+	storage := fs.String("storage", "", "storage backend: mongo, postgres, sqlite or memory")
+	port := fs.Int("port", 0, "HTTP port")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// godotenv never overrides variables that are already set.
+	if err := godotenv.Load(*envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%s: %w", *envFile, err)
+	}
+
+	cfg := &Config{}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	if *storage != "" {
+		cfg.Storage = *storage
+	}
+	if *port != 0 {
+		cfg.Port = *port
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// Validate reports every missing or inconsistent setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("PORT must be between 1 and 65535")
+	}
+
+	switch c.Storage {
+	case "mongo":
+		if c.Mongo.URI == "" {
+			fail("MONGODB_URI is required for mongo storage")
+		}
+		if c.Mongo.MaxPoolSize > 0 && c.Mongo.MinPoolSize > c.Mongo.MaxPoolSize {
+			fail("MONGO_MIN_POOL_SIZE must not exceed MONGO_MAX_POOL_SIZE")
+		}
+	case "postgres", "sqlite":
+		if c.DatabaseURL == "" {
+			fail("DATABASE_URL is required for %s storage", c.Storage)
+		}
+	case "memory":
+	default:
+		fail("unknown storage backend %q", c.Storage)
+	}
+
+	a := c.Auth
+	switch a.Provider {
+	case "auth0":
+		if a.Auth0.Domain == "" {
+			fail("AUTH0_DOMAIN is required for the auth0 provider")
+		}
+		if a.Auth0.Audience == "" {
+			fail("AUTH0_AUDIENCE is required for the auth0 provider")
+		}
+	case "oidc":
+		if a.Issuer == "" {
+			fail("AUTH_ISSUER is required for the oidc provider")
+		}
+		if a.Audience == "" {
+			fail("AUTH_AUDIENCE is required for the oidc provider")
+		}
+	case "jwks_file":
+		if a.JWKSFile == "" {
+			fail("AUTH_JWKS_FILE is required for the jwks_file provider")
+		}
+		if a.Issuer == "" {
+			fail("AUTH_ISSUER is required for the jwks_file provider")
+		}
+		if a.Audience == "" {
+			fail("AUTH_AUDIENCE is required for the jwks_file provider")
+		}
+	case "local":
+		if len(a.LocalSecret) < 32 {
+			fail("AUTH_LOCAL_SECRET must be at least 32 characters")
+		}
+	case "dev":
+		if !c.Development() {
+			fail("AUTH_PROVIDER=dev mints tokens without authentication and requires APP_ENV=development")
+		}
+		if a.DevSecret == "" {
+			fail("AUTH_DEV_SECRET is required for the dev provider")
+		}
+	default:
+		fail("unknown AUTH_PROVIDER %q", a.Provider)
+	}
+
+	if c.WebSocket.ReplayBuffer < 1 {
+		fail("WS_REPLAY_BUFFER must be at least 1")
+	}
+	if c.WebSocket.ResumeWindow <= 0 {
+		fail("WS_RESUME_WINDOW must be positive")
+	}
+	if c.WebSocket.PollTimeout <= 0 {
+		fail("WS_POLL_TIMEOUT must be positive")
+	}
+	switch c.WebSocket.DevicePolicy {
+	case "multiple", "replace", "reject":
+	default:
+		fail("WS_DEVICE_POLICY must be multiple, replace or reject, got %q", c.WebSocket.DevicePolicy)
+	}
+	if c.WebSocket.MaxDevices < 1 {
+		fail("WS_MAX_DEVICES must be at least 1")
+	}
+	if c.WebSocket.MaxMessageBytes < 1024 {
+		fail("WS_MAX_MESSAGE_BYTES must be at least 1024")
+	}
+	if c.WebSocket.MaxConnsPerUser < 0 || c.WebSocket.MaxConnsPerIP < 0 {
+		fail("WS_MAX_CONNS_PER_USER and WS_MAX_CONNS_PER_IP must not be negative")
+	}
+	if _, err := ParseEventLimits(c.WebSocket.RateLimit.PerConnection); err != nil {
+		fail("WS_RATE_LIMIT_CONNECTION: %v", err)
+	}
+	if _, err := ParseEventLimits(c.WebSocket.RateLimit.PerUser); err != nil {
+		fail("WS_RATE_LIMIT_USER: %v", err)
+	}
+	if c.WebSocket.RateLimit.MaxViolations < 1 {
+		fail("WS_RATE_LIMIT_MAX_VIOLATIONS must be at least 1")
+	}
+	if c.WebSocket.RateLimit.ViolationWindow <= 0 {
+		fail("WS_RATE_LIMIT_VIOLATION_WINDOW must be positive")
+	}
+	if c.WebSocket.Chat.MaxLength < 1 {
+		fail("CHAT_MAX_LENGTH must be at least 1")
+	}
+
+	switch c.Broker.Kind {
+	case "memory":
+	case "redis":
+		if c.Broker.RedisURL == "" {
+			fail("REDIS_URL is required for the redis broker")
+		}
+	default:
+		fail("BROKER must be memory or redis, got %q", c.Broker.Kind)
+	}
+
+	switch c.RateLimit.Store {
+	case "memory":
+	case "broker":
+		if c.Broker.Kind == "memory" {
+			fail("RATE_LIMIT_STORE=broker needs a shared BROKER such as redis")
+		}
+	default:
+		fail("RATE_LIMIT_STORE must be memory or broker, got %q", c.RateLimit.Store)
+	}
+	if _, err := ParseRoutePolicies(c.RateLimit.Policies); err != nil {
+		fail("RATE_LIMIT_POLICIES: %v", err)
+	}
+	if c.RateLimit.MaxBodyBytes < 1024 {
+		fail("HTTP_MAX_BODY_BYTES must be at least 1024")
+	}
+
+	if c.Shutdown.Timeout <= 0 {
+		fail("SHUTDOWN_TIMEOUT must be positive")
+	}
+	if m := c.Shutdown.SessionMode; m != "finalize" && m != "checkpoint" {
+		fail("SHUTDOWN_SESSION_MODE must be finalize or checkpoint, got %q", m)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		fail("OTEL_TRACES_EXPORTER must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if r := c.Tracing.SampleRatio; r < 0 || r > 1 {
+		fail("OTEL_TRACES_SAMPLER_ARG must be between 0 and 1")
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("LOG_LEVEL must be debug, info, warn or error, got %q", c.Logging.Level)
+	}
+	if f := c.Logging.Format; f != "json" && f != "text" {
+		fail("LOG_FORMAT must be json or text, got %q", f)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func devConfig(t *testing.T, env, secret string) *Config {
+	t.Helper()
+	cfg := &Config{}
+	if err := applyDefaults(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Env = env
+	cfg.Storage = "memory"
+	cfg.Auth.Provider = "dev"
+	cfg.Auth.DevSecret = secret
+	return cfg
+}
+
+func TestValidateDevProvider(t *testing.T) {
+	if err := devConfig(t, "development", "s3cret").Validate(); err != nil {
+		t.Errorf("dev provider in development: %v", err)
+	}
+
+	err := devConfig(t, "production", "s3cret").Validate()
+	if err == nil || !strings.Contains(err.Error(), "APP_ENV=development") {
+		t.Errorf("dev provider outside development: got %v", err)
+	}
+
+	err = devConfig(t, "development", "").Validate()
+	if err == nil || !strings.Contains(err.Error(), "AUTH_DEV_SECRET") {
+		t.Errorf("dev provider without secret: got %v", err)
+	}
+}
+
+func TestValidateRequiresAudience(t *testing.T) {
+	for provider, want := range map[string][]string{
+		"oidc":      {"AUTH_AUDIENCE"},
+		"jwks_file": {"AUTH_AUDIENCE", "AUTH_ISSUER"},
+	} {
+		cfg := devConfig(t, "development", "")
+		cfg.Auth.Provider = provider
+		cfg.Auth.JWKSFile = "keys.json"
+		if provider == "oidc" {
+			cfg.Auth.Issuer = "https://issuer.test/"
+		}
+
+		err := cfg.Validate()
+		for _, setting := range want {
+			if err == nil || !strings.Contains(err.Error(), setting+" is required for the "+provider) {
+				t.Errorf("%s without %s: got %v", provider, setting, err)
+			}
+		}
+	}
+}
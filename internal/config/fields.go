@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// visit calls fn for every leaf field of the struct v points to, descending
+// into nested and embedded structs.
+func visit(v reflect.Value, fn func(f reflect.StructField, v reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			if err := visit(fv, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(f, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyDefaults(cfg *Config) error {
+	return visit(reflect.ValueOf(cfg).Elem(), func(f reflect.StructField, v reflect.Value) error {
+		if def, ok := f.Tag.Lookup("default"); ok {
+			if err := setField(v, def); err != nil {
+				return fmt.Errorf("default for %s: %w", f.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+func applyEnv(cfg *Config) error {
+	return visit(reflect.ValueOf(cfg).Elem(), func(f reflect.StructField, v reflect.Value) error {
+		key := f.Tag.Get("env")
+		if key == "" {
+			return nil
+		}
+		if raw := os.Getenv(key); raw != "" {
+			if err := setField(v, raw); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		return nil
+	})
+}
+
+func setField(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Ptr:
+		ptr := reflect.New(v.Type().Elem())
+		if err := setField(ptr.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(ptr)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Redacted returns the configuration keyed by YAML names with secrets masked
+// and passwords stripped from connection URLs. It is safe to log or serve.
+func (c *Config) Redacted() map[string]interface{} {
+	return redactStruct(reflect.ValueOf(c).Elem())
+}
+
+func redactStruct(v reflect.Value) map[string]interface{} {
+	out := map[string]interface{}{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), v.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if f.Type.Kind() == reflect.Struct {
+			nested := redactStruct(fv)
+			if opts == "inline" {
+				for k, val := range nested {
+					out[k] = val
+				}
+			} else {
+				out[name] = nested
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		switch f.Tag.Get("secret") {
+		case "true":
+			if fv.String() != "" {
+				out[name] = redacted
+			} else {
+				out[name] = ""
+			}
+		case "url":
+			out[name] = redactURL(fv.String())
+		default:
+			out[name] = fv.Interface()
+			if f.Type == durationType {
+				out[name] = time.Duration(fv.Int()).String()
+			}
+		}
+	}
+	return out
+}
+
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	return u.Redacted()
+}
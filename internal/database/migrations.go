@@ -0,0 +1,372 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one versioned schema change. Up must be safe to re-run, since
+// a crash between applying and recording a version will repeat it.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+type MigrationState struct {
+	Version     int        `bson:"_id" json:"version"`
+	Description string     `bson:"description" json:"description"`
+	AppliedAt   *time.Time `bson:"appliedAt,omitempty" json:"appliedAt,omitempty"`
+}
+
+// Migrations are applied in order and recorded in the migrations collection.
+// Never edit an entry once released; append a new one instead.
+var Migrations = []Migration{
+	{1, "users: unique auth0Id, email and role indexes", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("users").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "auth0Id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("auth0Id_unique")},
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetName("email")},
+			{Keys: bson.D{{Key: "role", Value: 1}}, Options: options.Index().SetName("role")},
+		})
+		return err
+	}},
+	{2, "classes: teacherId and studentIds indexes", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("classes").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "teacherId", Value: 1}}, Options: options.Index().SetName("teacherId")},
+			{Keys: bson.D{{Key: "studentIds", Value: 1}}, Options: options.Index().SetName("studentIds")},
+		})
+		return err
+	}},
+	{3, "attendance: drop duplicate rows and add unique {classId, studentId}", func(ctx context.Context, db *mongo.Database) error {
+		col := db.Collection("attendance")
+		if err := dedupeAttendance(ctx, col); err != nil {
+			return err
+		}
+		_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "classId", Value: 1}, {Key: "studentId", Value: 1}},
+				Options: options.Index().SetUnique(true).SetName("classId_studentId_unique"),
+			},
+			{Keys: bson.D{{Key: "sessionId", Value: 1}}, Options: options.Index().SetName("sessionId").SetSparse(true)},
+		})
+		return err
+	}},
+	{4, "sessions: {classId, startedAt} index", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "classId", Value: 1}, {Key: "startedAt", Value: -1}},
+			Options: options.Index().SetName("classId_startedAt"),
+		})
+		return err
+	}},
+	{5, "backfill: default roles, empty rosters and user createdAt", func(ctx context.Context, db *mongo.Database) error {
+		users := db.Collection("users")
+		if _, err := users.UpdateMany(ctx, bson.M{"role": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"role": ""}}); err != nil {
+			return err
+		}
+		if _, err := users.UpdateMany(ctx, bson.M{"createdAt": bson.M{"$exists": false}}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"createdAt": bson.M{"$toDate": "$_id"}}}},
+		}); err != nil {
+			return err
+		}
+		_, err := db.Collection("classes").UpdateMany(ctx,
+			bson.M{"$or": bson.A{bson.M{"studentIds": bson.M{"$exists": false}}, bson.M{"studentIds": nil}}},
+			bson.M{"$set": bson.M{"studentIds": bson.A{}}},
+		)
+		return err
+	}},
+	{6, "JSON schema validators for users, classes, sessions and attendance", func(ctx context.Context, db *mongo.Database) error {
+		for name, schema := range validators {
+			if err := applyValidator(ctx, db, name, schema); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		return nil
+	}},
+	{7, "chat_messages: {sessionId, _id} index", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("chat_messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "sessionId", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("sessionId_id"),
+		})
+		return err
+	}},
+	{8, "users: resolve shared emails and make non-empty email unique", func(ctx context.Context, db *mongo.Database) error {
+		users := db.Collection("users")
+		if err := dedupeEmails(ctx, users); err != nil {
+			return err
+		}
+		if _, err := users.Indexes().DropOne(ctx, "email"); err != nil && !isIndexNotFound(err) {
+			return err
+		}
+		_, err := users.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("email_unique").
+				SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+		})
+		return err
+	}},
+}
+
+// isIndexNotFound reports whether err is MongoDB's IndexNotFound (27).
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 27
+}
+
+var validators = map[string]bson.M{
+	"users": {
+		"bsonType": "object",
+		"required": bson.A{"auth0Id"},
+		"properties": bson.M{
+			"auth0Id":      bson.M{"bsonType": "string", "minLength": 1},
+			"name":         bson.M{"bsonType": "string"},
+			"email":        bson.M{"bsonType": "string"},
+			"role":         bson.M{"bsonType": "string"},
+			"passwordHash": bson.M{"bsonType": "string"},
+		},
+	},
+	"classes": {
+		"bsonType": "object",
+		"required": bson.A{"className", "teacherId", "studentIds"},
+		"properties": bson.M{
+			"className":    bson.M{"bsonType": "string", "minLength": 1},
+			"teacherId":    bson.M{"bsonType": "string", "minLength": 1},
+			"studentIds":   bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}},
+			"activeRoomId": bson.M{"bsonType": "string"},
+		},
+	},
+	"sessions": {
+		"bsonType": "object",
+		"required": bson.A{"classId", "teacherId", "roomId", "startedAt"},
+		"properties": bson.M{
+			"classId":   bson.M{"bsonType": "objectId"},
+			"teacherId": bson.M{"bsonType": "string"},
+			"roomId":    bson.M{"bsonType": "string"},
+			"startedAt": bson.M{"bsonType": "date"},
+			"endedAt":   bson.M{"bsonType": "date"},
+		},
+	},
+	"attendance": {
+		"bsonType": "object",
+		"required": bson.A{"classId", "studentId", "status"},
+		"properties": bson.M{
+			"classId":   bson.M{"bsonType": "objectId"},
+			"sessionId": bson.M{"bsonType": "objectId"},
+			"studentId": bson.M{"bsonType": "string", "minLength": 1},
+			"status":    bson.M{"enum": bson.A{"present", "absent"}},
+		},
+	},
+}
+
+// applyValidator installs a $jsonSchema validator, creating the collection
+// first if it does not exist yet. Level "moderate" leaves pre-existing
+// invalid documents editable.
+func applyValidator(ctx context.Context, db *mongo.Database, name string, schema bson.M) error {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	validator := bson.M{"$jsonSchema": schema}
+
+	if len(names) == 0 {
+		return db.CreateCollection(ctx, name, options.CreateCollection().
+			SetValidator(validator).
+			SetValidationLevel("moderate").
+			SetValidationAction("error"))
+	}
+
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "moderate"},
+		{Key: "validationAction", Value: "error"},
+	}).Err()
+}
+
+// dedupeAttendance keeps the newest record for each {classId, studentId}.
+func dedupeAttendance(ctx context.Context, col *mongo.Collection) error {
+	cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"classId": "$classId", "studentId": "$studentId"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	removed := 0
+	for cursor.Next(ctx) {
+		var group struct {
+			IDs bson.A `bson:"ids"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+		res, err := col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}})
+		if err != nil {
+			return err
+		}
+		removed += int(res.DeletedCount)
+	}
+	if removed > 0 {
+		slog.Info("removed duplicate attendance records", "count", removed)
+	}
+	return cursor.Err()
+}
+
+// dedupeEmails clears the email of users who share it with another account,
+// so the unique index can be built. A local account keeps its email, since
+// it logs in with it; otherwise the oldest account does. Emails shared by
+// several local accounts cannot be resolved safely and are reported instead.
+func dedupeEmails(ctx context.Context, col *mongo.Collection) error {
+	cursor, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"email": bson.M{"$gt": ""}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$email",
+			"users": bson.M{"$push": bson.M{"id": "$_id", "auth0Id": "$auth0Id"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var (
+		clear     bson.A
+		conflicts []string
+	)
+	for cursor.Next(ctx) {
+		var group struct {
+			Email string `bson:"_id"`
+			Users []struct {
+				ID      interface{} `bson:"id"`
+				Auth0ID string      `bson:"auth0Id"`
+			} `bson:"users"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return err
+		}
+
+		keep := 0
+		var local []string
+		for i, u := range group.Users {
+			if strings.HasPrefix(u.Auth0ID, "local|") {
+				if len(local) == 0 {
+					keep = i
+				}
+				local = append(local, u.Auth0ID)
+			}
+		}
+		if len(local) > 1 {
+			conflicts = append(conflicts, group.Email+" ("+strings.Join(local, ", ")+")")
+		}
+		for i, u := range group.Users {
+			if i != keep && !strings.HasPrefix(u.Auth0ID, "local|") {
+				clear = append(clear, u.ID)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(clear) > 0 {
+		res, err := col.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": clear}}, bson.M{"$set": bson.M{"email": ""}})
+		if err != nil {
+			return err
+		}
+		slog.Warn("cleared emails shared with another account", "count", res.ModifiedCount)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d emails are shared by several local accounts; change all but one and rerun: %s",
+			len(conflicts), strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// Migrate applies, in order, every migration not yet recorded as applied.
+func Migrate(ctx context.Context, db *mongo.Database) error {
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	col := db.Collection("migrations")
+	for _, m := range Migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		slog.Info("applying migration", "version", m.Version, "description", m.Description)
+		if err := m.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d: %w", m.Version, err)
+		}
+
+		now := time.Now().UTC()
+		_, err := col.UpdateOne(ctx,
+			bson.M{"_id": m.Version},
+			bson.M{"$set": bson.M{"description": m.Description, "appliedAt": now}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationStatus lists every known migration and when it was applied.
+func MigrationStatus(ctx context.Context, db *mongo.Database) ([]MigrationState, error) {
+	cursor, err := db.Collection("migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var recorded []MigrationState
+	if err := cursor.All(ctx, &recorded); err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]*time.Time{}
+	for _, r := range recorded {
+		appliedAt[r.Version] = r.AppliedAt
+	}
+
+	states := make([]MigrationState, 0, len(Migrations))
+	for _, m := range Migrations {
+		states = append(states, MigrationState{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   appliedAt[m.Version],
+		})
+	}
+	return states, nil
+}
+
+func appliedVersions(ctx context.Context, db *mongo.Database) (map[int]bool, error) {
+	states, err := MigrationStatus(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	for _, s := range states {
+		if s.AppliedAt != nil {
+			applied[s.Version] = true
+		}
+	}
+	return applied, nil
+}
@@ -2,29 +2,302 @@ package database
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/metrics"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/tracing"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
 var DB *mongo.Database
 
-func ConnectDB(uri string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Options configures the Mongo client. Zero values leave the setting to the
+// connection string, or to the driver default when the URI does not set it.
+type Options struct {
+	URI      string
+	Database string
+	AppName  string
+
+	MaxPoolSize     uint64
+	MinPoolSize     uint64
+	MaxConnIdleTime time.Duration
+
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+
+	ReadConcern    string // local, majority, available, linearizable, snapshot
+	ReadPreference string // primary, primaryPreferred, secondary, ...
+	WriteConcern   string // "majority" or a number of nodes
+	WriteJournal   *bool
+	WriteTimeout   time.Duration
+
+	RetryWrites *bool
+	RetryReads  *bool
+
+	TLS         bool
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSInsecure bool
+}
+
+// Connect opens the client, pings the primary and sets DB.
+func Connect(o Options) error {
+	clientOpts, err := o.clientOptions()
+	if err != nil {
+		return err
+	}
+
+	timeout := 10 * time.Second
+	if o.ConnectTimeout > 0 {
+		timeout = o.ConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		return err
 	}
 
-	if err := client.Ping(ctx, nil); err != nil {
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
 		return err
 	}
 
-	DB = client.Database("attendance")
-	log.Println("MongoDB connected successfully")
+	name := o.Database
+	if name == "" {
+		// Fall back to the database in the URI path, then to the
+		// historical default.
+		if cs, err := connstring.Parse(o.URI); err == nil {
+			name = cs.Database
+		}
+	}
+	if name == "" {
+		name = "attendance"
+	}
+
+	DB = client.Database(name)
+	slog.Info("MongoDB connected", "database", name)
 	return nil
 }
+
+func (o Options) clientOptions() (*options.ClientOptions, error) {
+	c := options.Client().ApplyURI(o.URI).
+		SetPoolMonitor(poolMonitor).
+		SetMonitor(commandMonitor)
+
+	if o.AppName != "" {
+		c.SetAppName(o.AppName)
+	}
+	if o.MaxPoolSize > 0 {
+		c.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		c.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.MaxConnIdleTime > 0 {
+		c.SetMaxConnIdleTime(o.MaxConnIdleTime)
+	}
+	if o.ConnectTimeout > 0 {
+		c.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.ServerSelectionTimeout > 0 {
+		c.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	}
+	if o.SocketTimeout > 0 {
+		c.SetSocketTimeout(o.SocketTimeout)
+	}
+
+	if o.ReadConcern != "" {
+		c.SetReadConcern(&readconcern.ReadConcern{Level: o.ReadConcern})
+	}
+	if o.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(o.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		c.SetReadPreference(rp)
+	}
+	if o.WriteConcern != "" || o.WriteJournal != nil || o.WriteTimeout > 0 {
+		wc := &writeconcern.WriteConcern{Journal: o.WriteJournal, WTimeout: o.WriteTimeout}
+		switch o.WriteConcern {
+		case "":
+		case "majority":
+			wc.W = "majority"
+		default:
+			n, err := strconv.Atoi(o.WriteConcern)
+			if err != nil {
+				return nil, fmt.Errorf("write concern must be \"majority\" or a number, got %q", o.WriteConcern)
+			}
+			wc.W = n
+		}
+		c.SetWriteConcern(wc)
+	}
+
+	if o.RetryWrites != nil {
+		c.SetRetryWrites(*o.RetryWrites)
+	}
+	if o.RetryReads != nil {
+		c.SetRetryReads(*o.RetryReads)
+	}
+
+	if o.TLS || o.TLSCAFile != "" || o.TLSCertFile != "" {
+		cfg, err := o.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		c.SetTLSConfig(cfg)
+	}
+
+	return c, c.Validate()
+}
+
+func (o Options) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.TLSInsecure,
+	}
+
+	if o.TLSCAFile != "" {
+		pem, err := os.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls ca file: no certificates found")
+		}
+		cfg.RootCAs = roots
+	}
+
+	if o.TLSCertFile != "" {
+		// The key may live in the certificate file, as mongod expects.
+		keyFile := o.TLSKeyFile
+		if keyFile == "" {
+			keyFile = o.TLSCertFile
+		}
+		cert, err := tls.LoadX509KeyPair(o.TLSCertFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// PoolStats are counters collected from the driver's pool events.
+type PoolStats struct {
+	Open        int64 `json:"open"`
+	InUse       int64 `json:"inUse"`
+	CheckoutErr int64 `json:"checkoutFailures"`
+	Cleared     int64 `json:"poolCleared"`
+}
+
+var pool struct {
+	open, inUse, checkoutErr, cleared atomic.Int64
+}
+
+var poolMonitor = &event.PoolMonitor{
+	Event: func(e *event.PoolEvent) {
+		switch e.Type {
+		case event.ConnectionCreated:
+			pool.open.Add(1)
+		case event.ConnectionClosed:
+			pool.open.Add(-1)
+		case event.GetSucceeded:
+			pool.inUse.Add(1)
+		case event.ConnectionReturned:
+			pool.inUse.Add(-1)
+		case event.GetFailed:
+			pool.checkoutErr.Add(1)
+		case event.PoolCleared:
+			pool.cleared.Add(1)
+		}
+	},
+}
+
+var mongoTracer tracing.MongoTracer
+
+// commandMonitor records per-command latency, failures and trace spans.
+var commandMonitor = &event.CommandMonitor{
+	Started: mongoTracer.Started,
+	Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+		mongoTracer.Succeeded(ctx, e)
+		metrics.DBDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+	},
+	Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+		mongoTracer.Failed(ctx, e)
+		metrics.DBDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+		metrics.DBErrors.WithLabelValues(e.CommandName).Inc()
+	},
+}
+
+// Health is the connection status reported by /health.
+type Health struct {
+	Status    string    `json:"status"`
+	Database  string    `json:"database"`
+	LatencyMS int64     `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	Pool      PoolStats `json:"pool"`
+}
+
+// Check pings the primary and reports pool usage.
+func Check(ctx context.Context) Health {
+	h := Health{
+		Status: "up",
+		Pool: PoolStats{
+			Open:        pool.open.Load(),
+			InUse:       pool.inUse.Load(),
+			CheckoutErr: pool.checkoutErr.Load(),
+			Cleared:     pool.cleared.Load(),
+		},
+	}
+	if DB == nil {
+		h.Status = "disconnected"
+		return h
+	}
+	h.Database = DB.Name()
+
+	start := time.Now()
+	if err := DB.Client().Ping(ctx, readpref.Primary()); err != nil {
+		h.Status = "down"
+		h.Error = err.Error()
+	}
+	h.LatencyMS = time.Since(start).Milliseconds()
+	return h
+}
+
+// Disconnect closes every pooled connection. It is safe to call when not
+// connected.
+func Disconnect(ctx context.Context) error {
+	if DB == nil {
+		return nil
+	}
+	err := DB.Client().Disconnect(ctx)
+	DB = nil
+	if err == nil {
+		slog.Info("MongoDB disconnected")
+	}
+	return err
+}
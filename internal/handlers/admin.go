@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// isAdmin reports whether the caller has the admin role or is listed in
+// ADMIN_USER_IDS.
+func isAdmin(c *gin.Context, cfg *config.Config) bool {
+	if c.GetString("role") == "admin" {
+		return true
+	}
+	userID := c.GetString("userId")
+	for _, id := range cfg.Admin.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfig returns the effective configuration with secrets redacted.
+func GetConfig(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(c, cfg) {
+			utils.ErrorResponse(c, 403, "Forbidden, admin access required")
+			return
+		}
+		utils.SuccessResponse(c, 200, cfg.Redacted())
+	}
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=teacher student"`
+}
+
+// SetUserRole promotes or demotes a user registered with the local provider.
+// AuthMiddleware reads the stored role on every request, so it applies to
+// tokens already issued; open WebSocket connections keep their role until
+// they reconnect. Other providers take the role from the token, so it must
+// be changed at the identity provider.
+func SetUserRole(cfg *config.Config, st *store.Store, p *users.Provisioner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(c, cfg) {
+			utils.ErrorResponse(c, 403, "Forbidden, admin access required")
+			return
+		}
+		if cfg.Auth.Provider != "local" {
+			utils.ErrorResponse(c, 501, "Roles are managed by the configured auth provider")
+			return
+		}
+
+		var req SetRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BindErrorResponse(c, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		user, err := st.Users.FindByAuth0ID(ctx, c.Param("id"))
+		if err != nil {
+			if err == store.ErrNotFound {
+				utils.ErrorResponse(c, 404, "User not found")
+				return
+			}
+			utils.ErrorResponse(c, 500, "Internal server error")
+			return
+		}
+
+		if err := p.Sync(ctx, models.User{Auth0ID: user.Auth0ID, Role: req.Role}); err != nil {
+			utils.ErrorResponse(c, 500, "Failed to update role")
+			return
+		}
+
+		utils.SuccessResponse(c, 200, gin.H{
+			"auth0Id": user.Auth0ID,
+			"name":    user.Name,
+			"email":   user.Email,
+			"role":    req.Role,
+		})
+	}
+}
@@ -5,20 +5,25 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type StartAttendanceRequest struct {
 	ClassID string `json:"classId" binding:"required"`
 }
 
-func StartAttendance(c *gin.Context) {
+func StartAttendance(st *store.Store, sess *session.State, hub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		startAttendance(c, st, sess, hub)
+	}
+}
+
+func startAttendance(c *gin.Context, st *store.Store, sess *session.State, hub *websocket.Hub) {
 	if c.GetString("role") != "teacher" {
 		utils.ErrorResponse(c, 403, "Forbidden, teacher access required")
 		return
@@ -26,7 +31,7 @@ func StartAttendance(c *gin.Context) {
 
 	var req StartAttendanceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.ErrorResponse(c, 400, "Invalid request schema")
+		utils.BindErrorResponse(c, err)
 		return
 	}
 
@@ -38,14 +43,12 @@ func StartAttendance(c *gin.Context) {
 
 	teacherID := c.GetString("userId")
 
-	classes := database.DB.Collection("classes")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var class models.Class
-	err = classes.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
+	class, err := st.Classes.FindByID(ctx, classID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == store.ErrNotFound {
 			utils.ErrorResponse(c, 404, "Class not found")
 			return
 		}
@@ -58,31 +61,52 @@ func StartAttendance(c *gin.Context) {
 		return
 	}
 
-	startedAt := time.Now().UTC().Format(time.RFC3339)
+	now := time.Now().UTC()
+	startedAt := now.Format(time.RFC3339)
 	roomID := primitive.NewObjectID().Hex()
 
-	_, err = classes.UpdateOne(ctx, bson.M{"_id": classID}, bson.M{
-		"$set": bson.M{"activeRoomId": roomID},
-	})
+	err = st.Classes.SetActiveRoom(ctx, classID, roomID)
 	if err != nil {
 		utils.ErrorResponse(c, 500, "Failed to create video room")
 		return
 	}
 
-	session.Set(&session.ActiveSession{
+	record := models.Session{
+		ClassID:   classID,
+		TeacherID: teacherID,
+		RoomID:    roomID,
+		StartedAt: now,
+	}
+	if err := st.Sessions.Create(ctx, &record); err != nil {
+		utils.ErrorResponse(c, 500, "Failed to create session")
+		return
+	}
+
+	sess.Set(&session.ActiveSession{
+		SessionID:  record.ID.Hex(),
 		ClassID:    req.ClassID,
+		TeacherID:  teacherID,
+		RoomID:     roomID,
 		StartedAt:  startedAt,
 		Attendance: map[string]string{},
 	})
+	hub.AnnounceSession(ctx)
 
 	utils.SuccessResponse(c, 200, gin.H{
+		"sessionId": record.ID.Hex(),
 		"classId":   req.ClassID,
 		"roomId":    roomID,
 		"startedAt": startedAt,
 	})
 }
 
-func GetMyAttendance(c *gin.Context) {
+func GetMyAttendance(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getMyAttendance(c, st)
+	}
+}
+
+func getMyAttendance(c *gin.Context, st *store.Store) {
 	if c.GetString("role") != "student" {
 		utils.ErrorResponse(c, 403, "Forbidden, student access required")
 		return
@@ -99,11 +123,9 @@ func GetMyAttendance(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	classes := database.DB.Collection("classes")
-	var class models.Class
-	err = classes.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
+	class, err := st.Classes.FindByID(ctx, classID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == store.ErrNotFound {
 			utils.ErrorResponse(c, 404, "Class not found")
 			return
 		}
@@ -123,15 +145,9 @@ func GetMyAttendance(c *gin.Context) {
 		return
 	}
 
-	attendanceCol := database.DB.Collection("attendance")
-	var attendance models.Attendance
-	err = attendanceCol.FindOne(ctx, bson.M{
-		"classId":   classID,
-		"studentId": userID,
-	}).Decode(&attendance)
-
+	attendance, err := st.Attendance.Find(ctx, classID, userID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == store.ErrNotFound {
 			utils.SuccessResponse(c, 200, gin.H{
 				"classId": classID.Hex(),
 				"status":  nil,
@@ -2,33 +2,284 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func Signup(c *gin.Context) {
-	utils.ErrorResponse(c, 400, "Signup is handled by Auth0")
+// SignupRequest has no role: self-registered users are always students and
+// are promoted by an admin.
+type SignupRequest struct {
+	Name     string `json:"name" binding:"required,max=100"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=6,max=72"`
 }
 
-func Login(c *gin.Context) {
-	utils.ErrorResponse(c, 400, "Login is handled by Auth0")
+const signupRole = "student"
+
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type DevTokenRequest struct {
+	UserID string `json:"userId" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=teacher student"`
+	Name   string `json:"name"`
+	Email  string `json:"email" binding:"omitempty,email"`
+}
+
+// UpdateProfileRequest changes the caller's profile. Local accounts log in
+// with their email, so changing it requires CurrentPassword.
+type UpdateProfileRequest struct {
+	Name            *string `json:"name" binding:"omitempty,max=100"`
+	Email           *string `json:"email" binding:"omitempty,email"`
+	CurrentPassword string  `json:"currentPassword"`
+}
+
+const localTokenTTL = 12 * time.Hour
+
+// Signup returns the signup handler for the configured provider. Local
+// users are created in st; Auth0 signups are synced to it through p.
+func Signup(cfg config.Auth, st *store.Store, a utils.Authenticator, p *users.Provisioner) gin.HandlerFunc {
+	auth0 := utils.NewAuth0Client(cfg.Auth0)
+	local := utils.LocalAuth(a)
+
+	return func(c *gin.Context) {
+		var req SignupRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BindErrorResponse(c, err)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		switch {
+		case local != nil:
+			signupLocal(c, ctx, req, st)
+		case cfg.Provider == "auth0":
+			signupAuth0(c, ctx, req, auth0, p)
+		default:
+			utils.ErrorResponse(c, 501, "Signup is not supported by the configured auth provider")
+		}
+	}
 }
 
-func Me(c *gin.Context) {
+func signupLocal(c *gin.Context, ctx context.Context, req SignupRequest, st *store.Store) {
+	_, err := st.Users.FindByEmail(ctx, req.Email)
+	if err == nil {
+		utils.ErrorResponse(c, 400, "Email already exists")
+		return
+	}
+	if err != store.ErrNotFound {
+		utils.ErrorResponse(c, 500, "Internal server error")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		utils.ErrorResponse(c, 500, "Internal server error")
+		return
+	}
+
+	now := time.Now().UTC()
+	id := primitive.NewObjectID()
+	user := models.User{
+		ID:           id,
+		Auth0ID:      "local|" + id.Hex(),
+		Name:         req.Name,
+		Email:        req.Email,
+		Role:         signupRole,
+		PasswordHash: string(hash),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// The unique email index catches a concurrent signup that passed the
+	// check above.
+	if err := st.Users.Create(ctx, &user); err != nil {
+		if err == store.ErrDuplicate {
+			utils.ErrorResponse(c, 400, "Email already exists")
+			return
+		}
+		utils.ErrorResponse(c, 500, "Failed to create user")
+		return
+	}
+
+	utils.SuccessResponse(c, 201, gin.H{
+		"_id":     user.ID,
+		"auth0Id": user.Auth0ID,
+		"name":    user.Name,
+		"email":   user.Email,
+		"role":    user.Role,
+	})
+}
+
+func signupAuth0(c *gin.Context, ctx context.Context, req SignupRequest, auth0 *utils.Auth0Client, p *users.Provisioner) {
+	userID, err := auth0.Signup(ctx, req.Name, req.Email, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, utils.ErrUserExists):
+			utils.ErrorResponse(c, 400, "Email already exists")
+		case errors.Is(err, utils.ErrSignupRejected):
+			utils.ErrorResponse(c, 400, "Signup rejected, check password requirements")
+		default:
+			logging.FromContext(c.Request.Context()).Error("auth0 signup failed", "error", err)
+			utils.ErrorResponse(c, 502, "Identity provider unavailable")
+		}
+		return
+	}
+
+	user := models.User{Auth0ID: userID, Name: req.Name, Email: req.Email, Role: signupRole}
+	if err := p.Sync(ctx, user); err != nil {
+		logging.FromContext(c.Request.Context()).Error("user sync after signup failed", "error", err)
+	}
+
+	utils.SuccessResponse(c, 201, gin.H{
+		"auth0Id": user.Auth0ID,
+		"name":    user.Name,
+		"email":   user.Email,
+		"role":    user.Role,
+	})
+}
+
+// Login returns the login handler for the configured provider. Local
+// passwords are checked against st and tokens minted with a.
+func Login(cfg config.Auth, st *store.Store, a utils.Authenticator) gin.HandlerFunc {
+	auth0 := utils.NewAuth0Client(cfg.Auth0)
+	local := utils.LocalAuth(a)
+
+	return func(c *gin.Context) {
+		var req LoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BindErrorResponse(c, err)
+			return
+		}
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		switch {
+		case local != nil:
+			loginLocal(c, ctx, req, st, local)
+		case cfg.Provider == "auth0":
+			loginAuth0(c, ctx, req, auth0)
+		default:
+			utils.ErrorResponse(c, 501, "Login is not supported by the configured auth provider")
+		}
+	}
+}
+
+func loginLocal(c *gin.Context, ctx context.Context, req LoginRequest, st *store.Store, local *utils.LocalAuthenticator) {
+	user, err := st.Users.FindByEmail(ctx, req.Email)
+	if err != nil && err != store.ErrNotFound {
+		utils.ErrorResponse(c, 500, "Internal server error")
+		return
+	}
+
+	if err == store.ErrNotFound || user.PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		utils.ErrorResponse(c, 401, "Invalid email or password")
+		return
+	}
+
+	token, err := local.Mint(utils.Claims{
+		UserID: user.Auth0ID,
+		Role:   user.Role,
+		Name:   user.Name,
+		Email:  user.Email,
+	}, localTokenTTL)
+	if err != nil {
+		utils.ErrorResponse(c, 500, "Failed to issue token")
+		return
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{
+		"token":     token,
+		"expiresIn": int(localTokenTTL.Seconds()),
+	})
+}
+
+func loginAuth0(c *gin.Context, ctx context.Context, req LoginRequest, auth0 *utils.Auth0Client) {
+	tokens, err := auth0.PasswordLogin(ctx, req.Email, req.Password)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidCredentials) {
+			utils.ErrorResponse(c, 401, "Invalid email or password")
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error("auth0 login failed", "error", err)
+		utils.ErrorResponse(c, 502, "Identity provider unavailable")
+		return
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{
+		"token":     tokens.AccessToken,
+		"idToken":   tokens.IDToken,
+		"expiresIn": tokens.ExpiresIn,
+	})
+}
+
+// DevToken mints a local token; only routed when AUTH_PROVIDER=dev.
+func DevToken(cfg config.Auth, a utils.Authenticator) gin.HandlerFunc {
+	dev := utils.LocalAuth(a)
+	return func(c *gin.Context) {
+		devToken(c, dev, cfg.Provider == "dev")
+	}
+}
+
+func devToken(c *gin.Context, dev *utils.LocalAuthenticator, enabled bool) {
+	if dev == nil || !enabled {
+		utils.ErrorResponse(c, 404, "Not found")
+		return
+	}
+
+	var req DevTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, err)
+		return
+	}
+
+	token, err := dev.Mint(utils.Claims{
+		UserID: req.UserID,
+		Role:   req.Role,
+		Name:   req.Name,
+		Email:  req.Email,
+	}, 12*time.Hour)
+	if err != nil {
+		utils.ErrorResponse(c, 500, "Failed to issue token")
+		return
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{"token": token})
+}
+
+func Me(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		me(c, st)
+	}
+}
+
+func me(c *gin.Context, st *store.Store) {
 	userID := c.GetString("userId")
 
-	collection := database.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"auth0Id": userID}).Decode(&user)
+	user, err := st.Users.FindByAuth0ID(ctx, userID)
 	if err != nil {
 		utils.SuccessResponse(c, 200, gin.H{
 			"auth0Id": userID,
@@ -38,7 +289,86 @@ func Me(c *gin.Context) {
 	}
 
 	utils.SuccessResponse(c, 200, gin.H{
-		"_id":     primitive.NewObjectID(),
+		"_id":     user.ID,
+		"auth0Id": user.Auth0ID,
+		"name":    user.Name,
+		"email":   user.Email,
+		"role":    user.Role,
+	})
+}
+
+func UpdateMe(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		updateMe(c, st)
+	}
+}
+
+func updateMe(c *gin.Context, st *store.Store) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, err)
+		return
+	}
+	if req.Name == nil && req.Email == nil {
+		utils.ErrorResponse(c, 400, "Invalid request schema")
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			utils.ErrorResponse(c, 400, "Invalid request schema")
+			return
+		}
+		req.Name = &name
+	}
+	if req.Email != nil {
+		email := strings.ToLower(strings.TrimSpace(*req.Email))
+		req.Email = &email
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userID := c.GetString("userId")
+	if req.Email != nil && strings.HasPrefix(userID, "local|") {
+		current, err := st.Users.FindByAuth0ID(ctx, userID)
+		if err != nil {
+			if err == store.ErrNotFound {
+				utils.ErrorResponse(c, 404, "User not found")
+				return
+			}
+			utils.ErrorResponse(c, 500, "Internal server error")
+			return
+		}
+		if *req.Email != current.Email {
+			if req.CurrentPassword == "" {
+				utils.ErrorResponse(c, 400, "currentPassword is required to change email")
+				return
+			}
+			if current.PasswordHash == "" ||
+				bcrypt.CompareHashAndPassword([]byte(current.PasswordHash), []byte(req.CurrentPassword)) != nil {
+				utils.ErrorResponse(c, 403, "Incorrect password")
+				return
+			}
+		}
+	}
+
+	user, err := st.Users.UpdateProfile(ctx, userID, req.Name, req.Email)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			utils.ErrorResponse(c, 404, "User not found")
+		case store.ErrDuplicate:
+			utils.ErrorResponse(c, 400, "Email already exists")
+		default:
+			utils.ErrorResponse(c, 500, "Failed to update profile")
+		}
+		return
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{
+		"_id":     user.ID,
 		"auth0Id": user.Auth0ID,
 		"name":    user.Name,
 		"email":   user.Email,
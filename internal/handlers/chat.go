@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultChatPage = 50
+	maxChatPage     = 200
+)
+
+// GetChatHistory returns a page of a session's chat, oldest first. Pass the
+// first message's _id as ?before= to fetch the page before it. The teacher
+// sees every message, including deleted ones; students see public messages
+// and their own private ones.
+func GetChatHistory(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getChatHistory(c, st)
+	}
+}
+
+func getChatHistory(c *gin.Context, st *store.Store) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, 400, "Invalid class ID")
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("sessionId"))
+	if err != nil {
+		utils.ErrorResponse(c, 400, "Invalid session ID")
+		return
+	}
+
+	q := store.ChatQuery{SessionID: sessionID, Limit: defaultChatPage}
+	if v := c.Query("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 || q.Limit > maxChatPage {
+			utils.ErrorResponse(c, 400, "limit must be between 1 and "+strconv.Itoa(maxChatPage))
+			return
+		}
+	}
+	if v := c.Query("before"); v != "" {
+		if q.Before, err = primitive.ObjectIDFromHex(v); err != nil {
+			utils.ErrorResponse(c, 400, "Invalid before message ID")
+			return
+		}
+	}
+
+	userID := c.GetString("userId")
+	role := c.GetString("role")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	class, err := st.Classes.FindByID(ctx, classID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.ErrorResponse(c, 404, "Class not found")
+			return
+		}
+		utils.ErrorResponse(c, 500, "Internal server error")
+		return
+	}
+
+	isTeacher := role == "teacher" && class.TeacherID == userID
+	isStudent := false
+	for _, sid := range class.StudentIDs {
+		if sid == userID {
+			isStudent = true
+			break
+		}
+	}
+
+	if !isTeacher && !isStudent {
+		utils.ErrorResponse(c, 403, "Forbidden, not authorized for this class")
+		return
+	}
+
+	sess, err := st.Sessions.FindByID(ctx, sessionID)
+	if err != nil || sess.ClassID != classID {
+		if err == nil || err == store.ErrNotFound {
+			utils.ErrorResponse(c, 404, "Session not found")
+			return
+		}
+		utils.ErrorResponse(c, 500, "Internal server error")
+		return
+	}
+
+	if !isTeacher {
+		q.Viewer = userID
+	}
+	q.IncludeDeleted = isTeacher
+
+	messages, err := st.Chat.List(ctx, q)
+	if err != nil {
+		utils.ErrorResponse(c, 500, "Failed to fetch chat history")
+		return
+	}
+	if messages == nil {
+		messages = []models.ChatMessage{}
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{"messages": messages})
+}
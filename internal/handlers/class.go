@@ -5,10 +5,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -20,7 +19,13 @@ type AddStudentRequest struct {
 	StudentID string `json:"studentId" binding:"required"`
 }
 
-func CreateClass(c *gin.Context) {
+func CreateClass(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		createClass(c, st)
+	}
+}
+
+func createClass(c *gin.Context, st *store.Store) {
 	role := c.GetString("role")
 	if role != "teacher" {
 		utils.ErrorResponse(c, 403, "Forbidden, teacher access required")
@@ -29,7 +34,7 @@ func CreateClass(c *gin.Context) {
 
 	var req CreateClassRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.ErrorResponse(c, 400, "Invalid request schema")
+		utils.BindErrorResponse(c, err)
 		return
 	}
 
@@ -42,11 +47,10 @@ func CreateClass(c *gin.Context) {
 		StudentIDs: []string{},
 	}
 
-	collection := database.DB.Collection("classes")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := collection.InsertOne(ctx, class)
+	err := st.Classes.Create(ctx, &class)
 	if err != nil {
 		utils.ErrorResponse(c, 500, "Failed to create class")
 		return
@@ -60,7 +64,13 @@ func CreateClass(c *gin.Context) {
 	})
 }
 
-func AddStudent(c *gin.Context) {
+func AddStudent(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		addStudent(c, st)
+	}
+}
+
+func addStudent(c *gin.Context, st *store.Store) {
 	role := c.GetString("role")
 	if role != "teacher" {
 		utils.ErrorResponse(c, 403, "Forbidden, teacher access required")
@@ -75,16 +85,14 @@ func AddStudent(c *gin.Context) {
 
 	var req AddStudentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		utils.ErrorResponse(c, 400, "Invalid request schema")
+		utils.BindErrorResponse(c, err)
 		return
 	}
 
-	collection := database.DB.Collection("classes")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var class models.Class
-	err = collection.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
+	class, err := st.Classes.FindByID(ctx, classID)
 	if err != nil {
 		utils.ErrorResponse(c, 404, "Class not found")
 		return
@@ -96,22 +104,12 @@ func AddStudent(c *gin.Context) {
 		return
 	}
 
-	_, err = collection.UpdateOne(
-		ctx,
-		bson.M{"_id": classID},
-		bson.M{"$addToSet": bson.M{"studentIds": req.StudentID}},
-	)
+	class, err = st.Classes.AddStudent(ctx, classID, req.StudentID)
 	if err != nil {
 		utils.ErrorResponse(c, 500, "Failed to add student")
 		return
 	}
 
-	err = collection.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
-	if err != nil {
-		utils.ErrorResponse(c, 500, "Failed to fetch updated class")
-		return
-	}
-
 	utils.SuccessResponse(c, 200, gin.H{
 		"_id":        class.ID,
 		"className":  class.ClassName,
@@ -120,7 +118,13 @@ func AddStudent(c *gin.Context) {
 	})
 }
 
-func GetClass(c *gin.Context) {
+func GetClass(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getClass(c, st)
+	}
+}
+
+func getClass(c *gin.Context, st *store.Store) {
 	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		utils.ErrorResponse(c, 400, "Invalid class ID")
@@ -130,12 +134,10 @@ func GetClass(c *gin.Context) {
 	userID := c.GetString("userId")
 	role := c.GetString("role")
 
-	collection := database.DB.Collection("classes")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var class models.Class
-	err = collection.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
+	class, err := st.Classes.FindByID(ctx, classID)
 	if err != nil {
 		utils.ErrorResponse(c, 404, "Class not found")
 		return
@@ -163,23 +165,27 @@ func GetClass(c *gin.Context) {
 	})
 }
 
-func GetStudents(c *gin.Context) {
+func GetStudents(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getStudents(c, st)
+	}
+}
+
+func getStudents(c *gin.Context, st *store.Store) {
 	role := c.GetString("role")
 	if role != "teacher" {
 		utils.ErrorResponse(c, 403, "Forbidden, teacher access required")
 		return
 	}
 
-	collection := database.DB.Collection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := collection.Find(ctx, bson.M{"role": "student"})
+	users, err := st.Users.ListByRole(ctx, "student")
 	if err != nil {
 		utils.ErrorResponse(c, 500, "Failed to fetch students")
 		return
 	}
-	defer cursor.Close(ctx)
 
 	type StudentResponse struct {
 		Auth0ID string `json:"auth0Id"`
@@ -188,15 +194,12 @@ func GetStudents(c *gin.Context) {
 	}
 
 	var students []StudentResponse
-	for cursor.Next(ctx) {
-		var user models.User
-		if err := cursor.Decode(&user); err == nil {
-			students = append(students, StudentResponse{
-				Auth0ID: user.Auth0ID,
-				Name:    user.Name,
-				Email:   user.Email,
-			})
-		}
+	for _, user := range users {
+		students = append(students, StudentResponse{
+			Auth0ID: user.Auth0ID,
+			Name:    user.Name,
+			Email:   user.Email,
+		})
 	}
 
 	utils.SuccessResponse(c, 200, students)
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ClassEvents streams a class's attendance and session events as
+// Server-Sent Events, for clients that cannot open a WebSocket.
+func ClassEvents(st *store.Store, hub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		classEvents(c, st, hub)
+	}
+}
+
+func classEvents(c *gin.Context, st *store.Store, hub *websocket.Hub) {
+	if classID, ok := classMember(c, st); ok {
+		hub.StreamClassEvents(c, classID.Hex(), c.GetString("userId"))
+	}
+}
+
+// PollClassEvents answers with a class's attendance and session events
+// after ?since=, waiting for one if there are none yet, for clients that
+// can hold neither a WebSocket nor a stream open.
+func PollClassEvents(st *store.Store, hub *websocket.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pollClassEvents(c, st, hub)
+	}
+}
+
+func pollClassEvents(c *gin.Context, st *store.Store, hub *websocket.Hub) {
+	if classID, ok := classMember(c, st); ok {
+		hub.PollClassEvents(c, classID.Hex(), c.GetString("userId"))
+	}
+}
+
+// classMember checks that the user is the teacher or a student of the class
+// in the path, and answers the request if not.
+func classMember(c *gin.Context, st *store.Store) (primitive.ObjectID, bool) {
+	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.ErrorResponse(c, 400, "Invalid class ID")
+		return classID, false
+	}
+
+	userID := c.GetString("userId")
+	role := c.GetString("role")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	class, err := st.Classes.FindByID(ctx, classID)
+	cancel()
+	if err != nil {
+		if err == store.ErrNotFound {
+			utils.ErrorResponse(c, 404, "Class not found")
+			return classID, false
+		}
+		utils.ErrorResponse(c, 500, "Internal server error")
+		return classID, false
+	}
+
+	isTeacher := role == "teacher" && class.TeacherID == userID
+	isStudent := false
+	for _, sid := range class.StudentIDs {
+		if sid == userID {
+			isStudent = true
+			break
+		}
+	}
+
+	if !isTeacher && !isStudent {
+		utils.ErrorResponse(c, 403, "Forbidden, not authorized for this class")
+		return classID, false
+	}
+	return classID, true
+}
@@ -0,0 +1,409 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/broker"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/routes"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var auth = utils.NewLocalAuthenticator("0123456789abcdef0123456789abcdef")
+
+// newDeps returns fresh in-memory services with no active session.
+func newDeps(t *testing.T, cfg *config.Config) routes.Deps {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemory()
+	sess := session.New()
+	hub, err := websocket.NewHub(websocket.Options{Store: st, Session: sess, Auth: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes.Deps{
+		Config:  cfg,
+		Store:   st,
+		Session: sess,
+		Auth:    auth,
+		Users:   users.NewProvisioner(cfg.Auth, st),
+		Hub:     hub,
+	}
+}
+
+// newRouter returns the class and attendance routes on fresh services.
+func newRouter(t *testing.T) (*gin.Engine, routes.Deps) {
+	t.Helper()
+	d := newDeps(t, &config.Config{Auth: config.Auth{Provider: "local"}})
+	r := gin.New()
+	routes.ClassRoutes(r, d)
+	routes.AttendanceRoutes(r, d)
+	return r, d
+}
+
+func token(t *testing.T, userID, role string) string {
+	t.Helper()
+	tok, err := auth.Mint(utils.Claims{UserID: userID, Role: role}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+type response struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+}
+
+// call sends a JSON request as userID and decodes the envelope. An empty
+// role sends no token.
+func call(t *testing.T, r *gin.Engine, method, path, userID, role string, body interface{}) (int, response) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if role != "" {
+		req.Header.Set("Authorization", "Bearer "+token(t, userID, role))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: invalid body %q", method, path, w.Body.String())
+	}
+	return w.Code, resp
+}
+
+func decode(t *testing.T, resp response, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		t.Fatalf("invalid data %s: %v", resp.Data, err)
+	}
+}
+
+type classData struct {
+	ID         string   `json:"_id"`
+	ClassName  string   `json:"className"`
+	TeacherID  string   `json:"teacherId"`
+	StudentIDs []string `json:"studentIds"`
+}
+
+func createClass(t *testing.T, r *gin.Engine, teacherID string) classData {
+	t.Helper()
+	code, resp := call(t, r, "POST", "/class", teacherID, "teacher", gin.H{"className": "Physics"})
+	if code != 201 {
+		t.Fatalf("create class: %d %s", code, resp.Error)
+	}
+	var class classData
+	decode(t, resp, &class)
+	return class
+}
+
+func TestCreateClass(t *testing.T) {
+	r, d := newRouter(t)
+
+	class := createClass(t, r, "t1")
+	if class.ClassName != "Physics" || class.TeacherID != "t1" || len(class.StudentIDs) != 0 {
+		t.Errorf("unexpected class %+v", class)
+	}
+	id, err := primitive.ObjectIDFromHex(class.ID)
+	if err != nil {
+		t.Fatalf("invalid class id %q", class.ID)
+	}
+	if _, err := d.Store.Classes.FindByID(t.Context(), id); err != nil {
+		t.Errorf("class not stored: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		role string
+		body interface{}
+		want int
+	}{
+		"no token":     {"", gin.H{"className": "Physics"}, 401},
+		"student":      {"student", gin.H{"className": "Physics"}, 403},
+		"missing name": {"teacher", gin.H{}, 400},
+	} {
+		if code, _ := call(t, r, "POST", "/class", "u1", tc.role, tc.body); code != tc.want {
+			t.Errorf("%s: got %d, want %d", name, code, tc.want)
+		}
+	}
+}
+
+func TestCreateClassBodyTooLarge(t *testing.T) {
+	r, _ := newRouter(t)
+	limited := gin.New()
+	limited.Use(middleware.BodyLimit(1024))
+	limited.Any("/*path", func(c *gin.Context) { r.HandleContext(c) })
+
+	// Without a Content-Length the body is only cut off while it is read.
+	body := `{"className":"` + strings.Repeat("x", 2048) + `"}`
+	req := httptest.NewRequest("POST", "/class", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token(t, "t1", "teacher"))
+	w := httptest.NewRecorder()
+	limited.ServeHTTP(w, req)
+	if w.Code != 413 {
+		t.Errorf("got %d %s, want 413", w.Code, w.Body.String())
+	}
+}
+
+func TestAddStudent(t *testing.T) {
+	r, _ := newRouter(t)
+	class := createClass(t, r, "t1")
+	path := "/class/" + class.ID + "/add-student"
+
+	for i := 0; i < 2; i++ {
+		code, resp := call(t, r, "POST", path, "t1", "teacher", gin.H{"studentId": "s1"})
+		if code != 200 {
+			t.Fatalf("add student: %d %s", code, resp.Error)
+		}
+		var got classData
+		decode(t, resp, &got)
+		if len(got.StudentIDs) != 1 || got.StudentIDs[0] != "s1" {
+			t.Errorf("add #%d: studentIds = %v, want [s1]", i+1, got.StudentIDs)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		path, userID, role string
+		want               int
+	}{
+		"other teacher": {path, "t2", "teacher", 403},
+		"student":       {path, "s1", "student", 403},
+		"bad id":        {"/class/nope/add-student", "t1", "teacher", 400},
+		"unknown class": {"/class/" + primitive.NewObjectID().Hex() + "/add-student", "t1", "teacher", 404},
+	} {
+		if code, _ := call(t, r, "POST", tc.path, tc.userID, tc.role, gin.H{"studentId": "s2"}); code != tc.want {
+			t.Errorf("%s: got %d, want %d", name, code, tc.want)
+		}
+	}
+}
+
+func TestStartAttendance(t *testing.T) {
+	r, d := newRouter(t)
+	class := createClass(t, r, "t1")
+
+	if code, _ := call(t, r, "POST", "/attendance/start", "t2", "teacher", gin.H{"classId": class.ID}); code != 403 {
+		t.Errorf("other teacher: got %d, want 403", code)
+	}
+	if code, _ := call(t, r, "POST", "/attendance/start", "t1", "teacher", gin.H{"classId": primitive.NewObjectID().Hex()}); code != 404 {
+		t.Errorf("unknown class: got %d, want 404", code)
+	}
+	if d.Session.Get() != nil {
+		t.Fatal("session started by a rejected request")
+	}
+
+	code, resp := call(t, r, "POST", "/attendance/start", "t1", "teacher", gin.H{"classId": class.ID})
+	if code != 200 {
+		t.Fatalf("start: %d %s", code, resp.Error)
+	}
+	var started struct {
+		SessionID string `json:"sessionId"`
+		ClassID   string `json:"classId"`
+		RoomID    string `json:"roomId"`
+	}
+	decode(t, resp, &started)
+
+	s := d.Session.Get()
+	if s == nil || s.SessionID != started.SessionID || s.ClassID != class.ID || s.TeacherID != "t1" {
+		t.Fatalf("active session %+v does not match %+v", s, started)
+	}
+	id, _ := primitive.ObjectIDFromHex(started.SessionID)
+	record, err := d.Store.Sessions.FindByID(t.Context(), id)
+	if err != nil {
+		t.Fatalf("session not stored: %v", err)
+	}
+	if record.RoomID != started.RoomID {
+		t.Errorf("stored room %q, response room %q", record.RoomID, started.RoomID)
+	}
+}
+
+func TestGetMyAttendance(t *testing.T) {
+	r, d := newRouter(t)
+	class := createClass(t, r, "t1")
+	call(t, r, "POST", "/class/"+class.ID+"/add-student", "t1", "teacher", gin.H{"studentId": "s1"})
+	path := "/class/" + class.ID + "/my-attendance"
+
+	status := func() interface{} {
+		t.Helper()
+		code, resp := call(t, r, "GET", path, "s1", "student", nil)
+		if code != 200 {
+			t.Fatalf("my-attendance: %d %s", code, resp.Error)
+		}
+		var data struct {
+			ClassID string      `json:"classId"`
+			Status  interface{} `json:"status"`
+		}
+		decode(t, resp, &data)
+		if data.ClassID != class.ID {
+			t.Errorf("classId = %q, want %q", data.ClassID, class.ID)
+		}
+		return data.Status
+	}
+
+	if got := status(); got != nil {
+		t.Errorf("before any session: status = %v, want null", got)
+	}
+
+	classID, _ := primitive.ObjectIDFromHex(class.ID)
+	err := d.Store.Attendance.Replace(t.Context(), []models.Attendance{{ClassID: classID, StudentID: "s1", Status: "present"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status(); got != "present" {
+		t.Errorf("status = %v, want present", got)
+	}
+
+	if code, _ := call(t, r, "GET", path, "s2", "student", nil); code != 403 {
+		t.Errorf("not enrolled: got %d, want 403", code)
+	}
+	if code, _ := call(t, r, "GET", path, "t1", "teacher", nil); code != 403 {
+		t.Errorf("teacher: got %d, want 403", code)
+	}
+}
+
+func TestUpdateMeEmail(t *testing.T) {
+	d := newDeps(t, &config.Config{Auth: config.Auth{Provider: "local"}})
+	st := d.Store
+	r := gin.New()
+	routes.AuthRoutes(r, d)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := models.User{Auth0ID: "local|1", Name: "Ada", Email: "ada@example.com", Role: "student", PasswordHash: string(hash)}
+	if err := st.Users.Create(t.Context(), &u); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		body gin.H
+		want int
+	}{
+		"no password":    {gin.H{"email": "eve@example.com"}, 400},
+		"wrong password": {gin.H{"email": "eve@example.com", "currentPassword": "guess"}, 403},
+		"same email":     {gin.H{"email": "ADA@example.com"}, 200},
+		"name only":      {gin.H{"name": "Ada L"}, 200},
+	} {
+		if code, resp := call(t, r, "PATCH", "/auth/me", "local|1", "student", tc.body); code != tc.want {
+			t.Errorf("%s: got %d %s, want %d", name, code, resp.Error, tc.want)
+		}
+	}
+	if got, _ := st.Users.FindByAuth0ID(t.Context(), "local|1"); got.Email != "ada@example.com" {
+		t.Fatalf("email changed to %q without the password", got.Email)
+	}
+
+	code, resp := call(t, r, "PATCH", "/auth/me", "local|1", "student", gin.H{"email": "ada@new.example.com", "currentPassword": "secret123"})
+	if code != 200 {
+		t.Fatalf("with password: %d %s", code, resp.Error)
+	}
+	if got, _ := st.Users.FindByAuth0ID(t.Context(), "local|1"); got.Email != "ada@new.example.com" {
+		t.Errorf("email = %q, want ada@new.example.com", got.Email)
+	}
+
+	// Accounts that log in elsewhere have no password here.
+	if code, _ := call(t, r, "PATCH", "/auth/me", "auth0|1", "student", gin.H{"email": "x@example.com"}); code == 400 || code == 403 {
+		t.Errorf("auth0 account: got %d, want no password check", code)
+	}
+}
+
+// /auth is limited per IP for signup and login; /auth/me also gets the
+// group's per-user limit once the token is verified.
+func TestAuthMeLimitedPerUser(t *testing.T) {
+	d := newDeps(t, &config.Config{Auth: config.Auth{Provider: "local"}})
+	limits, err := middleware.NewRateLimiter(config.RateLimit{Enabled: true, Policies: []string{"auth:2:100:1m"}}, broker.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Limits = limits
+	r := gin.New()
+	routes.AuthRoutes(r, d)
+
+	for i := 0; i < 2; i++ {
+		if code, resp := call(t, r, "GET", "/auth/me", "u1", "student", nil); code != 200 {
+			t.Fatalf("request %d: %d %s", i+1, code, resp.Error)
+		}
+	}
+	if code, _ := call(t, r, "GET", "/auth/me", "u1", "student", nil); code != 429 {
+		t.Errorf("third request: got %d, want 429", code)
+	}
+	if code, _ := call(t, r, "GET", "/auth/me", "u2", "student", nil); code != 200 {
+		t.Errorf("other user: got %d, want 200", code)
+	}
+}
+
+// With the local provider a demotion applies to tokens issued before it.
+func TestLocalStoredRoleOverridesToken(t *testing.T) {
+	r, d := newRouter(t)
+	u := models.User{Auth0ID: "local|1", Name: "Ada", Email: "ada@example.com", Role: "student"}
+	if err := d.Store.Users.Create(t.Context(), &u); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := call(t, r, "POST", "/class", "local|1", "teacher", gin.H{"className": "Physics"}); code != 403 {
+		t.Errorf("teacher token for a stored student: got %d, want 403", code)
+	}
+}
+
+// Readiness is 503 with the failing component's detail until every
+// component is up, while liveness checks nothing.
+func TestReadiness(t *testing.T) {
+	d := newDeps(t, &config.Config{Storage: "memory", Auth: config.Auth{Provider: "local"}})
+	r := gin.New()
+	routes.HealthRoutes(r, d)
+
+	if code, _ := call(t, r, "GET", "/health/live", "", "", nil); code != 200 {
+		t.Errorf("liveness = %d, want 200", code)
+	}
+
+	var health struct {
+		Status     string `json:"status"`
+		Components map[string]struct {
+			Status string `json:"status"`
+		} `json:"components"`
+	}
+	code, resp := call(t, r, "GET", "/health/ready", "", "", nil)
+	decode(t, resp, &health)
+	if code != 503 || health.Status != "unready" || health.Components["scheduler"].Status != "stopped" {
+		t.Errorf("readiness before the scheduler runs = %d %+v, want 503 with the scheduler stopped", code, health)
+	}
+
+	d.Hub.StartScheduler()
+	t.Cleanup(func() { d.Hub.Shutdown(context.Background(), websocket.ShutdownOptions{}) })
+	code, resp = call(t, r, "GET", "/health/ready", "", "", nil)
+	decode(t, resp, &health)
+	if code != 200 || health.Status != "ready" {
+		t.Errorf("readiness = %d %+v, want 200", code, health)
+	}
+	for _, name := range []string{"database", "auth", "scheduler", "websocket", "broker"} {
+		if health.Components[name].Status != "up" {
+			t.Errorf("%s = %+v, want up", name, health.Components[name])
+		}
+	}
+}
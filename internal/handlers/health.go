@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/websocket"
+)
+
+var startedAt = time.Now()
+
+// componentCheck returns the component's status detail and whether it is up.
+type componentCheck func(ctx context.Context) (interface{}, bool)
+
+// Liveness only reports that the process is serving requests. Orchestrators
+// should restart the process when it fails, so it checks no dependencies.
+func Liveness(c *gin.Context) {
+	utils.SuccessResponse(c, 200, gin.H{
+		"status":        "alive",
+		"uptimeSeconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// Readiness checks every dependency the server needs to handle traffic and
+// returns 503 with per-component detail if any of them is down.
+func Readiness(cfg *config.Config, st *store.Store, a utils.Authenticator, hub *websocket.Hub) gin.HandlerFunc {
+	checks := map[string]componentCheck{
+		"database": databaseCheck(cfg.Storage, st),
+		"auth": func(context.Context) (interface{}, bool) {
+			h := utils.CheckAuth(a, cfg.Auth.Provider)
+			return h, h.Status == "up"
+		},
+		"scheduler": func(context.Context) (interface{}, bool) { h := hub.CheckScheduler(); return h, h.Status == "up" },
+		"websocket": func(context.Context) (interface{}, bool) { h := hub.CheckHub(); return h, h.Status == "up" },
+		"broker": func(ctx context.Context) (interface{}, bool) {
+			h := hub.CheckBroker(ctx)
+			return h, h.Status == "up"
+		},
+	}
+
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		var (
+			mu         sync.Mutex
+			wg         sync.WaitGroup
+			ready      = true
+			components = gin.H{}
+		)
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check componentCheck) {
+				defer wg.Done()
+				detail, up := check(ctx)
+				mu.Lock()
+				components[name] = detail
+				ready = ready && up
+				mu.Unlock()
+			}(name, check)
+		}
+		wg.Wait()
+
+		data := gin.H{"status": "ready", "components": components}
+		if !ready {
+			data["status"] = "unready"
+			c.JSON(503, gin.H{"success": false, "data": data})
+			return
+		}
+		utils.SuccessResponse(c, 200, data)
+	}
+}
+
+func databaseCheck(storage string, st *store.Store) componentCheck {
+	if storage == "mongo" {
+		return func(ctx context.Context) (interface{}, bool) {
+			h := database.Check(ctx)
+			return h, h.Status == "up"
+		}
+	}
+	return func(ctx context.Context) (interface{}, bool) {
+		h := gin.H{"status": "up", "storage": storage}
+		start := time.Now()
+		if st == nil {
+			h["status"] = "disconnected"
+			return h, false
+		}
+		if err := st.Ping(ctx); err != nil {
+			h["status"] = "down"
+			h["error"] = err.Error()
+			return h, false
+		}
+		h["latencyMs"] = time.Since(start).Milliseconds()
+		return h, true
+	}
+}
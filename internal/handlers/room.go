@@ -5,15 +5,18 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func GetRoomInfo(c *gin.Context) {
+func GetRoomInfo(st *store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getRoomInfo(c, st)
+	}
+}
+
+func getRoomInfo(c *gin.Context, st *store.Store) {
 	classID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
 		utils.ErrorResponse(c, 400, "Invalid class ID")
@@ -25,11 +28,9 @@ func GetRoomInfo(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	classes := database.DB.Collection("classes")
-	var class models.Class
-	err = classes.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
+	class, err := st.Classes.FindByID(ctx, classID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == store.ErrNotFound {
 			utils.ErrorResponse(c, 404, "Class not found")
 			return
 		}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// PostLoginWebhookRequest is the body sent by the Auth0 post-login Action.
+type PostLoginWebhookRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Role   string `json:"role" binding:"omitempty,oneof=teacher student"`
+}
+
+// PostLoginWebhook returns the handler for the Auth0 post-login Action,
+// authenticated with the shared secret. Profiles are synced through p.
+func PostLoginWebhook(secret string, p *users.Provisioner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		postLoginWebhook(c, secret, p)
+	}
+}
+
+func postLoginWebhook(c *gin.Context, secret string, p *users.Provisioner) {
+	if secret == "" {
+		utils.ErrorResponse(c, 503, "Webhook not configured")
+		return
+	}
+
+	provided := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+		utils.ErrorResponse(c, 401, "Unauthorized, invalid webhook secret")
+		return
+	}
+
+	var req PostLoginWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BindErrorResponse(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := p.Sync(ctx, models.User{
+		Auth0ID: req.UserID,
+		Name:    strings.TrimSpace(req.Name),
+		Email:   strings.ToLower(strings.TrimSpace(req.Email)),
+		Role:    req.Role,
+	})
+	if err != nil {
+		utils.ErrorResponse(c, 500, "Failed to sync user")
+		return
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{"auth0Id": req.UserID})
+}
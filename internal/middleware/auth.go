@@ -1,13 +1,19 @@
 package middleware
 
 import (
+	"context"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware validates the bearer token with a and provisions its
+// subject with p.
+func AuthMiddleware(a utils.Authenticator, p *users.Provisioner) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -20,13 +26,23 @@ func AuthMiddleware() gin.HandlerFunc {
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		tokenString = strings.TrimSpace(tokenString)
 
-		claims, err := utils.ValidateToken(tokenString)
+		claims, err := a.ValidateToken(tokenString)
 		if err != nil {
 			utils.ErrorResponse(c, 401, "Unauthorized, token missing or invalid")
 			c.Abort()
 			return
 		}
 
+		l := logging.FromContext(c.Request.Context()).With("user_id", claims.UserID)
+		c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), l))
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		if err := p.Provision(ctx, tokenString, claims); err != nil {
+			l.Error("user provisioning failed", "error", err)
+		}
+		cancel()
+
+		c.Set("claims", claims)
 		c.Set("userId", claims.UserID)
 		c.Set("role", claims.Role)
 		c.Next()
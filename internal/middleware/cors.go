@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// OriginPolicy decides which browser origins may call the API or open a
+// WebSocket. Same-origin requests and clients that send no Origin header
+// (curl, native apps) are always allowed.
+type OriginPolicy struct {
+	allowAll  bool
+	origins   map[string]bool
+	wildcards []string
+}
+
+// NewOriginPolicy builds the policy from the configured allow-list.
+// Development mode allows every origin; otherwise only the listed origins
+// are allowed. Entries may use a leading wildcard such as
+// https://*.school.edu.
+func NewOriginPolicy(allowed []string, development bool) *OriginPolicy {
+	p := &OriginPolicy{origins: map[string]bool{}}
+
+	for _, o := range allowed {
+		o = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(o), "/"))
+		switch {
+		case o == "":
+		case o == "*":
+			p.allowAll = true
+		case strings.Contains(o, "://*."):
+			p.wildcards = append(p.wildcards, o)
+		default:
+			p.origins[o] = true
+		}
+	}
+
+	if development {
+		p.allowAll = true
+	}
+	if p.allowAll {
+		slog.Warn("CORS: all origins allowed")
+	}
+	return p
+}
+
+// AllowRequest reports whether r comes from an acceptable origin.
+func (p *OriginPolicy) AllowRequest(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.allowAll {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.allowOrigin(strings.ToLower(origin))
+}
+
+func (p *OriginPolicy) allowOrigin(origin string) bool {
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		scheme, domain, _ := strings.Cut(w, "://*.")
+		if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS applies the origin policy to REST requests and answers preflights.
+func CORS(p *OriginPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		if !p.AllowRequest(c.Request) {
+			utils.ErrorResponse(c, 403, "Forbidden, origin not allowed")
+			c.Abort()
+			return
+		}
+
+		h := c.Writer.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+
+		// Clients may send their own request ID and read the one echoed
+		// back, so logs can be correlated from the browser.
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+requestIDHeader)
+			h.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", requestIDHeader)
+
+		c.Next()
+	}
+}
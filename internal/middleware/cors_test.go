@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Listed and wildcard origins get CORS headers, other origins are refused,
+// and preflights are answered without reaching the route.
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORS(NewOriginPolicy([]string{"https://app.example.edu", "https://*.school.edu"}, false)))
+	r.GET("/class", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.OPTIONS("/class", func(c *gin.Context) { t.Error("preflight reached the route") })
+
+	serve := func(method, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://api.example.edu/class", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, origin := range []string{"https://app.example.edu", "https://north.school.edu"} {
+		w := serve(http.MethodGet, origin)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("%s: %d with Access-Control-Allow-Origin %q", origin, w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	for _, origin := range []string{"https://evil.example.com", "http://north.school.edu"} {
+		w := serve(http.MethodGet, origin)
+		if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: %d with Access-Control-Allow-Origin %q, want 403 without it", origin, w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	if w := serve(http.MethodGet, ""); w.Code != http.StatusOK {
+		t.Errorf("request without Origin = %d, want 200", w.Code)
+	}
+
+	w := serve(http.MethodOptions, "https://app.example.edu")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") == "" ||
+		w.Header().Get("Access-Control-Allow-Headers") == "" || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.edu" {
+		t.Errorf("preflight = %d %v", w.Code, w.Header())
+	}
+	if w := serve(http.MethodOptions, "https://evil.example.com"); w.Code != http.StatusForbidden {
+		t.Errorf("preflight from a refused origin = %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+)
+
+// Every line logged while handling a request carries its request ID, which
+// is taken from X-Request-ID when valid and echoed back.
+func TestRequestIDCorrelatesLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	r := gin.New()
+	r.Use(RequestID(), AccessLog())
+	r.GET("/class/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("handling")
+		c.Status(http.StatusOK)
+	})
+
+	// An empty want expects a generated id.
+	for _, tc := range []struct{ sent, want string }{
+		{"abc-123", "abc-123"},
+		{"not a valid id", ""},
+	} {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/class/42", nil)
+		req.Header.Set(requestIDHeader, tc.sent)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if tc.want == "" && (id == "" || id == tc.sent) {
+			t.Errorf("sent %q, echoed %q, want a generated id", tc.sent, id)
+		} else if tc.want != "" && id != tc.want {
+			t.Errorf("sent %q, echoed %q", tc.sent, id)
+		}
+
+		var lines []map[string]interface{}
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var line map[string]interface{}
+			if err := dec.Decode(&line); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) != 2 {
+			t.Fatalf("logged %d lines, want the handler's and the access log", len(lines))
+		}
+		for _, line := range lines {
+			if line["request_id"] != id {
+				t.Errorf("line %v has request_id %v, want %s", line["msg"], line["request_id"], id)
+			}
+		}
+		if access := lines[1]; access["route"] != "/class/:id" || access["status"] != float64(200) {
+			t.Errorf("access log = %v", access)
+		}
+	}
+}
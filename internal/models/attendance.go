@@ -5,6 +5,7 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 type Attendance struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
 	ClassID   primitive.ObjectID `bson:"classId" json:"classId"`
+	SessionID primitive.ObjectID `bson:"sessionId,omitempty" json:"sessionId,omitempty"`
 	StudentID string             `bson:"studentId" json:"studentId"`
 	Status    string             `bson:"status" json:"status"`
 }
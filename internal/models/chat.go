@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChatMessage is one message sent in a session's chat. RecipientID is set
+// on private messages. Deleted messages are kept for the teacher.
+type ChatMessage struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	SessionID   primitive.ObjectID `bson:"sessionId" json:"sessionId"`
+	ClassID     primitive.ObjectID `bson:"classId" json:"classId"`
+	SenderID    string             `bson:"senderId" json:"senderId"`
+	SenderName  string             `bson:"senderName" json:"senderName"`
+	SenderRole  string             `bson:"senderRole" json:"senderRole"`
+	RecipientID string             `bson:"recipientId,omitempty" json:"recipientId,omitempty"`
+	Text        string             `bson:"text" json:"text"`
+	SentAt      time.Time          `bson:"sentAt" json:"sentAt"`
+	DeletedAt   *time.Time         `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy   string             `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ClassID   primitive.ObjectID `bson:"classId" json:"classId"`
+	TeacherID string             `bson:"teacherId" json:"teacherId"`
+	RoomID    string             `bson:"roomId" json:"roomId"`
+	StartedAt time.Time          `bson:"startedAt" json:"startedAt"`
+	EndedAt   *time.Time         `bson:"endedAt,omitempty" json:"endedAt,omitempty"`
+	// HandRaises is the session's participation record.
+	HandRaises []HandRaise `bson:"handRaises,omitempty" json:"handRaises,omitempty"`
+}
+
+// HandRaise is a student's raised hand and how it was resolved: lowered,
+// acknowledged, cleared, or unanswered when the session ended first. Outcome
+// is empty for a raise still open at a checkpoint.
+type HandRaise struct {
+	StudentID  string     `bson:"studentId" json:"studentId"`
+	RaisedAt   time.Time  `bson:"raisedAt" json:"raisedAt"`
+	ResolvedAt *time.Time `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	Outcome    string     `bson:"outcome" json:"outcome"`
+}
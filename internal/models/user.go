@@ -1,11 +1,19 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type User struct {
-	ID      primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	Auth0ID string             `bson:"auth0Id" json:"auth0Id"`
-	Name    string             `bson:"name" json:"name"`
-	Email   string             `bson:"email" json:"email"`
-	Role    string             `bson:"role" json:"role"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Auth0ID      string             `bson:"auth0Id" json:"auth0Id"`
+	Name         string             `bson:"name" json:"name"`
+	Email        string             `bson:"email" json:"email"`
+	Role         string             `bson:"role" json:"role"`
+	PasswordHash string             `bson:"passwordHash,omitempty" json:"-"` // local provider only
+	CreatedAt    time.Time          `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+	UpdatedAt    time.Time          `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+	LastLoginAt  time.Time          `bson:"lastLoginAt,omitempty" json:"lastLoginAt,omitempty"`
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/handlers"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
+)
+
+func AdminRoutes(r *gin.Engine, d Deps) {
+	admin := r.Group("/admin", middleware.Authenticated(d.Limits, "admin", middleware.AuthMiddleware(d.Auth, d.Users))...)
+	{
+		admin.GET("/config", handlers.GetConfig(d.Config))
+		admin.PUT("/users/:id/role", handlers.SetUserRole(d.Config, d.Store, d.Users))
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
 )
 
-func AttendanceRoutes(r *gin.Engine) {
-	r.POST("/attendance/start", middleware.AuthMiddleware(), handlers.StartAttendance)
-	r.GET("/class/:id/my-attendance", middleware.AuthMiddleware(), handlers.GetMyAttendance)
+func AttendanceRoutes(r *gin.Engine, d Deps) {
+	attendance := r.Group("/", middleware.Authenticated(d.Limits, "attendance", middleware.AuthMiddleware(d.Auth, d.Users))...)
+	{
+		attendance.POST("/attendance/start", handlers.StartAttendance(d.Store, d.Session, d.Hub))
+		attendance.GET("/class/:id/my-attendance", handlers.GetMyAttendance(d.Store))
+	}
 }
@@ -6,11 +6,22 @@ import (
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
 )
 
-func AuthRoutes(r *gin.Engine) {
-	auth := r.Group("/auth")
+func AuthRoutes(r *gin.Engine, d Deps) {
+	cfg := d.Config
+
+	// Most auth routes are unauthenticated, so the group is limited per IP;
+	// /me adds the per-user limit.
+	auth := r.Group("/auth", middleware.RateLimit(d.Limits, "auth"))
 	{
-		auth.POST("/signup", handlers.Signup)
-		auth.POST("/login", handlers.Login)
-		auth.GET("/me", middleware.AuthMiddleware(), handlers.Me)
+		auth.POST("/signup", handlers.Signup(cfg.Auth, d.Store, d.Auth, d.Users))
+		auth.POST("/login", handlers.Login(cfg.Auth, d.Store, d.Auth))
+		me := auth.Group("/me", middleware.Authenticated(d.Limits, "auth", middleware.AuthMiddleware(d.Auth, d.Users))...)
+		me.GET("", handlers.Me(d.Store))
+		me.PATCH("", handlers.UpdateMe(d.Store))
+		auth.POST("/webhook/post-login", handlers.PostLoginWebhook(cfg.Auth.Auth0.WebhookSecret, d.Users))
+
+		if cfg.Auth.Provider == "dev" {
+			auth.POST("/dev/token", handlers.DevToken(cfg.Auth, d.Auth))
+		}
 	}
 }
@@ -6,10 +6,16 @@ import (
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
 )
 
-func ClassRoutes(r *gin.Engine) {
-	r.POST("/class", middleware.AuthMiddleware(), handlers.CreateClass)
-	r.POST("/class/:id/add-student", middleware.AuthMiddleware(), handlers.AddStudent)
-	r.GET("/class/:id", middleware.AuthMiddleware(), handlers.GetClass)
-	r.GET("/class/:id/room", middleware.AuthMiddleware(), handlers.GetRoomInfo)
-	r.GET("/students", middleware.AuthMiddleware(), handlers.GetStudents)
+func ClassRoutes(r *gin.Engine, d Deps) {
+	classes := r.Group("/", middleware.Authenticated(d.Limits, "classes", middleware.AuthMiddleware(d.Auth, d.Users))...)
+	{
+		classes.POST("/class", handlers.CreateClass(d.Store))
+		classes.POST("/class/:id/add-student", handlers.AddStudent(d.Store))
+		classes.GET("/class/:id", handlers.GetClass(d.Store))
+		classes.GET("/class/:id/room", handlers.GetRoomInfo(d.Store))
+		classes.GET("/class/:id/events", handlers.ClassEvents(d.Store, d.Hub))
+		classes.GET("/class/:id/events/poll", handlers.PollClassEvents(d.Store, d.Hub))
+		classes.GET("/class/:id/sessions/:sessionId/chat", handlers.GetChatHistory(d.Store))
+		classes.GET("/students", handlers.GetStudents(d.Store))
+	}
 }
@@ -2,12 +2,12 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
 )
 
-func DebugRoutes(r *gin.Engine) {
-	r.GET("/debug/session", func(c *gin.Context) {
-		s := session.Get()
+func DebugRoutes(r *gin.Engine, d Deps) {
+	r.GET("/debug/session", middleware.RateLimit(d.Limits, "debug"), func(c *gin.Context) {
+		s := d.Session.Get()
 		if s == nil {
 			c.JSON(200, gin.H{"session": nil})
 			return
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/handlers"
+)
+
+func HealthRoutes(r *gin.Engine, d Deps) {
+	ready := handlers.Readiness(d.Config, d.Store, d.Auth, d.Hub)
+
+	r.GET("/health/live", handlers.Liveness)
+	r.GET("/health/ready", ready)
+	// /health predates the split and keeps reporting full readiness.
+	r.GET("/health", ready)
+}
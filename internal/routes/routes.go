@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/websocket"
+)
+
+// Deps are the services the routes are built on, created once at startup.
+type Deps struct {
+	Config *config.Config
+	Store  *store.Store
+	// Session is the active class session, shared with Hub.
+	Session *session.State
+	Auth    utils.Authenticator
+	Users   *users.Provisioner
+	// Limits may be nil to leave the routes unlimited.
+	Limits *middleware.RateLimiter
+	Hub    *websocket.Hub
+}
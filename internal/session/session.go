@@ -1,40 +1,155 @@
 package session
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type ActiveSession struct {
+	SessionID  string
 	ClassID    string
+	TeacherID  string
+	RoomID     string
 	StartedAt  string
 	Attendance map[string]string
+	// Hands records every raised hand in the order raised. Open raises
+	// form the queue; resolved ones are kept for the participation record.
+	Hands []HandRaise
+	// Muted maps users barred from chat to when the mute ends; a zero time
+	// lasts until the session ends.
+	Muted map[string]time.Time
 }
 
-var (
-	mu sync.RWMutex
-	s  *ActiveSession
-)
+// HandRaise is one raised hand. Outcome is empty while it is in the queue,
+// then lowered, acknowledged or cleared.
+type HandRaise struct {
+	StudentID  string
+	RaisedAt   time.Time
+	ResolvedAt time.Time
+	Outcome    string
+}
+
+// State holds the class session running on this instance, if any. It is
+// created once at startup and shared by the hub and the REST handlers.
+type State struct {
+	mu       sync.RWMutex
+	active   *ActiveSession
+	onChange func(prev, cur *ActiveSession)
+
+	// notifyMu is taken before mu is released, so OnChange sees changes in
+	// the order they were made.
+	notifyMu sync.Mutex
+}
+
+func New() *State {
+	return &State{}
+}
+
+// OnChange registers fn to receive copies of the session before and after
+// every Set, Clear or WithWrite; either is nil when there was or is no
+// session. Calls are made one at a time, in order, and fn must not change
+// the session itself. It is used to replicate changes to other instances.
+func (st *State) OnChange(fn func(prev, cur *ActiveSession)) {
+	st.mu.Lock()
+	st.onChange = fn
+	st.mu.Unlock()
+}
 
-func Set(v *ActiveSession) {
-	mu.Lock()
-	s = v
-	mu.Unlock()
+// Apply replaces the session with one received from another instance. It
+// does not trigger OnChange.
+func (st *State) Apply(v *ActiveSession) {
+	st.mu.Lock()
+	st.active = v
+	st.mu.Unlock()
 }
 
-func Get() *ActiveSession {
-	mu.RLock()
-	defer mu.RUnlock()
-	return s
+// ApplyChange runs fn on the session to merge a change received from
+// another instance. Like Apply it does not trigger OnChange.
+func (st *State) ApplyChange(fn func(s *ActiveSession)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.active != nil {
+		fn(st.active)
+	}
 }
 
-func Clear() {
-	mu.Lock()
-	s = nil
-	mu.Unlock()
+func (st *State) Set(v *ActiveSession) {
+	st.mu.Lock()
+	prev := st.snapshot()
+	st.active = v
+	notify := st.changed(prev)
+	st.mu.Unlock()
+	notify()
 }
 
-func WithWrite(fn func(s *ActiveSession)) {
-	mu.Lock()
-	defer mu.Unlock()
-	if s != nil {
-		fn(s)
+func (st *State) Get() *ActiveSession {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.active
+}
+
+func (st *State) Clear() {
+	st.mu.Lock()
+	prev := st.snapshot()
+	st.active = nil
+	notify := st.changed(prev)
+	st.mu.Unlock()
+	notify()
+}
+
+func (st *State) WithWrite(fn func(s *ActiveSession)) {
+	st.mu.Lock()
+	if st.active == nil {
+		st.mu.Unlock()
+		return
+	}
+	prev := st.snapshot()
+	fn(st.active)
+	notify := st.changed(prev)
+	st.mu.Unlock()
+	notify()
+}
+
+func (st *State) WithRead(fn func(s *ActiveSession)) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if st.active != nil {
+		fn(st.active)
+	}
+}
+
+// changed returns the call of the OnChange hook with prev and a copy of the
+// session, to be made after mu is released. The caller must hold mu.
+func (st *State) changed(prev *ActiveSession) func() {
+	if st.onChange == nil {
+		return func() {}
+	}
+	fn, cur := st.onChange, st.snapshot()
+	st.notifyMu.Lock()
+	return func() {
+		defer st.notifyMu.Unlock()
+		fn(prev, cur)
+	}
+}
+
+// snapshot copies the session for the OnChange hook; it is nil without a
+// hook or a session. The caller must hold mu.
+func (st *State) snapshot() *ActiveSession {
+	s := st.active
+	if st.onChange == nil || s == nil {
+		return nil
+	}
+	c := *s
+	c.Attendance = make(map[string]string, len(s.Attendance))
+	for k, v := range s.Attendance {
+		c.Attendance[k] = v
+	}
+	c.Hands = append([]HandRaise(nil), s.Hands...)
+	if s.Muted != nil {
+		c.Muted = make(map[string]time.Time, len(s.Muted))
+		for k, v := range s.Muted {
+			c.Muted[k] = v
+		}
 	}
+	return &c
 }
@@ -0,0 +1,392 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemory returns a Store that keeps everything in process memory. It is
+// meant for tests and local development; nothing survives a restart.
+func NewMemory() *Store {
+	return &Store{
+		Users:      &memoryUsers{byAuth0ID: map[string]models.User{}},
+		Classes:    &memoryClasses{byID: map[primitive.ObjectID]models.Class{}},
+		Sessions:   &memorySessions{byID: map[primitive.ObjectID]models.Session{}},
+		Attendance: &memoryAttendance{byKey: map[attendanceKey]models.Attendance{}},
+		Chat:       &memoryChat{byID: map[primitive.ObjectID]models.ChatMessage{}},
+	}
+}
+
+type memoryUsers struct {
+	mu        sync.RWMutex
+	byAuth0ID map[string]models.User
+}
+
+func (r *memoryUsers) FindByAuth0ID(_ context.Context, auth0ID string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.byAuth0ID[auth0ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *memoryUsers) FindByEmail(_ context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.byAuth0ID {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUsers) ListByRole(_ context.Context, role string) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var users []models.User
+	for _, u := range r.byAuth0ID {
+		if u.Role == role {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID.Hex() < users[j].ID.Hex() })
+	return users, nil
+}
+
+func (r *memoryUsers) Create(_ context.Context, u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byAuth0ID[u.Auth0ID]; ok || r.emailTaken(u.Email, u.Auth0ID) {
+		return ErrDuplicate
+	}
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	r.byAuth0ID[u.Auth0ID] = *u
+	return nil
+}
+
+// emailTaken reports whether another user already has the non-empty email,
+// mirroring the unique index of the other backends. Callers hold r.mu.
+func (r *memoryUsers) emailTaken(email, auth0ID string) bool {
+	if email == "" {
+		return false
+	}
+	for _, u := range r.byAuth0ID {
+		if u.Email == email && u.Auth0ID != auth0ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryUsers) Provision(_ context.Context, u models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	existing, ok := r.byAuth0ID[u.Auth0ID]
+	if !ok {
+		if r.emailTaken(u.Email, u.Auth0ID) {
+			return ErrDuplicate
+		}
+		existing = models.User{
+			ID:        primitive.NewObjectID(),
+			Auth0ID:   u.Auth0ID,
+			Name:      u.Name,
+			Email:     u.Email,
+			CreatedAt: now,
+		}
+	}
+	if u.Role != "" {
+		existing.Role = u.Role
+	}
+	existing.LastLoginAt = now
+	r.byAuth0ID[u.Auth0ID] = existing
+	return nil
+}
+
+func (r *memoryUsers) Sync(_ context.Context, u models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	existing, ok := r.byAuth0ID[u.Auth0ID]
+	if !ok {
+		existing = models.User{ID: primitive.NewObjectID(), Auth0ID: u.Auth0ID, CreatedAt: now}
+	}
+	if r.emailTaken(u.Email, u.Auth0ID) {
+		return ErrDuplicate
+	}
+	if u.Name != "" {
+		existing.Name = u.Name
+	}
+	if u.Email != "" {
+		existing.Email = u.Email
+	}
+	if u.Role != "" {
+		existing.Role = u.Role
+	}
+	existing.UpdatedAt = now
+	existing.LastLoginAt = now
+	r.byAuth0ID[u.Auth0ID] = existing
+	return nil
+}
+
+func (r *memoryUsers) UpdateProfile(_ context.Context, auth0ID string, name, email *string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.byAuth0ID[auth0ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if email != nil && r.emailTaken(*email, auth0ID) {
+		return nil, ErrDuplicate
+	}
+	if name != nil {
+		u.Name = *name
+	}
+	if email != nil {
+		u.Email = *email
+	}
+	u.UpdatedAt = time.Now().UTC()
+	r.byAuth0ID[auth0ID] = u
+	return &u, nil
+}
+
+type memoryClasses struct {
+	mu   sync.RWMutex
+	byID map[primitive.ObjectID]models.Class
+}
+
+func copyClass(c models.Class) *models.Class {
+	c.StudentIDs = append([]string{}, c.StudentIDs...)
+	return &c
+}
+
+func (r *memoryClasses) Create(_ context.Context, c *models.Class) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	if _, ok := r.byID[c.ID]; ok {
+		return ErrDuplicate
+	}
+	r.byID[c.ID] = *copyClass(*c)
+	return nil
+}
+
+func (r *memoryClasses) FindByID(_ context.Context, id primitive.ObjectID) (*models.Class, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyClass(c), nil
+}
+
+func (r *memoryClasses) AddStudent(_ context.Context, id primitive.ObjectID, studentID string) (*models.Class, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	for _, sid := range c.StudentIDs {
+		if sid == studentID {
+			return copyClass(c), nil
+		}
+	}
+	c.StudentIDs = append(append([]string{}, c.StudentIDs...), studentID)
+	r.byID[id] = c
+	return copyClass(c), nil
+}
+
+func (r *memoryClasses) SetActiveRoom(_ context.Context, id primitive.ObjectID, roomID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.ActiveRoomID = roomID
+	r.byID[id] = c
+	return nil
+}
+
+func (r *memoryClasses) ClearActiveRoom(ctx context.Context, id primitive.ObjectID) error {
+	err := r.SetActiveRoom(ctx, id, "")
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+type memorySessions struct {
+	mu   sync.RWMutex
+	byID map[primitive.ObjectID]models.Session
+}
+
+func (r *memorySessions) Create(_ context.Context, s *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	r.byID[s.ID] = *s
+	return nil
+}
+
+func (r *memorySessions) FindByID(_ context.Context, id primitive.ObjectID) (*models.Session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+func (r *memorySessions) End(_ context.Context, id primitive.ObjectID, endedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.byID[id]
+	if !ok {
+		return nil
+	}
+	s.EndedAt = &endedAt
+	r.byID[id] = s
+	return nil
+}
+
+func (r *memorySessions) SaveHandRaises(_ context.Context, id primitive.ObjectID, raises []models.HandRaise) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.byID[id]
+	if !ok {
+		return nil
+	}
+	s.HandRaises = append([]models.HandRaise(nil), raises...)
+	r.byID[id] = s
+	return nil
+}
+
+type attendanceKey struct {
+	classID   primitive.ObjectID
+	studentID string
+}
+
+type memoryAttendance struct {
+	mu    sync.RWMutex
+	byKey map[attendanceKey]models.Attendance
+}
+
+func (r *memoryAttendance) Find(_ context.Context, classID primitive.ObjectID, studentID string) (*models.Attendance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, ok := r.byKey[attendanceKey{classID, studentID}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+func (r *memoryAttendance) Replace(_ context.Context, records []models.Attendance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rec := range records {
+		if rec.ID.IsZero() {
+			rec.ID = primitive.NewObjectID()
+		}
+		r.byKey[attendanceKey{rec.ClassID, rec.StudentID}] = rec
+	}
+	return nil
+}
+
+type memoryChat struct {
+	mu   sync.RWMutex
+	byID map[primitive.ObjectID]models.ChatMessage
+}
+
+func (r *memoryChat) Create(_ context.Context, m *models.ChatMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m.ID.IsZero() {
+		m.ID = primitive.NewObjectID()
+	}
+	r.byID[m.ID] = *m
+	return nil
+}
+
+func (r *memoryChat) FindByID(_ context.Context, id primitive.ObjectID) (*models.ChatMessage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &m, nil
+}
+
+func (r *memoryChat) Delete(_ context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if m.DeletedAt == nil {
+		m.DeletedAt, m.DeletedBy = &deletedAt, deletedBy
+		r.byID[id] = m
+	}
+	return nil
+}
+
+func (r *memoryChat) List(_ context.Context, q ChatQuery) ([]models.ChatMessage, error) {
+	r.mu.RLock()
+	var messages []models.ChatMessage
+	for _, m := range r.byID {
+		if m.SessionID != q.SessionID || !q.visible(m) {
+			continue
+		}
+		if !q.Before.IsZero() && m.ID.Hex() >= q.Before.Hex() {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID.Hex() < messages[j].ID.Hex() })
+	if len(messages) > q.Limit {
+		messages = messages[len(messages)-q.Limit:]
+	}
+	return messages, nil
+}
@@ -0,0 +1,334 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongo returns a Store backed by the given database.
+func NewMongo(db *mongo.Database) *Store {
+	return &Store{
+		Users:      &mongoUsers{col: db.Collection("users")},
+		Classes:    &mongoClasses{col: db.Collection("classes")},
+		Sessions:   &mongoSessions{col: db.Collection("sessions")},
+		Attendance: &mongoAttendance{col: db.Collection("attendance")},
+		Chat:       &mongoChat{col: db.Collection("chat_messages")},
+	}
+}
+
+func mongoErr(err error) error {
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicate
+	}
+	return err
+}
+
+type mongoUsers struct {
+	col *mongo.Collection
+}
+
+func (r *mongoUsers) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	if err := r.col.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, mongoErr(err)
+	}
+	return &user, nil
+}
+
+func (r *mongoUsers) FindByAuth0ID(ctx context.Context, auth0ID string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"auth0Id": auth0ID})
+}
+
+func (r *mongoUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email": email})
+}
+
+func (r *mongoUsers) ListByRole(ctx context.Context, role string) ([]models.User, error) {
+	cursor, err := r.col.Find(ctx, bson.M{"role": role})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err == nil {
+			users = append(users, user)
+		}
+	}
+	return users, cursor.Err()
+}
+
+func (r *mongoUsers) Create(ctx context.Context, u *models.User) error {
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	_, err := r.col.InsertOne(ctx, u)
+	return mongoErr(err)
+}
+
+func (r *mongoUsers) Provision(ctx context.Context, u models.User) error {
+	now := time.Now().UTC()
+	onInsert := bson.M{
+		"auth0Id":   u.Auth0ID,
+		"name":      u.Name,
+		"email":     u.Email,
+		"createdAt": now,
+	}
+	set := bson.M{"lastLoginAt": now}
+	if u.Role != "" {
+		set["role"] = u.Role
+	} else {
+		onInsert["role"] = ""
+	}
+
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"auth0Id": u.Auth0ID},
+		bson.M{"$setOnInsert": onInsert, "$set": set},
+		options.Update().SetUpsert(true),
+	)
+	return mongoErr(err)
+}
+
+func (r *mongoUsers) Sync(ctx context.Context, u models.User) error {
+	now := time.Now().UTC()
+	set := bson.M{"updatedAt": now, "lastLoginAt": now}
+	onInsert := bson.M{"auth0Id": u.Auth0ID, "createdAt": now}
+
+	for field, value := range map[string]string{"name": u.Name, "email": u.Email, "role": u.Role} {
+		if value != "" {
+			set[field] = value
+		} else {
+			onInsert[field] = ""
+		}
+	}
+
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"auth0Id": u.Auth0ID},
+		bson.M{"$setOnInsert": onInsert, "$set": set},
+		options.Update().SetUpsert(true),
+	)
+	return mongoErr(err)
+}
+
+func (r *mongoUsers) UpdateProfile(ctx context.Context, auth0ID string, name, email *string) (*models.User, error) {
+	set := bson.M{"updatedAt": time.Now().UTC()}
+	if name != nil {
+		set["name"] = *name
+	}
+	if email != nil {
+		set["email"] = *email
+	}
+
+	var user models.User
+	err := r.col.FindOneAndUpdate(ctx,
+		bson.M{"auth0Id": auth0ID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		return nil, mongoErr(err)
+	}
+	return &user, nil
+}
+
+type mongoClasses struct {
+	col *mongo.Collection
+}
+
+func (r *mongoClasses) Create(ctx context.Context, c *models.Class) error {
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+	_, err := r.col.InsertOne(ctx, c)
+	return mongoErr(err)
+}
+
+func (r *mongoClasses) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Class, error) {
+	var class models.Class
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&class); err != nil {
+		return nil, mongoErr(err)
+	}
+	return &class, nil
+}
+
+func (r *mongoClasses) AddStudent(ctx context.Context, id primitive.ObjectID, studentID string) (*models.Class, error) {
+	var class models.Class
+	err := r.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$addToSet": bson.M{"studentIds": studentID}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&class)
+	if err != nil {
+		return nil, mongoErr(err)
+	}
+	return &class, nil
+}
+
+func (r *mongoClasses) SetActiveRoom(ctx context.Context, id primitive.ObjectID, roomID string) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"activeRoomId": roomID},
+	})
+	if err != nil {
+		return mongoErr(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoClasses) ClearActiveRoom(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$unset": bson.M{"activeRoomId": ""},
+	})
+	return mongoErr(err)
+}
+
+type mongoSessions struct {
+	col *mongo.Collection
+}
+
+func (r *mongoSessions) Create(ctx context.Context, s *models.Session) error {
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+	_, err := r.col.InsertOne(ctx, s)
+	return mongoErr(err)
+}
+
+func (r *mongoSessions) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	var s models.Session
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&s); err != nil {
+		return nil, mongoErr(err)
+	}
+	return &s, nil
+}
+
+func (r *mongoSessions) End(ctx context.Context, id primitive.ObjectID, endedAt time.Time) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"endedAt": endedAt},
+	})
+	return mongoErr(err)
+}
+
+func (r *mongoSessions) SaveHandRaises(ctx context.Context, id primitive.ObjectID, raises []models.HandRaise) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"handRaises": raises},
+	})
+	return mongoErr(err)
+}
+
+type mongoAttendance struct {
+	col *mongo.Collection
+}
+
+func (r *mongoAttendance) Find(ctx context.Context, classID primitive.ObjectID, studentID string) (*models.Attendance, error) {
+	var a models.Attendance
+	err := r.col.FindOne(ctx, bson.M{
+		"classId":   classID,
+		"studentId": studentID,
+	}).Decode(&a)
+	if err != nil {
+		return nil, mongoErr(err)
+	}
+	return &a, nil
+}
+
+func (r *mongoAttendance) Replace(ctx context.Context, records []models.Attendance) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(records))
+	for _, rec := range records {
+		// The stored _id is kept so the replacement does not try to
+		// change an immutable field.
+		rec.ID = primitive.NilObjectID
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"classId": rec.ClassID, "studentId": rec.StudentID}).
+			SetReplacement(rec).
+			SetUpsert(true))
+	}
+
+	_, err := r.col.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return mongoErr(err)
+}
+
+type mongoChat struct {
+	col *mongo.Collection
+}
+
+func (r *mongoChat) Create(ctx context.Context, m *models.ChatMessage) error {
+	if m.ID.IsZero() {
+		m.ID = primitive.NewObjectID()
+	}
+	_, err := r.col.InsertOne(ctx, m)
+	return mongoErr(err)
+}
+
+func (r *mongoChat) FindByID(ctx context.Context, id primitive.ObjectID) (*models.ChatMessage, error) {
+	var m models.ChatMessage
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&m); err != nil {
+		return nil, mongoErr(err)
+	}
+	return &m, nil
+}
+
+func (r *mongoChat) Delete(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}}, bson.M{
+		"$set": bson.M{"deletedAt": deletedAt, "deletedBy": deletedBy},
+	})
+	if err != nil {
+		return mongoErr(err)
+	}
+	if res.MatchedCount == 0 {
+		// Already deleted is fine; a missing message is not.
+		if _, err := r.FindByID(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *mongoChat) List(ctx context.Context, q ChatQuery) ([]models.ChatMessage, error) {
+	filter := bson.M{"sessionId": q.SessionID}
+	if !q.Before.IsZero() {
+		filter["_id"] = bson.M{"$lt": q.Before}
+	}
+	if !q.IncludeDeleted {
+		filter["deletedAt"] = bson.M{"$exists": false}
+	}
+	if q.Viewer != "" {
+		filter["$or"] = bson.A{
+			bson.M{"recipientId": bson.M{"$exists": false}},
+			bson.M{"senderId": q.Viewer},
+			bson.M{"recipientId": q.Viewer},
+		}
+	}
+
+	cursor, err := r.col.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(q.Limit)))
+	if err != nil {
+		return nil, mongoErr(err)
+	}
+	var messages []models.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, mongoErr(err)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
@@ -0,0 +1,347 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlDB wraps a database/sql handle with the dialect-specific bits: bind
+// placeholders and constraint error detection.
+type sqlDB struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// OpenSQL connects to PostgreSQL (driver "postgres") or SQLite (driver
+// "sqlite"), applies pending schema migrations and returns a Store.
+func OpenSQL(driver, dsn string) (*Store, error) {
+	s, err := connectSQL(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.migrate(ctx); err != nil {
+		s.db.Close()
+		return nil, fmt.Errorf("sql migrations: %w", err)
+	}
+
+	return &Store{
+		Users:      &sqlUsers{s},
+		Classes:    &sqlClasses{s},
+		Sessions:   &sqlSessions{s},
+		Attendance: &sqlAttendance{s},
+		Chat:       &sqlChat{s},
+		closer:     s.db.Close,
+		pinger:     s.db.PingContext,
+	}, nil
+}
+
+func connectSQL(driver, dsn string) (*sqlDB, error) {
+	var (
+		db  *sql.DB
+		err error
+	)
+
+	switch driver {
+	case "postgres":
+		db, err = sql.Open("pgx", dsn)
+	case "sqlite":
+		if !strings.Contains(dsn, "_foreign_keys") && !strings.Contains(dsn, "_fk") {
+			sep := "?"
+			if strings.Contains(dsn, "?") {
+				sep = "&"
+			}
+			dsn += sep + "_foreign_keys=1"
+		}
+		db, err = sql.Open("sqlite3", dsn)
+	default:
+		return nil, fmt.Errorf("unknown sql driver %q", driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer; serialising through one
+		// connection avoids "database is locked" errors.
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlDB{db: db, postgres: driver == "postgres"}, nil
+}
+
+// rebind rewrites ? placeholders to $n for PostgreSQL.
+func (s *sqlDB) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlDB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	res, err := s.db.ExecContext(ctx, s.rebind(query), args...)
+	return res, sqlErr(err)
+}
+
+func (s *sqlDB) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+func (s *sqlDB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.QueryContext(ctx, s.rebind(query), args...)
+}
+
+// sqlErr maps missing rows and foreign key violations, which mean the
+// referenced row does not exist, to ErrNotFound and unique violations to
+// ErrDuplicate.
+func sqlErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return ErrDuplicate
+		case "23503":
+			return ErrNotFound
+		}
+	}
+
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return ErrDuplicate
+		case sqlite3.ErrConstraintForeignKey:
+			return ErrNotFound
+		}
+	}
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// sqlMigrations are applied in order and recorded in schema_migrations. Each
+// migration is a list of statements run in one transaction, so a failure
+// leaves none of it applied. Never edit an entry once released; append a new
+// one instead.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE users (
+			id            TEXT PRIMARY KEY,
+			auth0_id      TEXT NOT NULL UNIQUE,
+			name          TEXT NOT NULL DEFAULT '',
+			email         TEXT NOT NULL DEFAULT '',
+			role          TEXT NOT NULL DEFAULT '',
+			password_hash TEXT NOT NULL DEFAULT '',
+			created_at    TIMESTAMP NULL,
+			updated_at    TIMESTAMP NULL,
+			last_login_at TIMESTAMP NULL
+		)`,
+		`CREATE INDEX users_email_idx ON users (email)`,
+		`CREATE INDEX users_role_idx ON users (role)`,
+		`CREATE TABLE classes (
+			id             TEXT PRIMARY KEY,
+			class_name     TEXT NOT NULL,
+			teacher_id     TEXT NOT NULL,
+			active_room_id TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX classes_teacher_idx ON classes (teacher_id)`,
+		`CREATE TABLE enrollments (
+			class_id    TEXT NOT NULL REFERENCES classes (id) ON DELETE CASCADE,
+			student_id  TEXT NOT NULL,
+			enrolled_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (class_id, student_id)
+		)`,
+		`CREATE INDEX enrollments_student_idx ON enrollments (student_id)`,
+		`CREATE TABLE sessions (
+			id         TEXT PRIMARY KEY,
+			class_id   TEXT NOT NULL REFERENCES classes (id) ON DELETE CASCADE,
+			teacher_id TEXT NOT NULL,
+			room_id    TEXT NOT NULL,
+			started_at TIMESTAMP NOT NULL,
+			ended_at   TIMESTAMP NULL
+		)`,
+		`CREATE INDEX sessions_class_idx ON sessions (class_id)`,
+		`CREATE TABLE attendance (
+			id         TEXT PRIMARY KEY,
+			class_id   TEXT NOT NULL REFERENCES classes (id) ON DELETE CASCADE,
+			session_id TEXT NULL REFERENCES sessions (id) ON DELETE SET NULL,
+			student_id TEXT NOT NULL,
+			status     TEXT NOT NULL CHECK (status IN ('present', 'absent')),
+			UNIQUE (class_id, student_id)
+		)`,
+	},
+	{
+		`CREATE TABLE hand_raises (
+			session_id  TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+			position    INTEGER NOT NULL,
+			student_id  TEXT NOT NULL,
+			raised_at   TIMESTAMP NOT NULL,
+			resolved_at TIMESTAMP NULL,
+			outcome     TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (session_id, position)
+		)`,
+	},
+	{
+		`CREATE TABLE chat_messages (
+			id           TEXT PRIMARY KEY,
+			session_id   TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+			class_id     TEXT NOT NULL,
+			sender_id    TEXT NOT NULL,
+			sender_name  TEXT NOT NULL DEFAULT '',
+			sender_role  TEXT NOT NULL DEFAULT '',
+			recipient_id TEXT NOT NULL DEFAULT '',
+			text         TEXT NOT NULL,
+			sent_at      TIMESTAMP NOT NULL,
+			deleted_at   TIMESTAMP NULL,
+			deleted_by   TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX chat_messages_session_idx ON chat_messages (session_id, id)`,
+	},
+	{
+		// A local account keeps a shared email, since it logs in with it,
+		// otherwise the oldest account does.
+		`UPDATE users SET email = ''
+		WHERE email <> '' AND auth0_id NOT LIKE 'local|%' AND EXISTS (
+			SELECT 1 FROM users o
+			WHERE o.email = users.email AND o.id <> users.id
+				AND (o.auth0_id LIKE 'local|%' OR o.id < users.id)
+		)`,
+		`DROP INDEX users_email_idx`,
+		`CREATE UNIQUE INDEX users_email_idx ON users (email) WHERE email <> ''`,
+	},
+}
+
+// SQLMigrationState is one schema version and when it was applied.
+type SQLMigrationState struct {
+	Version   int
+	AppliedAt *time.Time
+}
+
+// SQLMigrationStatus lists every schema version and when it was applied,
+// without applying pending ones.
+func SQLMigrationStatus(ctx context.Context, driver, dsn string) ([]SQLMigrationState, error) {
+	s, err := connectSQL(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Close()
+
+	exists := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`
+	if s.postgres {
+		exists = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'`
+	}
+	var n int
+	if err := s.db.QueryRowContext(ctx, exists).Scan(&n); err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]*time.Time{}
+	if n > 0 {
+		rows, err := s.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				version int
+				at      time.Time
+			)
+			if err := rows.Scan(&version, &at); err != nil {
+				return nil, err
+			}
+			appliedAt[version] = &at
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]SQLMigrationState, len(sqlMigrations))
+	for i := range sqlMigrations {
+		states[i] = SQLMigrationState{Version: i + 1, AppliedAt: appliedAt[i+1]}
+	}
+	return states, nil
+}
+
+func (s *sqlDB) migrate(ctx context.Context) error {
+	return s.migrateTo(ctx, len(sqlMigrations))
+}
+
+// migrateTo applies pending migrations up to and including target.
+func (s *sqlDB) migrateTo(ctx context.Context, target int) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < target; i++ {
+		version := i + 1
+
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range sqlMigrations[i] {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("version %d: %w", version, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`),
+			version, time.Now().UTC()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const userColumns = `id, auth0_id, name, email, role, password_hash, created_at, updated_at, last_login_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var (
+		u                              models.User
+		id                             string
+		created, updated, lastLoggedIn sql.NullTime
+	)
+	err := row.Scan(&id, &u.Auth0ID, &u.Name, &u.Email, &u.Role, &u.PasswordHash, &created, &updated, &lastLoggedIn)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	u.ID, _ = primitive.ObjectIDFromHex(id)
+	u.CreatedAt = created.Time
+	u.UpdatedAt = updated.Time
+	u.LastLoginAt = lastLoggedIn.Time
+	return &u, nil
+}
+
+type sqlUsers struct {
+	s *sqlDB
+}
+
+func (r *sqlUsers) FindByAuth0ID(ctx context.Context, auth0ID string) (*models.User, error) {
+	return scanUser(r.s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE auth0_id = ?`, auth0ID))
+}
+
+func (r *sqlUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return scanUser(r.s.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? ORDER BY id LIMIT 1`, email))
+}
+
+func (r *sqlUsers) ListByRole(ctx context.Context, role string) ([]models.User, error) {
+	rows, err := r.s.query(ctx, `SELECT `+userColumns+` FROM users WHERE role = ? ORDER BY id`, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func (r *sqlUsers) Create(ctx context.Context, u *models.User) error {
+	if u.ID.IsZero() {
+		u.ID = primitive.NewObjectID()
+	}
+	_, err := r.s.exec(ctx, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.ID.Hex(), u.Auth0ID, u.Name, u.Email, u.Role, u.PasswordHash,
+		nullTime(u.CreatedAt), nullTime(u.UpdatedAt), nullTime(u.LastLoginAt))
+	return err
+}
+
+func (r *sqlUsers) Provision(ctx context.Context, u models.User) error {
+	now := time.Now().UTC()
+	_, err := r.s.exec(ctx, `
+		INSERT INTO users (id, auth0_id, name, email, role, created_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (auth0_id) DO UPDATE SET
+			role = CASE WHEN excluded.role <> '' THEN excluded.role ELSE users.role END,
+			last_login_at = excluded.last_login_at`,
+		primitive.NewObjectID().Hex(), u.Auth0ID, u.Name, u.Email, u.Role, now, now)
+	return err
+}
+
+func (r *sqlUsers) Sync(ctx context.Context, u models.User) error {
+	now := time.Now().UTC()
+	_, err := r.s.exec(ctx, `
+		INSERT INTO users (id, auth0_id, name, email, role, created_at, updated_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (auth0_id) DO UPDATE SET
+			name = CASE WHEN excluded.name <> '' THEN excluded.name ELSE users.name END,
+			email = CASE WHEN excluded.email <> '' THEN excluded.email ELSE users.email END,
+			role = CASE WHEN excluded.role <> '' THEN excluded.role ELSE users.role END,
+			updated_at = excluded.updated_at,
+			last_login_at = excluded.last_login_at`,
+		primitive.NewObjectID().Hex(), u.Auth0ID, u.Name, u.Email, u.Role, now, now, now)
+	return err
+}
+
+func (r *sqlUsers) UpdateProfile(ctx context.Context, auth0ID string, name, email *string) (*models.User, error) {
+	res, err := r.s.exec(ctx, `
+		UPDATE users SET
+			name = COALESCE(?, name),
+			email = COALESCE(?, email),
+			updated_at = ?
+		WHERE auth0_id = ?`,
+		name, email, time.Now().UTC(), auth0ID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	return r.FindByAuth0ID(ctx, auth0ID)
+}
+
+type sqlClasses struct {
+	s *sqlDB
+}
+
+func (r *sqlClasses) Create(ctx context.Context, c *models.Class) error {
+	if c.ID.IsZero() {
+		c.ID = primitive.NewObjectID()
+	}
+
+	tx, err := r.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, r.s.rebind(`INSERT INTO classes (id, class_name, teacher_id, active_room_id) VALUES (?, ?, ?, ?)`),
+		c.ID.Hex(), c.ClassName, c.TeacherID, c.ActiveRoomID)
+	if err != nil {
+		return sqlErr(err)
+	}
+
+	now := time.Now().UTC()
+	for i, sid := range c.StudentIDs {
+		_, err = tx.ExecContext(ctx, r.s.rebind(`INSERT INTO enrollments (class_id, student_id, enrolled_at) VALUES (?, ?, ?)`),
+			c.ID.Hex(), sid, now.Add(time.Duration(i)*time.Microsecond))
+		if err != nil {
+			return sqlErr(err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqlClasses) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Class, error) {
+	c := models.Class{ID: id, StudentIDs: []string{}}
+	err := r.s.queryRow(ctx, `SELECT class_name, teacher_id, active_room_id FROM classes WHERE id = ?`, id.Hex()).
+		Scan(&c.ClassName, &c.TeacherID, &c.ActiveRoomID)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+
+	rows, err := r.s.query(ctx, `SELECT student_id FROM enrollments WHERE class_id = ? ORDER BY enrolled_at, student_id`, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		c.StudentIDs = append(c.StudentIDs, sid)
+	}
+	return &c, rows.Err()
+}
+
+func (r *sqlClasses) AddStudent(ctx context.Context, id primitive.ObjectID, studentID string) (*models.Class, error) {
+	_, err := r.s.exec(ctx, `
+		INSERT INTO enrollments (class_id, student_id, enrolled_at) VALUES (?, ?, ?)
+		ON CONFLICT (class_id, student_id) DO NOTHING`,
+		id.Hex(), studentID, time.Now().UTC())
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	return r.FindByID(ctx, id)
+}
+
+func (r *sqlClasses) SetActiveRoom(ctx context.Context, id primitive.ObjectID, roomID string) error {
+	res, err := r.s.exec(ctx, `UPDATE classes SET active_room_id = ? WHERE id = ?`, roomID, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *sqlClasses) ClearActiveRoom(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.s.exec(ctx, `UPDATE classes SET active_room_id = '' WHERE id = ?`, id.Hex())
+	return err
+}
+
+type sqlSessions struct {
+	s *sqlDB
+}
+
+func (r *sqlSessions) Create(ctx context.Context, s *models.Session) error {
+	if s.ID.IsZero() {
+		s.ID = primitive.NewObjectID()
+	}
+
+	var endedAt sql.NullTime
+	if s.EndedAt != nil {
+		endedAt = nullTime(*s.EndedAt)
+	}
+	_, err := r.s.exec(ctx, `
+		INSERT INTO sessions (id, class_id, teacher_id, room_id, started_at, ended_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		s.ID.Hex(), s.ClassID.Hex(), s.TeacherID, s.RoomID, s.StartedAt, endedAt)
+	return err
+}
+
+func (r *sqlSessions) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	var (
+		s       = models.Session{ID: id}
+		classID string
+		endedAt sql.NullTime
+	)
+	err := r.s.queryRow(ctx, `SELECT class_id, teacher_id, room_id, started_at, ended_at FROM sessions WHERE id = ?`, id.Hex()).
+		Scan(&classID, &s.TeacherID, &s.RoomID, &s.StartedAt, &endedAt)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	s.ClassID, _ = primitive.ObjectIDFromHex(classID)
+	if endedAt.Valid {
+		s.EndedAt = &endedAt.Time
+	}
+
+	rows, err := r.s.query(ctx, `
+		SELECT student_id, raised_at, resolved_at, outcome FROM hand_raises
+		WHERE session_id = ? ORDER BY position`, id.Hex())
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			h          models.HandRaise
+			resolvedAt sql.NullTime
+		)
+		if err := rows.Scan(&h.StudentID, &h.RaisedAt, &resolvedAt, &h.Outcome); err != nil {
+			return nil, sqlErr(err)
+		}
+		if resolvedAt.Valid {
+			h.ResolvedAt = &resolvedAt.Time
+		}
+		s.HandRaises = append(s.HandRaises, h)
+	}
+	return &s, sqlErr(rows.Err())
+}
+
+func (r *sqlSessions) End(ctx context.Context, id primitive.ObjectID, endedAt time.Time) error {
+	_, err := r.s.exec(ctx, `UPDATE sessions SET ended_at = ? WHERE id = ?`, endedAt, id.Hex())
+	return err
+}
+
+func (r *sqlSessions) SaveHandRaises(ctx context.Context, id primitive.ObjectID, raises []models.HandRaise) error {
+	tx, err := r.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, r.s.rebind(`DELETE FROM hand_raises WHERE session_id = ?`), id.Hex()); err != nil {
+		return sqlErr(err)
+	}
+	query := r.s.rebind(`
+		INSERT INTO hand_raises (session_id, position, student_id, raised_at, resolved_at, outcome)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	for i, h := range raises {
+		var resolvedAt sql.NullTime
+		if h.ResolvedAt != nil {
+			resolvedAt = nullTime(*h.ResolvedAt)
+		}
+		if _, err := tx.ExecContext(ctx, query, id.Hex(), i, h.StudentID, h.RaisedAt, resolvedAt, h.Outcome); err != nil {
+			return sqlErr(err)
+		}
+	}
+	return tx.Commit()
+}
+
+type sqlAttendance struct {
+	s *sqlDB
+}
+
+func (r *sqlAttendance) Find(ctx context.Context, classID primitive.ObjectID, studentID string) (*models.Attendance, error) {
+	var (
+		a         = models.Attendance{ClassID: classID, StudentID: studentID}
+		id        string
+		sessionID sql.NullString
+	)
+	err := r.s.queryRow(ctx, `SELECT id, session_id, status FROM attendance WHERE class_id = ? AND student_id = ?`,
+		classID.Hex(), studentID).Scan(&id, &sessionID, &a.Status)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	a.ID, _ = primitive.ObjectIDFromHex(id)
+	a.SessionID, _ = primitive.ObjectIDFromHex(sessionID.String)
+	return &a, nil
+}
+
+func (r *sqlAttendance) Replace(ctx context.Context, records []models.Attendance) error {
+	tx, err := r.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := r.s.rebind(`
+		INSERT INTO attendance (id, class_id, session_id, student_id, status)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (class_id, student_id) DO UPDATE SET
+			id = excluded.id,
+			session_id = excluded.session_id,
+			status = excluded.status`)
+
+	for _, rec := range records {
+		if rec.ID.IsZero() {
+			rec.ID = primitive.NewObjectID()
+		}
+		var sessionID sql.NullString
+		if !rec.SessionID.IsZero() {
+			sessionID = sql.NullString{String: rec.SessionID.Hex(), Valid: true}
+		}
+		if _, err := tx.ExecContext(ctx, query, rec.ID.Hex(), rec.ClassID.Hex(), sessionID, rec.StudentID, rec.Status); err != nil {
+			return sqlErr(err)
+		}
+	}
+	return tx.Commit()
+}
+
+type sqlChat struct {
+	s *sqlDB
+}
+
+const chatColumns = `id, session_id, class_id, sender_id, sender_name, sender_role, recipient_id, text, sent_at, deleted_at, deleted_by`
+
+func (r *sqlChat) Create(ctx context.Context, m *models.ChatMessage) error {
+	if m.ID.IsZero() {
+		m.ID = primitive.NewObjectID()
+	}
+
+	var deletedAt sql.NullTime
+	if m.DeletedAt != nil {
+		deletedAt = nullTime(*m.DeletedAt)
+	}
+	_, err := r.s.exec(ctx, `INSERT INTO chat_messages (`+chatColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID.Hex(), m.SessionID.Hex(), m.ClassID.Hex(), m.SenderID, m.SenderName, m.SenderRole,
+		m.RecipientID, m.Text, m.SentAt, deletedAt, m.DeletedBy)
+	return err
+}
+
+func (r *sqlChat) FindByID(ctx context.Context, id primitive.ObjectID) (*models.ChatMessage, error) {
+	return scanChatMessage(r.s.queryRow(ctx, `SELECT `+chatColumns+` FROM chat_messages WHERE id = ?`, id.Hex()))
+}
+
+func (r *sqlChat) Delete(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error {
+	res, err := r.s.exec(ctx, `UPDATE chat_messages SET deleted_at = ?, deleted_by = ? WHERE id = ? AND deleted_at IS NULL`,
+		deletedAt, deletedBy, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Already deleted is fine; a missing message is not.
+		if _, err := r.FindByID(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *sqlChat) List(ctx context.Context, q ChatQuery) ([]models.ChatMessage, error) {
+	where := []string{"session_id = ?"}
+	args := []interface{}{q.SessionID.Hex()}
+	if !q.Before.IsZero() {
+		where = append(where, "id < ?")
+		args = append(args, q.Before.Hex())
+	}
+	if !q.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if q.Viewer != "" {
+		where = append(where, "(recipient_id = '' OR sender_id = ? OR recipient_id = ?)")
+		args = append(args, q.Viewer, q.Viewer)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := r.s.query(ctx, `SELECT `+chatColumns+` FROM chat_messages WHERE `+
+		strings.Join(where, " AND ")+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	defer rows.Close()
+
+	var messages []models.ChatMessage
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqlErr(err)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func scanChatMessage(row rowScanner) (*models.ChatMessage, error) {
+	var (
+		m                      models.ChatMessage
+		id, sessionID, classID string
+		deletedAt              sql.NullTime
+	)
+	err := row.Scan(&id, &sessionID, &classID, &m.SenderID, &m.SenderName, &m.SenderRole,
+		&m.RecipientID, &m.Text, &m.SentAt, &deletedAt, &m.DeletedBy)
+	if err != nil {
+		return nil, sqlErr(err)
+	}
+	m.ID, _ = primitive.ObjectIDFromHex(id)
+	m.SessionID, _ = primitive.ObjectIDFromHex(sessionID)
+	m.ClassID, _ = primitive.ObjectIDFromHex(classID)
+	if deletedAt.Valid {
+		m.DeletedAt = &deletedAt.Time
+	}
+	return &m, nil
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// The unique email migration keeps a shared email on the local account, or
+// else on the oldest one, and clears it elsewhere.
+func TestSQLiteEmailMigration(t *testing.T) {
+	ctx := context.Background()
+	s, err := connectSQL("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	if err := s.migrateTo(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range [][3]string{
+		{"1", "auth0|a", "shared@example.com"},
+		{"2", "local|b", "shared@example.com"},
+		{"3", "google|c", "shared@example.com"},
+		{"4", "auth0|d", "social@example.com"},
+		{"5", "google|e", "social@example.com"},
+		{"6", "auth0|f", "alone@example.com"},
+	} {
+		if _, err := s.exec(ctx, `INSERT INTO users (id, auth0_id, email) VALUES (?, ?, ?)`, u[0], u[1], u[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	want := map[string]string{
+		"auth0|a":  "",
+		"local|b":  "shared@example.com",
+		"google|c": "",
+		"auth0|d":  "social@example.com",
+		"google|e": "",
+		"auth0|f":  "alone@example.com",
+	}
+	users := &sqlUsers{s}
+	for id, email := range want {
+		u, err := users.FindByAuth0ID(ctx, id)
+		if err != nil {
+			t.Fatalf("FindByAuth0ID(%s): %v", id, err)
+		}
+		if u.Email != email {
+			t.Errorf("%s: email = %q, want %q", id, u.Email, email)
+		}
+	}
+}
+
+func TestSQLMigrationStatus(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "status.db")
+
+	states, err := SQLMigrationStatus(ctx, "sqlite", dsn)
+	if err != nil {
+		t.Fatalf("SQLMigrationStatus: %v", err)
+	}
+	if len(states) != len(sqlMigrations) {
+		t.Fatalf("got %d versions, want %d", len(states), len(sqlMigrations))
+	}
+	for _, st := range states {
+		if st.AppliedAt != nil {
+			t.Errorf("version %d applied on a new database", st.Version)
+		}
+	}
+
+	// Status must not apply anything itself.
+	if states, _ := SQLMigrationStatus(ctx, "sqlite", dsn); states[0].AppliedAt != nil {
+		t.Error("SQLMigrationStatus applied migrations")
+	}
+
+	s, err := OpenSQL("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	states, err = SQLMigrationStatus(ctx, "sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range states {
+		if st.AppliedAt == nil {
+			t.Errorf("version %d pending after OpenSQL", st.Version)
+		}
+	}
+}
+
+// A migration whose statements partly fail leaves nothing of it applied.
+// Statements are run whole, so semicolons inside literals are kept.
+func TestSQLiteMigrationIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	s, err := connectSQL("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	if err := s.migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	released := sqlMigrations
+	t.Cleanup(func() { sqlMigrations = released })
+	sqlMigrations = append(released[:len(released):len(released)], []string{
+		`CREATE TABLE notes (body TEXT NOT NULL DEFAULT 'a; b')`,
+		`INSERT INTO missing_table VALUES (1)`,
+	})
+
+	if err := s.migrate(ctx); err == nil {
+		t.Fatal("failing migration applied")
+	}
+	var n int
+	if err := s.queryRow(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'notes'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("notes table left behind by failed migration (count %d, err %v)", n, err)
+	}
+	if err := s.queryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&n); err != nil || n != len(released) {
+		t.Errorf("schema version = %d, want %d (err %v)", n, len(released), err)
+	}
+
+	sqlMigrations[len(released)] = []string{`CREATE TABLE notes (body TEXT NOT NULL DEFAULT 'a; b')`}
+	if err := s.migrate(ctx); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := s.exec(ctx, `INSERT INTO notes DEFAULT VALUES`); err != nil {
+		t.Fatal(err)
+	}
+	var body string
+	if err := s.queryRow(ctx, `SELECT body FROM notes`).Scan(&body); err != nil || body != "a; b" {
+		t.Errorf("default body = %q, %v", body, err)
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("duplicate")
+)
+
+// UserRepository stores user profiles. A non-empty email belongs to at most
+// one user; writes that would share one fail with ErrDuplicate.
+type UserRepository interface {
+	FindByAuth0ID(ctx context.Context, auth0ID string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	ListByRole(ctx context.Context, role string) ([]models.User, error)
+	Create(ctx context.Context, u *models.User) error
+	// Provision creates the user if missing. Name and email are only set on
+	// insert; role and last login are always updated.
+	Provision(ctx context.Context, u models.User) error
+	// Sync upserts the user, overwriting every non-empty profile field.
+	Sync(ctx context.Context, u models.User) error
+	// UpdateProfile sets the given fields and returns the updated user.
+	UpdateProfile(ctx context.Context, auth0ID string, name, email *string) (*models.User, error)
+}
+
+type ClassRepository interface {
+	Create(ctx context.Context, c *models.Class) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Class, error)
+	// AddStudent enrolls the student (idempotently) and returns the class,
+	// or ErrNotFound if the class does not exist.
+	AddStudent(ctx context.Context, id primitive.ObjectID, studentID string) (*models.Class, error)
+	SetActiveRoom(ctx context.Context, id primitive.ObjectID, roomID string) error
+	ClearActiveRoom(ctx context.Context, id primitive.ObjectID) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, s *models.Session) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error)
+	End(ctx context.Context, id primitive.ObjectID, endedAt time.Time) error
+	// SaveHandRaises replaces the session's participation record.
+	SaveHandRaises(ctx context.Context, id primitive.ObjectID, raises []models.HandRaise) error
+}
+
+type AttendanceRepository interface {
+	Find(ctx context.Context, classID primitive.ObjectID, studentID string) (*models.Attendance, error)
+	// Replace stores one record per student, overwriting any earlier record
+	// for the same class and student.
+	Replace(ctx context.Context, records []models.Attendance) error
+}
+
+type ChatRepository interface {
+	Create(ctx context.Context, m *models.ChatMessage) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.ChatMessage, error)
+	// Delete marks the message deleted; it stays in the history.
+	Delete(ctx context.Context, id primitive.ObjectID, deletedBy string, deletedAt time.Time) error
+	// List returns a page of a session's history, oldest first.
+	List(ctx context.Context, q ChatQuery) ([]models.ChatMessage, error)
+}
+
+// ChatQuery selects up to Limit messages of a session sent before Before,
+// or the newest ones when Before is zero. With Viewer set, only public
+// messages and private ones sent by or to Viewer are returned.
+type ChatQuery struct {
+	SessionID      primitive.ObjectID
+	Before         primitive.ObjectID
+	Limit          int
+	Viewer         string
+	IncludeDeleted bool
+}
+
+// visible reports whether m matches the query's viewer and deletion filters.
+func (q ChatQuery) visible(m models.ChatMessage) bool {
+	if m.DeletedAt != nil && !q.IncludeDeleted {
+		return false
+	}
+	return q.Viewer == "" || m.RecipientID == "" || m.SenderID == q.Viewer || m.RecipientID == q.Viewer
+}
+
+// Store groups the repositories of one storage backend.
+type Store struct {
+	Users      UserRepository
+	Classes    ClassRepository
+	Sessions   SessionRepository
+	Attendance AttendanceRepository
+	Chat       ChatRepository
+
+	closer func() error
+	pinger func(ctx context.Context) error
+}
+
+// Ping checks that the backend is reachable. Backends without a connection
+// always succeed.
+func (s *Store) Ping(ctx context.Context) error {
+	if s.pinger == nil {
+		return nil
+	}
+	return s.pinger(ctx)
+}
+
+// Close releases connections owned by the backend, if any.
+func (s *Store) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer()
+}
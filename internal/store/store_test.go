@@ -0,0 +1,449 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The conformance suite runs every backend through the same checks. Set
+// STORE_TEST_POSTGRES_DSN to a throwaway database to include PostgreSQL;
+// each run uses fresh ids, so the database need not be empty. Set
+// STORE_TEST_MONGO_URI to include MongoDB; each run creates and drops its
+// own database.
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s, err := OpenSQL("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("OpenSQL: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	testStore(t, s)
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("STORE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("STORE_TEST_POSTGRES_DSN not set")
+	}
+	s, err := OpenSQL("postgres", dsn)
+	if err != nil {
+		t.Fatalf("OpenSQL: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	testStore(t, s)
+}
+
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv("STORE_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("STORE_TEST_MONGO_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	db := client.Database(uniq("store_test_"))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	// The indexes that enforce uniqueness come from the migrations.
+	if err := database.Migrate(ctx, db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	testStore(t, NewMongo(db))
+}
+
+func testStore(t *testing.T, s *Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, s) })
+	t.Run("Classes", func(t *testing.T) { testClasses(t, s) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, s) })
+	t.Run("Attendance", func(t *testing.T) { testAttendance(t, s) })
+	t.Run("Chat", func(t *testing.T) { testChat(t, s) })
+}
+
+// uniq returns a value no earlier run has used, so suites can share a
+// database.
+func uniq(prefix string) string {
+	return prefix + primitive.NewObjectID().Hex()
+}
+
+func testUsers(t *testing.T, s *Store) {
+	ctx := context.Background()
+	role := uniq("role-")
+	email := uniq("") + "@example.com"
+
+	u := models.User{Auth0ID: uniq("local|"), Name: "Ada", Email: email, Role: role, PasswordHash: "hash"}
+	if err := s.Users.Create(ctx, &u); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if u.ID.IsZero() {
+		t.Error("Create did not assign an ID")
+	}
+
+	got, err := s.Users.FindByAuth0ID(ctx, u.Auth0ID)
+	if err != nil {
+		t.Fatalf("FindByAuth0ID: %v", err)
+	}
+	if got.ID != u.ID || got.Name != "Ada" || got.Email != email || got.Role != role || got.PasswordHash != "hash" {
+		t.Errorf("FindByAuth0ID = %+v", got)
+	}
+	if got, err := s.Users.FindByEmail(ctx, email); err != nil || got.Auth0ID != u.Auth0ID {
+		t.Errorf("FindByEmail = %+v, %v", got, err)
+	}
+	if _, err := s.Users.FindByAuth0ID(ctx, uniq("missing|")); err != ErrNotFound {
+		t.Errorf("FindByAuth0ID(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Users.FindByEmail(ctx, uniq("")+"@example.com"); err != ErrNotFound {
+		t.Errorf("FindByEmail(missing) error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Users.Create(ctx, &models.User{Auth0ID: u.Auth0ID}); err != ErrDuplicate {
+		t.Errorf("Create(same auth0Id) error = %v, want ErrDuplicate", err)
+	}
+	if err := s.Users.Create(ctx, &models.User{Auth0ID: uniq("local|"), Email: email}); err != ErrDuplicate {
+		t.Errorf("Create(same email) error = %v, want ErrDuplicate", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Users.Create(ctx, &models.User{Auth0ID: uniq("local|"), Role: role}); err != nil {
+			t.Errorf("Create(no email #%d): %v", i+1, err)
+		}
+	}
+
+	// Provision sets name and email on insert only; the role always follows.
+	p := models.User{Auth0ID: uniq("auth0|"), Name: "Bo", Email: uniq("") + "@example.com", Role: "student"}
+	if err := s.Users.Provision(ctx, p); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if err := s.Users.Provision(ctx, models.User{Auth0ID: p.Auth0ID, Name: "Other", Email: uniq("") + "@example.com", Role: "teacher"}); err != nil {
+		t.Fatalf("Provision again: %v", err)
+	}
+	if err := s.Users.Provision(ctx, models.User{Auth0ID: p.Auth0ID}); err != nil {
+		t.Fatalf("Provision without role: %v", err)
+	}
+	got, err = s.Users.FindByAuth0ID(ctx, p.Auth0ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Bo" || got.Email != p.Email || got.Role != "teacher" || got.LastLoginAt.IsZero() {
+		t.Errorf("after Provision: %+v", got)
+	}
+	if err := s.Users.Provision(ctx, models.User{Auth0ID: uniq("auth0|"), Email: email}); err != ErrDuplicate {
+		t.Errorf("Provision(taken email) error = %v, want ErrDuplicate", err)
+	}
+
+	// Sync overwrites non-empty fields only.
+	if err := s.Users.Sync(ctx, models.User{Auth0ID: p.Auth0ID, Name: "Bea"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	got, err = s.Users.FindByAuth0ID(ctx, p.Auth0ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Bea" || got.Email != p.Email || got.Role != "teacher" {
+		t.Errorf("after Sync: %+v", got)
+	}
+	if err := s.Users.Sync(ctx, models.User{Auth0ID: p.Auth0ID, Email: email}); err != ErrDuplicate {
+		t.Errorf("Sync(taken email) error = %v, want ErrDuplicate", err)
+	}
+
+	name, newEmail := "Ada L", uniq("")+"@example.com"
+	got, err = s.Users.UpdateProfile(ctx, u.Auth0ID, &name, &newEmail)
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if got.Name != name || got.Email != newEmail || got.Role != role {
+		t.Errorf("UpdateProfile = %+v", got)
+	}
+	if _, err := s.Users.UpdateProfile(ctx, u.Auth0ID, nil, &p.Email); err != ErrDuplicate {
+		t.Errorf("UpdateProfile(taken email) error = %v, want ErrDuplicate", err)
+	}
+	if _, err := s.Users.UpdateProfile(ctx, uniq("missing|"), &name, nil); err != ErrNotFound {
+		t.Errorf("UpdateProfile(missing) error = %v, want ErrNotFound", err)
+	}
+
+	users, err := s.Users.ListByRole(ctx, role)
+	if err != nil {
+		t.Fatalf("ListByRole: %v", err)
+	}
+	if len(users) != 3 {
+		t.Errorf("ListByRole returned %d users, want 3", len(users))
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i-1].ID.Hex() > users[i].ID.Hex() {
+			t.Error("ListByRole not ordered by id")
+		}
+	}
+}
+
+func testClasses(t *testing.T, s *Store) {
+	ctx := context.Background()
+
+	c := models.Class{ClassName: "Physics", TeacherID: "t1", StudentIDs: []string{"s2", "s1"}}
+	if err := s.Classes.Create(ctx, &c); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	got, err := s.Classes.FindByID(ctx, c.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.ClassName != "Physics" || got.TeacherID != "t1" || !equalStrings(got.StudentIDs, []string{"s2", "s1"}) {
+		t.Errorf("FindByID = %+v", got)
+	}
+	if _, err := s.Classes.FindByID(ctx, primitive.NewObjectID()); err != ErrNotFound {
+		t.Errorf("FindByID(missing) error = %v, want ErrNotFound", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err = s.Classes.AddStudent(ctx, c.ID, "s3")
+		if err != nil {
+			t.Fatalf("AddStudent: %v", err)
+		}
+		if !equalStrings(got.StudentIDs, []string{"s2", "s1", "s3"}) {
+			t.Errorf("AddStudent #%d: studentIds = %v", i+1, got.StudentIDs)
+		}
+	}
+	if _, err := s.Classes.AddStudent(ctx, primitive.NewObjectID(), "s1"); err != ErrNotFound {
+		t.Errorf("AddStudent(missing class) error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Classes.SetActiveRoom(ctx, c.ID, "room1"); err != nil {
+		t.Fatalf("SetActiveRoom: %v", err)
+	}
+	if got, _ := s.Classes.FindByID(ctx, c.ID); got.ActiveRoomID != "room1" {
+		t.Errorf("ActiveRoomID = %q, want room1", got.ActiveRoomID)
+	}
+	if err := s.Classes.ClearActiveRoom(ctx, c.ID); err != nil {
+		t.Fatalf("ClearActiveRoom: %v", err)
+	}
+	if got, _ := s.Classes.FindByID(ctx, c.ID); got.ActiveRoomID != "" {
+		t.Errorf("ActiveRoomID = %q after clear", got.ActiveRoomID)
+	}
+	if err := s.Classes.SetActiveRoom(ctx, primitive.NewObjectID(), "room1"); err != ErrNotFound {
+		t.Errorf("SetActiveRoom(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := s.Classes.ClearActiveRoom(ctx, primitive.NewObjectID()); err != nil {
+		t.Errorf("ClearActiveRoom(missing): %v", err)
+	}
+}
+
+// newClass creates a class for tests of the repositories that reference
+// one.
+func newClass(t *testing.T, s *Store) primitive.ObjectID {
+	t.Helper()
+	c := models.Class{ClassName: "Chemistry", TeacherID: "t1", StudentIDs: []string{}}
+	if err := s.Classes.Create(context.Background(), &c); err != nil {
+		t.Fatalf("create class: %v", err)
+	}
+	return c.ID
+}
+
+func newSession(t *testing.T, s *Store, classID primitive.ObjectID) primitive.ObjectID {
+	t.Helper()
+	sess := models.Session{ClassID: classID, TeacherID: "t1", RoomID: "room1", StartedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := s.Sessions.Create(context.Background(), &sess); err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	return sess.ID
+}
+
+func testSessions(t *testing.T, s *Store) {
+	ctx := context.Background()
+	classID := newClass(t, s)
+	start := time.Now().UTC().Truncate(time.Second)
+
+	sess := models.Session{ClassID: classID, TeacherID: "t1", RoomID: "room1", StartedAt: start}
+	if err := s.Sessions.Create(ctx, &sess); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	got, err := s.Sessions.FindByID(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.ClassID != classID || got.TeacherID != "t1" || got.RoomID != "room1" || !got.StartedAt.Equal(start) || got.EndedAt != nil {
+		t.Errorf("FindByID = %+v", got)
+	}
+	if _, err := s.Sessions.FindByID(ctx, primitive.NewObjectID()); err != ErrNotFound {
+		t.Errorf("FindByID(missing) error = %v, want ErrNotFound", err)
+	}
+
+	resolved := start.Add(time.Minute)
+	raises := []models.HandRaise{
+		{StudentID: "s1", RaisedAt: start, ResolvedAt: &resolved, Outcome: "acknowledged"},
+		{StudentID: "s2", RaisedAt: start.Add(time.Second)},
+	}
+	if err := s.Sessions.SaveHandRaises(ctx, sess.ID, raises); err != nil {
+		t.Fatalf("SaveHandRaises: %v", err)
+	}
+	if err := s.Sessions.SaveHandRaises(ctx, sess.ID, raises[1:]); err != nil {
+		t.Fatalf("SaveHandRaises again: %v", err)
+	}
+	end := start.Add(time.Hour)
+	if err := s.Sessions.End(ctx, sess.ID, end); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+
+	got, err = s.Sessions.FindByID(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EndedAt == nil || !got.EndedAt.Equal(end) {
+		t.Errorf("EndedAt = %v, want %v", got.EndedAt, end)
+	}
+	if len(got.HandRaises) != 1 || got.HandRaises[0].StudentID != "s2" || got.HandRaises[0].ResolvedAt != nil {
+		t.Errorf("HandRaises = %+v, want only the open raise of s2", got.HandRaises)
+	}
+}
+
+func testAttendance(t *testing.T, s *Store) {
+	ctx := context.Background()
+	classID := newClass(t, s)
+	sessionID := newSession(t, s, classID)
+
+	if _, err := s.Attendance.Find(ctx, classID, "s1"); err != ErrNotFound {
+		t.Errorf("Find(missing) error = %v, want ErrNotFound", err)
+	}
+
+	err := s.Attendance.Replace(ctx, []models.Attendance{
+		{ClassID: classID, SessionID: sessionID, StudentID: "s1", Status: "present"},
+		{ClassID: classID, SessionID: sessionID, StudentID: "s2", Status: "absent"},
+	})
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	err = s.Attendance.Replace(ctx, []models.Attendance{
+		{ClassID: classID, SessionID: sessionID, StudentID: "s2", Status: "present"},
+	})
+	if err != nil {
+		t.Fatalf("Replace again: %v", err)
+	}
+
+	for student, want := range map[string]string{"s1": "present", "s2": "present"} {
+		got, err := s.Attendance.Find(ctx, classID, student)
+		if err != nil {
+			t.Fatalf("Find(%s): %v", student, err)
+		}
+		if got.Status != want || got.SessionID != sessionID || got.ID.IsZero() {
+			t.Errorf("Find(%s) = %+v, want status %s", student, got, want)
+		}
+	}
+}
+
+func testChat(t *testing.T, s *Store) {
+	ctx := context.Background()
+	classID := newClass(t, s)
+	sessionID := newSession(t, s, classID)
+	sent := time.Now().UTC().Truncate(time.Second)
+
+	send := func(sender, recipient, text string) primitive.ObjectID {
+		t.Helper()
+		m := models.ChatMessage{SessionID: sessionID, ClassID: classID, SenderID: sender, RecipientID: recipient, Text: text, SentAt: sent}
+		if err := s.Chat.Create(ctx, &m); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return m.ID
+	}
+	public1 := send("t1", "", "one")
+	private := send("s1", "t1", "two")
+	public2 := send("s2", "", "three")
+	deleted := send("s2", "", "four")
+
+	got, err := s.Chat.FindByID(ctx, private)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if got.SenderID != "s1" || got.RecipientID != "t1" || got.Text != "two" || !got.SentAt.Equal(sent) {
+		t.Errorf("FindByID = %+v", got)
+	}
+	if _, err := s.Chat.FindByID(ctx, primitive.NewObjectID()); err != ErrNotFound {
+		t.Errorf("FindByID(missing) error = %v, want ErrNotFound", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Chat.Delete(ctx, deleted, "t1", sent); err != nil {
+			t.Fatalf("Delete #%d: %v", i+1, err)
+		}
+	}
+	if err := s.Chat.Delete(ctx, primitive.NewObjectID(), "t1", sent); err != ErrNotFound {
+		t.Errorf("Delete(missing) error = %v, want ErrNotFound", err)
+	}
+	if got, _ := s.Chat.FindByID(ctx, deleted); got == nil || got.DeletedAt == nil || got.DeletedBy != "t1" {
+		t.Errorf("deleted message = %+v", got)
+	}
+
+	list := func(q ChatQuery) []primitive.ObjectID {
+		t.Helper()
+		q.SessionID = sessionID
+		if q.Limit == 0 {
+			q.Limit = 10
+		}
+		messages, err := s.Chat.List(ctx, q)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		ids := []primitive.ObjectID{}
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	for name, tc := range map[string]struct {
+		q    ChatQuery
+		want []primitive.ObjectID
+	}{
+		"teacher":          {ChatQuery{IncludeDeleted: true}, []primitive.ObjectID{public1, private, public2, deleted}},
+		"sender":           {ChatQuery{Viewer: "s1"}, []primitive.ObjectID{public1, private, public2}},
+		"other student":    {ChatQuery{Viewer: "s2"}, []primitive.ObjectID{public1, public2}},
+		"newest page":      {ChatQuery{Limit: 2}, []primitive.ObjectID{private, public2}},
+		"page before":      {ChatQuery{Before: private, Limit: 2}, []primitive.ObjectID{public1}},
+		"before first":     {ChatQuery{Before: public1}, []primitive.ObjectID{}},
+		"deleted excluded": {ChatQuery{}, []primitive.ObjectID{public1, private, public2}},
+	} {
+		if got := list(tc.q); !equalIDs(got, tc.want) {
+			t.Errorf("%s: List = %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalIDs(a, b []primitive.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MongoTracer records a client span for every MongoDB command, parented to
+// the span in the operation's context. Wire its methods into an
+// event.CommandMonitor.
+type MongoTracer struct {
+	spans sync.Map // "connID/requestID" -> trace.Span
+}
+
+func mongoKey(connID string, requestID int64) string {
+	return fmt.Sprintf("%s/%d", connID, requestID)
+}
+
+func (t *MongoTracer) Started(ctx context.Context, e *event.CommandStartedEvent) {
+	_, span := Tracer().Start(ctx, "mongo."+e.CommandName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.namespace", e.DatabaseName),
+			attribute.String("db.operation.name", e.CommandName),
+		),
+	)
+	t.spans.Store(mongoKey(e.ConnectionID, e.RequestID), span)
+}
+
+func (t *MongoTracer) Succeeded(_ context.Context, e *event.CommandSucceededEvent) {
+	if span, ok := t.spans.LoadAndDelete(mongoKey(e.ConnectionID, e.RequestID)); ok {
+		span.(trace.Span).End()
+	}
+}
+
+func (t *MongoTracer) Failed(_ context.Context, e *event.CommandFailedEvent) {
+	if span, ok := t.spans.LoadAndDelete(mongoKey(e.ConnectionID, e.RequestID)); ok {
+		s := span.(trace.Span)
+		s.SetStatus(codes.Error, e.Failure)
+		s.End()
+	}
+}
@@ -0,0 +1,118 @@
+package users
+
+import (
+	"context"
+	"sync"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// Provisioner keeps the users collection in step with the tokens callers
+// present.
+type Provisioner struct {
+	store         *store.Store
+	auth0         *utils.Auth0Client
+	fetchUserInfo bool
+	// storedRoles is set for the local provider, whose tokens copy the
+	// stored role and could undo a promotion made since they were issued.
+	storedRoles bool
+
+	// provisioned maps each subject that already has a users document to
+	// the role last written, so the upsert only runs again when the role
+	// changes.
+	provisioned sync.Map
+}
+
+// NewProvisioner returns a Provisioner writing users to st.
+func NewProvisioner(cfg config.Auth, st *store.Store) *Provisioner {
+	return &Provisioner{
+		store:         st,
+		auth0:         utils.NewAuth0Client(cfg.Auth0),
+		fetchUserInfo: cfg.Auth0.FetchUserInfo,
+		storedRoles:   cfg.Provider == "local",
+	}
+}
+
+// Provision creates the users document for the token subject if it does not
+// exist yet. Name and email are only written on insert so that profile edits
+// are not overwritten; the role follows the token unless roles are stored.
+// When they are, claims.Role is replaced with the stored role so that a
+// change applies to tokens issued before it.
+func (p *Provisioner) Provision(ctx context.Context, accessToken string, claims *utils.Claims) error {
+	if claims.UserID == "" {
+		return nil
+	}
+	if p.storedRoles {
+		u, err := p.store.Users.FindByAuth0ID(ctx, claims.UserID)
+		switch {
+		case err == nil && u.Role != "":
+			claims.Role = u.Role
+		case err != nil && err != store.ErrNotFound:
+			return err
+		}
+	}
+	if role, ok := p.provisioned.Load(claims.UserID); ok && role == claims.Role {
+		return nil
+	}
+
+	name, email := claims.Name, claims.Email
+	if (name == "" || email == "") && p.fetchUserInfo {
+		info, err := p.auth0.FetchUserInfo(ctx, accessToken)
+		if err != nil {
+			logging.FromContext(ctx).Warn("userinfo lookup failed", "error", err)
+		} else {
+			if name == "" {
+				name = info.Name
+			}
+			if email == "" {
+				email = info.Email
+			}
+		}
+	}
+
+	role := claims.Role
+	if p.storedRoles {
+		role = ""
+	}
+	u := models.User{Auth0ID: claims.UserID, Name: name, Email: email, Role: role}
+	err := p.store.Users.Provision(ctx, u)
+	if err == store.ErrDuplicate && u.Email != "" {
+		// Emails are unique, but two Auth0 identities (e.g. a social and a
+		// database login) may share one. Keep the second without it.
+		logging.FromContext(ctx).Warn("email belongs to another account, provisioning without it", "email", u.Email)
+		u.Email = ""
+		err = p.store.Users.Provision(ctx, u)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.provisioned.Store(claims.UserID, claims.Role)
+	return nil
+}
+
+// Sync overwrites the stored profile with the values Auth0 reports, creating
+// the document if needed. Empty fields are left untouched.
+func (p *Provisioner) Sync(ctx context.Context, u models.User) error {
+	err := p.store.Users.Sync(ctx, u)
+	if err == store.ErrDuplicate && u.Email != "" {
+		logging.FromContext(ctx).Warn("email belongs to another account, syncing without it", "email", u.Email)
+		u.Email = ""
+		err = p.store.Users.Sync(ctx, u)
+	}
+	if err != nil {
+		return err
+	}
+
+	if u.Role != "" {
+		p.provisioned.Store(u.Auth0ID, u.Role)
+	} else {
+		// The stored role is unknown; let the next request write it.
+		p.provisioned.Delete(u.Auth0ID)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// A second Auth0 identity with an email another account already has is
+// provisioned without it rather than failing on every request.
+func TestProvisionSharedEmail(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	p := NewProvisioner(config.Auth{Provider: "auth0"}, st)
+
+	first := &utils.Claims{UserID: "auth0|1", Role: "student", Name: "Ada", Email: "ada@example.com"}
+	second := &utils.Claims{UserID: "google-oauth2|1", Role: "student", Name: "Ada", Email: "ada@example.com"}
+	for _, c := range []*utils.Claims{first, second} {
+		if err := p.Provision(ctx, "", c); err != nil {
+			t.Fatalf("Provision(%s): %v", c.UserID, err)
+		}
+	}
+
+	u, err := st.Users.FindByAuth0ID(ctx, second.UserID)
+	if err != nil {
+		t.Fatalf("second identity not stored: %v", err)
+	}
+	if u.Email != "" || u.Name != "Ada" {
+		t.Errorf("second identity = %+v, want name only", u)
+	}
+	if u, _ := st.Users.FindByEmail(ctx, "ada@example.com"); u == nil || u.Auth0ID != first.UserID {
+		t.Errorf("email owner = %+v, want %s", u, first.UserID)
+	}
+
+	if err := p.Sync(ctx, models.User{Auth0ID: second.UserID, Email: "ada@example.com", Name: "Ada L"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if u, _ := st.Users.FindByAuth0ID(ctx, second.UserID); u.Name != "Ada L" || u.Email != "" {
+		t.Errorf("after Sync = %+v", u)
+	}
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+)
+
+// ErrInvalidCredentials is returned when Auth0 rejects a password login.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrUserExists is returned when Auth0 refuses a signup for a known email.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrSignupRejected is returned for any other signup refusal, such as a
+// password that fails the connection's strength policy.
+var ErrSignupRejected = errors.New("signup rejected")
+
+type auth0Error struct {
+	Code        string `json:"code"`
+	Error       string `json:"error"`
+	Description string `json:"description"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token,omitempty"`
+	ExpiresIn   int    `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+var auth0Client = &http.Client{Timeout: 10 * time.Second}
+
+// Auth0Client calls the Auth0 Authentication API of one tenant.
+type Auth0Client struct {
+	cfg config.Auth0
+}
+
+func NewAuth0Client(cfg config.Auth0) *Auth0Client {
+	return &Auth0Client{cfg: cfg}
+}
+
+func (a *Auth0Client) connection() string {
+	if a.cfg.Connection != "" {
+		return a.cfg.Connection
+	}
+	return "Username-Password-Authentication"
+}
+
+func (a *Auth0Client) post(ctx context.Context, path string, body interface{}, out interface{}) (int, *auth0Error, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	url := fmt.Sprintf("https://%s%s", a.cfg.Domain, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := auth0Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var apiErr auth0Error
+		json.NewDecoder(res.Body).Decode(&apiErr)
+		return res.StatusCode, &apiErr, nil
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, nil, err
+		}
+	}
+	return res.StatusCode, nil, nil
+}
+
+// PasswordLogin exchanges an email and password for tokens using the
+// password-realm grant against the configured database connection.
+func (a *Auth0Client) PasswordLogin(ctx context.Context, email, password string) (*TokenResponse, error) {
+	var tokens TokenResponse
+	status, _, err := a.post(ctx, "/oauth/token", map[string]string{
+		"grant_type":    "http://auth0.com/oauth/grant-type/password-realm",
+		"realm":         a.connection(),
+		"username":      email,
+		"password":      password,
+		"audience":      a.cfg.Audience,
+		"scope":         "openid profile email",
+		"client_id":     a.cfg.ClientID,
+		"client_secret": a.cfg.ClientSecret,
+	}, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case status == http.StatusForbidden || status == http.StatusUnauthorized:
+		return nil, ErrInvalidCredentials
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("auth0 token endpoint returned %d", status)
+	}
+	return &tokens, nil
+}
+
+// Signup creates a database-connection user and returns its Auth0 user ID.
+// No role is sent: user_metadata is editable by the user, so the post-login
+// Action must take the role from app_metadata.
+func (a *Auth0Client) Signup(ctx context.Context, name, email, password string) (string, error) {
+	var created struct {
+		ID string `json:"_id"`
+	}
+	status, apiErr, err := a.post(ctx, "/dbconnections/signup", map[string]interface{}{
+		"client_id":  a.cfg.ClientID,
+		"connection": a.connection(),
+		"email":      email,
+		"password":   password,
+		"name":       name,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case apiErr != nil && (apiErr.Code == "user_exists" || apiErr.Code == "invalid_signup"):
+		return "", ErrUserExists
+	case status == http.StatusBadRequest:
+		return "", fmt.Errorf("%w: %s", ErrSignupRejected, apiErr.Description)
+	case status != http.StatusOK:
+		return "", fmt.Errorf("auth0 signup returned %d", status)
+	}
+	return "auth0|" + created.ID, nil
+}
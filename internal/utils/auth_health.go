@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+)
+
+// keySet remembers where a JWKS came from and the last background refresh
+// failure, so readiness checks can report on it.
+type keySet struct {
+	source string
+	jwks   *keyfunc.JWKS
+
+	mu        sync.Mutex
+	lastErr   string
+	lastErrAt time.Time
+}
+
+func (k *keySet) refreshFailed(err error) {
+	slog.Warn("JWKS refresh failed", "source", k.source, "error", err)
+	k.mu.Lock()
+	k.lastErr = err.Error()
+	k.lastErrAt = time.Now().UTC()
+	k.mu.Unlock()
+}
+
+// AuthHealth is the state of the token verification keys.
+type AuthHealth struct {
+	Status   string `json:"status"`
+	Provider string `json:"provider"`
+	Source   string `json:"source,omitempty"`
+	Keys     int    `json:"keys,omitempty"`
+	// LastRefreshError is the most recent background refresh failure. The
+	// cached keys stay in use, so it does not by itself make auth unready.
+	LastRefreshError string     `json:"lastRefreshError,omitempty"`
+	LastRefreshErrAt *time.Time `json:"lastRefreshErrorAt,omitempty"`
+}
+
+// CheckAuth reports "down" when auth is nil or its JWKS holds no keys, since
+// no token could then be verified.
+func CheckAuth(auth Authenticator, provider string) AuthHealth {
+	h := AuthHealth{Status: "up", Provider: provider}
+
+	if auth == nil {
+		h.Status = "down"
+		return h
+	}
+	a, ok := auth.(*jwtAuthenticator)
+	if !ok || a.keys == nil {
+		// Shared-secret providers have nothing to fetch.
+		return h
+	}
+
+	k := a.keys
+	h.Source = k.source
+	h.Keys = k.jwks.Len()
+	if h.Keys == 0 {
+		h.Status = "down"
+	}
+
+	k.mu.Lock()
+	if k.lastErr != "" {
+		at := k.lastErrAt
+		h.LastRefreshError = k.lastErr
+		h.LastRefreshErrAt = &at
+	}
+	k.mu.Unlock()
+	return h
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var asymmetricMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256"}
+
+// NewAuth0Authenticator validates RS256 access tokens issued by an Auth0
+// tenant, reading the role from the namespaced custom claim.
+func NewAuth0Authenticator(domain, audience, namespace string) (Authenticator, error) {
+	if domain == "" {
+		return nil, errors.New("AUTH0_DOMAIN is required")
+	}
+
+	ks := &keySet{source: fmt.Sprintf("https://%s/.well-known/jwks.json", domain)}
+	jwks, err := keyfunc.Get(ks.source, keyfunc.Options{
+		RefreshInterval:     time.Hour,
+		RefreshUnknownKID:   true,
+		RefreshErrorHandler: ks.refreshFailed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ks.jwks = jwks
+
+	return &jwtAuthenticator{
+		keyfunc: jwks.Keyfunc,
+		keys:    ks,
+		options: []jwt.ParserOption{
+			jwt.WithAudience(audience),
+			jwt.WithIssuer(fmt.Sprintf("https://%s/", domain)),
+			jwt.WithValidMethods([]string{"RS256"}),
+		},
+		roleClaim: namespace + "/role",
+		namespace: namespace,
+	}, nil
+}
+
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// NewOIDCAuthenticator discovers the JWKS endpoint of any OpenID Connect
+// issuer and validates its tokens. Tokens must be issued for audience, so
+// those minted for other clients of the same issuer are refused.
+func NewOIDCAuthenticator(issuer, audience, roleClaim string) (Authenticator, error) {
+	if issuer == "" {
+		return nil, errors.New("AUTH_ISSUER is required for the oidc provider")
+	}
+	if audience == "" {
+		return nil, errors.New("AUTH_AUDIENCE is required for the oidc provider")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery returned %d", res.StatusCode)
+	}
+
+	var doc oidcDiscovery
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document has no jwks_uri")
+	}
+
+	ks := &keySet{source: doc.JWKSURI}
+	jwks, err := keyfunc.Get(doc.JWKSURI, keyfunc.Options{
+		RefreshInterval:     time.Hour,
+		RefreshUnknownKID:   true,
+		RefreshErrorHandler: ks.refreshFailed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ks.jwks = jwks
+
+	return &jwtAuthenticator{
+		keyfunc: jwks.Keyfunc,
+		keys:    ks,
+		options: []jwt.ParserOption{
+			jwt.WithAudience(audience),
+			jwt.WithIssuer(doc.Issuer),
+			jwt.WithValidMethods(asymmetricMethods),
+		},
+		roleClaim: roleClaim,
+	}, nil
+}
+
+// NewJWKSFileAuthenticator validates tokens against a static JWKS document on
+// disk, for air-gapped installs and tests. The file may hold keys of several
+// issuers, so both the issuer and the audience are required.
+func NewJWKSFileAuthenticator(path, issuer, audience, roleClaim string) (Authenticator, error) {
+	if path == "" {
+		return nil, errors.New("AUTH_JWKS_FILE is required for the jwks_file provider")
+	}
+	if issuer == "" || audience == "" {
+		return nil, errors.New("AUTH_ISSUER and AUTH_AUDIENCE are required for the jwks_file provider")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks, err := keyfunc.NewJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtAuthenticator{
+		keyfunc: jwks.Keyfunc,
+		keys:    &keySet{source: path, jwks: jwks},
+		options: []jwt.ParserOption{
+			jwt.WithAudience(audience),
+			jwt.WithIssuer(issuer),
+			jwt.WithValidMethods(asymmetricMethods),
+		},
+		roleClaim: roleClaim,
+	}, nil
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLocalAuthenticatorRoundTrip(t *testing.T) {
+	a := NewLocalAuthenticator("0123456789abcdef0123456789abcdef")
+
+	token, err := a.Mint(Claims{UserID: "u1", Role: "teacher", Name: "Ada", Email: "ada@example.com"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := a.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != "u1" || claims.Role != "teacher" || claims.Name != "Ada" || claims.Email != "ada@example.com" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if claims.ExpiresAt.IsZero() {
+		t.Error("ExpiresAt not set")
+	}
+}
+
+func TestLocalAuthenticatorRejects(t *testing.T) {
+	a := NewLocalAuthenticator("0123456789abcdef0123456789abcdef")
+	other := NewLocalAuthenticator("fedcba9876543210fedcba9876543210")
+
+	wrongSecret, _ := other.Mint(Claims{UserID: "u1", Role: "student"}, time.Minute)
+	expired, _ := a.Mint(Claims{UserID: "u1", Role: "student"}, -time.Minute)
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": localIssuer, "sub": "u1",
+	}).SignedString([]byte("0123456789abcdef0123456789abcdef"))
+	wrongIssuer, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": "someone-else", "sub": "u1", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("0123456789abcdef0123456789abcdef"))
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iss": localIssuer, "sub": "u1", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	for name, token := range map[string]string{
+		"wrong secret": wrongSecret,
+		"expired":      expired,
+		"no expiry":    noExpiry,
+		"wrong issuer": wrongIssuer,
+		"alg none":     unsigned,
+		"garbage":      "not-a-jwt",
+	} {
+		if _, err := a.ValidateToken(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+// writeJWKS writes the public half of key as a JWKS file with the given kid.
+func writeJWKS(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	doc := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWKSFileAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeJWKS(t, key, "k1")
+
+	a, err := NewJWKSFileAuthenticator(path, "https://issuer.test/", "liveclassroom", "https://liveclassroom.app/role")
+	if err != nil {
+		t.Fatalf("NewJWKSFileAuthenticator: %v", err)
+	}
+
+	valid := jwt.MapClaims{
+		"iss":                            "https://issuer.test/",
+		"aud":                            "liveclassroom",
+		"sub":                            "auth0|1",
+		"https://liveclassroom.app/role": "student",
+		"exp":                            time.Now().Add(time.Minute).Unix(),
+	}
+	claims, err := a.ValidateToken(signRS256(t, key, "k1", valid))
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != "auth0|1" || claims.Role != "student" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(k string, v interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{}
+		for key, val := range valid {
+			c[key] = val
+		}
+		c[k] = v
+		return c
+	}
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString([]byte("secret"))
+
+	for name, token := range map[string]string{
+		"unknown key":    signRS256(t, otherKey, "k1", valid),
+		"wrong issuer":   signRS256(t, key, "k1", with("iss", "https://evil.test/")),
+		"wrong audience": signRS256(t, key, "k1", with("aud", "other")),
+		"expired":        signRS256(t, key, "k1", with("exp", time.Now().Add(-time.Minute).Unix())),
+		"symmetric alg":  hs256,
+	} {
+		if _, err := a.ValidateToken(token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestJWKSFileAuthenticatorMissingFile(t *testing.T) {
+	if _, err := NewJWKSFileAuthenticator(filepath.Join(t.TempDir(), "missing.json"), "https://issuer.test/", "liveclassroom", "role"); err == nil {
+		t.Error("missing JWKS file accepted")
+	}
+}
+
+func TestJWKSFileAuthenticatorRequiresIssuerAndAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeJWKS(t, key, "k1")
+
+	if _, err := NewJWKSFileAuthenticator(path, "", "liveclassroom", "role"); err == nil {
+		t.Error("JWKS file without issuer accepted")
+	}
+	if _, err := NewJWKSFileAuthenticator(path, "https://issuer.test/", "", "role"); err == nil {
+		t.Error("JWKS file without audience accepted")
+	}
+}
@@ -3,43 +3,72 @@ package utils
 import (
 	"errors"
 	"fmt"
-	"os"
+	"time"
 
-	"github.com/MicahParks/keyfunc/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
 )
 
 type Claims struct {
-	UserID string
-	Role   string
+	UserID    string
+	Role      string
+	Name      string
+	Email     string
+	ExpiresAt time.Time // zero when the token has no exp claim
 }
 
-var jwks *keyfunc.JWKS
-
-func InitJWKS() error {
-	domain := os.Getenv("AUTH0_DOMAIN")
-	jwksURL := fmt.Sprintf("https://%s/.well-known/jwks.json", domain)
-
-	var err error
-	jwks, err = keyfunc.Get(jwksURL, keyfunc.Options{})
-	return err
+// Authenticator validates a bearer token and extracts the caller's claims.
+type Authenticator interface {
+	ValidateToken(tokenString string) (*Claims, error)
 }
 
-func ValidateToken(tokenString string) (*Claims, error) {
-	if jwks == nil {
-		return nil, errors.New("JWKS not initialized")
+// NewAuthenticator creates the token provider named by cfg.Provider: auth0,
+// oidc, jwks_file, local or dev.
+func NewAuthenticator(cfg config.Auth) (Authenticator, error) {
+	var (
+		a   Authenticator
+		err error
+	)
+
+	switch cfg.Provider {
+	case "auth0":
+		a, err = NewAuth0Authenticator(cfg.Auth0.Domain, cfg.Auth0.Audience, cfg.Auth0.Namespace)
+	case "oidc":
+		a, err = NewOIDCAuthenticator(cfg.Issuer, cfg.Audience, cfg.RoleClaim)
+	case "jwks_file":
+		a, err = NewJWKSFileAuthenticator(cfg.JWKSFile, cfg.Issuer, cfg.Audience, cfg.RoleClaim)
+	case "local":
+		if len(cfg.LocalSecret) < 32 {
+			return nil, errors.New("AUTH_LOCAL_SECRET must be at least 32 characters")
+		}
+		a = NewLocalAuthenticator(cfg.LocalSecret)
+	case "dev":
+		if cfg.DevSecret == "" {
+			return nil, errors.New("AUTH_DEV_SECRET is required for the dev provider")
+		}
+		a = NewLocalAuthenticator(cfg.DevSecret)
+	default:
+		return nil, fmt.Errorf("unknown AUTH_PROVIDER %q", cfg.Provider)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
 
-	domain := os.Getenv("AUTH0_DOMAIN")
-	audience := os.Getenv("AUTH0_AUDIENCE")
-	namespace := os.Getenv("AUTH0_NAMESPACE")
-	issuer := fmt.Sprintf("https://%s/", domain)
+// jwtAuthenticator verifies JWTs signed with a JWKS and maps the configured
+// claims onto Claims. The auth0, oidc and jwks_file providers are built on
+// it; local and dev use LocalAuthenticator.
+type jwtAuthenticator struct {
+	keyfunc   jwt.Keyfunc
+	keys      *keySet
+	options   []jwt.ParserOption
+	roleClaim string
+	namespace string
+}
 
-	token, err := jwt.Parse(tokenString, jwks.Keyfunc,
-		jwt.WithAudience(audience),
-		jwt.WithIssuer(issuer),
-		jwt.WithValidMethods([]string{"RS256"}),
-	)
+func (a *jwtAuthenticator) ValidateToken(tokenString string) (*Claims, error) {
+	token, err := jwt.Parse(tokenString, a.keyfunc, a.options...)
 	if err != nil || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
@@ -49,8 +78,30 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid claims")
 	}
 
+	return claimsFromMap(mapClaims, a.roleClaim, a.namespace), nil
+}
+
+func claimsFromMap(mapClaims jwt.MapClaims, roleClaim, namespace string) *Claims {
 	sub, _ := mapClaims["sub"].(string)
-	role, _ := mapClaims[namespace+"/role"].(string)
+	role, _ := mapClaims[roleClaim].(string)
 
-	return &Claims{UserID: sub, Role: role}, nil
+	// Access tokens only carry profile fields when a post-login Action adds
+	// them under the namespace; fall back to the standard OIDC claims.
+	var name, email string
+	if namespace != "" {
+		name, _ = mapClaims[namespace+"/name"].(string)
+		email, _ = mapClaims[namespace+"/email"].(string)
+	}
+	if name == "" {
+		name, _ = mapClaims["name"].(string)
+	}
+	if email == "" {
+		email, _ = mapClaims["email"].(string)
+	}
+
+	claims := &Claims{UserID: sub, Role: role, Name: name, Email: email}
+	if exp, err := mapClaims.GetExpirationTime(); err == nil && exp != nil {
+		claims.ExpiresAt = exp.Time
+	}
+	return claims
 }
@@ -1,6 +1,11 @@
 package utils
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
 
 func SuccessResponse(c *gin.Context, statusCode int, data interface{}) {
 	c.JSON(statusCode, gin.H{
@@ -15,3 +20,14 @@ func ErrorResponse(c *gin.Context, statusCode int, message string) {
 		"error":   message,
 	})
 }
+
+// BindErrorResponse answers a request whose JSON body could not be bound:
+// 413 if the body was cut off by BodyLimit, 400 otherwise.
+func BindErrorResponse(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		ErrorResponse(c, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	ErrorResponse(c, 400, "Invalid request schema")
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type UserInfo struct {
+	Sub   string `json:"sub"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+var userInfoClient = &http.Client{Timeout: 5 * time.Second}
+
+// FetchUserInfo calls the Auth0 /userinfo endpoint with the caller's access
+// token. It only succeeds for tokens issued with the openid scope.
+func (a *Auth0Client) FetchUserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	url := fmt.Sprintf("https://%s/userinfo", a.cfg.Domain)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	res, err := userInfoClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo returned %d", res.StatusCode)
+	}
+
+	var info UserInfo
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
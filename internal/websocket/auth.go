@@ -0,0 +1,193 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+const (
+	ticketTTL      = 30 * time.Second
+	authTimeout    = 10 * time.Second
+	reauthWindow   = time.Minute
+	expiryInterval = 5 * time.Second
+
+	// bearerProtocol is the subprotocol clients offer alongside their token:
+	// new WebSocket(url, ["bearer", token]).
+	bearerProtocol = "bearer"
+
+	closeAuthFailed  = 4001
+	closeAuthExpired = 4002
+)
+
+// IssueTicket hands out a short-lived, single-use ticket that can be passed
+// as ?ticket= on the upgrade request instead of the bearer token.
+func (h *Hub) IssueTicket(c *gin.Context) {
+	claims, ok := c.MustGet("claims").(*utils.Claims)
+	if !ok {
+		utils.ErrorResponse(c, 401, "Unauthorized, token missing or invalid")
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		utils.ErrorResponse(c, 500, "Failed to issue ticket")
+		return
+	}
+	id := hex.EncodeToString(buf)
+
+	// Tickets live in the broker so the upgrade request may land on any
+	// instance.
+	data, _ := json.Marshal(claims)
+	if err := h.bus.Set(c.Request.Context(), "ticket:"+id, data, ticketTTL); err != nil {
+		utils.ErrorResponse(c, 500, "Failed to issue ticket")
+		return
+	}
+
+	utils.SuccessResponse(c, 200, gin.H{
+		"ticket":    id,
+		"expiresIn": int(ticketTTL.Seconds()),
+	})
+}
+
+func (h *Hub) redeemTicket(ctx context.Context, id string) (*utils.Claims, bool) {
+	data, err := h.bus.Take(ctx, "ticket:"+id)
+	if err != nil {
+		return nil, false
+	}
+	var claims utils.Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// authenticateRequest resolves credentials presented on the upgrade request.
+// It returns nil claims and no error when none were presented, in which case
+// the client must send an AUTH event after connecting.
+func (h *Hub) authenticateRequest(c *gin.Context, allowQueryToken bool) (claims *utils.Claims, token string, err error) {
+	if id := c.Query("ticket"); id != "" {
+		claims, ok := h.redeemTicket(c.Request.Context(), id)
+		if !ok {
+			return nil, "", errors.New("invalid ticket")
+		}
+		return claims, "", nil
+	}
+
+	if token = protocolToken(c.Request.Header); token == "" {
+		if t := c.Query("token"); t != "" {
+			if !allowQueryToken {
+				return nil, "", errors.New("query tokens are disabled")
+			}
+			token = t
+		}
+	}
+	if token == "" {
+		return nil, "", nil
+	}
+
+	claims, err = h.auth.ValidateToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+	return claims, token, nil
+}
+
+// protocolToken extracts the token offered as the subprotocol following
+// "bearer" in Sec-WebSocket-Protocol.
+func protocolToken(h map[string][]string) string {
+	var protocols []string
+	for _, v := range h["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+
+	for i, p := range protocols {
+		if p == bearerProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// awaitAuth waits for the first frame to be an AUTH event carrying a token.
+// It also returns the message id so the WELCOME can reply to it.
+func (h *Hub) awaitAuth(conn *websocket.Conn) (claims *utils.Claims, token, id string, err error) {
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var msg inbound
+	if err := conn.ReadJSON(&msg); err != nil {
+		return nil, "", "", err
+	}
+	if msg.Event != "AUTH" {
+		return nil, "", "", errors.New("expected AUTH event")
+	}
+
+	req := request{conn: conn, msg: msg}
+	var p AuthPayload
+	if err := req.decode(&p); err != nil {
+		return nil, "", "", err
+	}
+	claims, err = h.auth.ValidateToken(p.Token)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return claims, p.Token, msg.ID, nil
+}
+
+func closeWithReason(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// handleReauth accepts a fresh token for the same user and extends the
+// connection's expiry.
+func (h *Hub) handleReauth(ctx context.Context, req *request) error {
+	var p AuthPayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+
+	claims, err := h.auth.ValidateToken(p.Token)
+	if err != nil {
+		return protoErr(CodeUnauthorized, "invalid token")
+	}
+	if claims.UserID != req.client.UserID {
+		return protoErr(CodeUnauthorized, "token subject does not match connection")
+	}
+
+	conn := req.conn
+	h.clientsMu.Lock()
+	if info, ok := h.clients[conn]; ok {
+		info.ExpiresAt = claims.ExpiresAt
+		info.reauthSent = false
+		h.clients[conn] = info
+	}
+	h.clientsMu.Unlock()
+
+	req.reply(WSMessage{
+		Event: "AUTH_OK",
+		Data:  AuthOKData{ExpiresAt: expiryValue(claims.ExpiresAt)},
+	})
+	return nil
+}
+
+func expiryValue(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	v := t.UTC().Format(time.RFC3339)
+	return &v
+}
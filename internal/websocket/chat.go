@@ -0,0 +1,315 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ChatFilter screens a chat message before it is stored and sent. It returns
+// the text to send, which may be masked, or an error to reject the message;
+// a *Error reaches the sender as is.
+type ChatFilter func(userID, text string) (string, error)
+
+// blockedWordsFilter masks whole-word, case-insensitive matches of words.
+func blockedWordsFilter(words []string) ChatFilter {
+	var quoted []string
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	re := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	return func(_, text string) (string, error) {
+		return re.ReplaceAllStringFunc(text, func(w string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(w))
+		}), nil
+	}
+}
+
+// ChatPayload is the data of CHAT_MESSAGE. A private message from a student
+// goes to the teacher; a teacher's goes to ToUserID.
+type ChatPayload struct {
+	Text     string `json:"text"`
+	Private  bool   `json:"private"`
+	ToUserID string `json:"toUserId"`
+}
+
+func (p *ChatPayload) Validate() error {
+	p.Text = strings.TrimSpace(p.Text)
+	if p.Text == "" {
+		return protoErr(CodeInvalidPayload, "text is required")
+	}
+	if p.ToUserID != "" && !p.Private {
+		return protoErr(CodeInvalidPayload, "toUserId is only allowed on private messages")
+	}
+	return nil
+}
+
+// ChatDeletePayload is the data of CHAT_DELETE.
+type ChatDeletePayload struct {
+	MessageID string `json:"messageId"`
+}
+
+func (p *ChatDeletePayload) Validate() error {
+	if _, err := primitive.ObjectIDFromHex(p.MessageID); err != nil {
+		return protoErr(CodeInvalidPayload, "invalid messageId")
+	}
+	return nil
+}
+
+// maxMuteSeconds caps a timed mute; longer ones would overflow time.Duration
+// and no session lasts that long anyway.
+const maxMuteSeconds = 24 * 60 * 60
+
+// ChatMutePayload is the data of CHAT_MUTE and CHAT_UNMUTE. A mute without
+// a duration lasts until the session ends.
+type ChatMutePayload struct {
+	UserID          string `json:"userId"`
+	DurationSeconds int    `json:"durationSeconds"`
+}
+
+func (p *ChatMutePayload) Validate() error {
+	if p.UserID == "" {
+		return protoErr(CodeInvalidPayload, "userId is required")
+	}
+	if p.DurationSeconds < 0 || p.DurationSeconds > maxMuteSeconds {
+		return protoErr(CodeInvalidPayload, "durationSeconds must be between 0 and "+strconv.Itoa(maxMuteSeconds))
+	}
+	return nil
+}
+
+type ChatMessageData struct {
+	MessageID string    `json:"messageId"`
+	UserID    string    `json:"userId"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Text      string    `json:"text"`
+	Private   bool      `json:"private"`
+	ToUserID  string    `json:"toUserId,omitempty"`
+	SentAt    time.Time `json:"sentAt"`
+}
+
+type ChatDeletedData struct {
+	MessageID string `json:"messageId"`
+	DeletedBy string `json:"deletedBy"`
+}
+
+// ChatMuteData reports a mute. Until is omitted for mutes that last until
+// the session ends.
+type ChatMuteData struct {
+	UserID string     `json:"userId"`
+	Muted  bool       `json:"muted"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// handleChatMessage stores a message in the session's history and sends it
+// to the room, or for private messages to the sender and recipient only.
+func (h *Hub) handleChatMessage(ctx context.Context, req *request) error {
+	var p ChatPayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+	if max := req.client.opts.chatMaxLength; utf8.RuneCountInString(p.Text) > max {
+		return protoErr(CodeInvalidPayload, "text is longer than "+strconv.Itoa(max)+" characters")
+	}
+
+	var (
+		s     session.ActiveSession
+		muted bool
+	)
+	now := time.Now().UTC()
+	h.session.WithRead(func(as *session.ActiveSession) {
+		s = session.ActiveSession{SessionID: as.SessionID, ClassID: as.ClassID, TeacherID: as.TeacherID}
+		until, ok := as.Muted[req.client.UserID]
+		muted = ok && (until.IsZero() || now.Before(until))
+	})
+	if s.SessionID == "" {
+		return errNoSession
+	}
+	if muted {
+		return protoErr(CodeMuted, "You are muted in this session's chat")
+	}
+
+	recipient := ""
+	if p.Private {
+		switch {
+		case req.client.Role == "teacher" && p.ToUserID == "":
+			return protoErr(CodeInvalidPayload, "toUserId is required on a teacher's private message")
+		case req.client.Role == "teacher":
+			recipient = p.ToUserID
+		case p.ToUserID != "" && p.ToUserID != s.TeacherID:
+			return protoErr(CodeForbidden, "students may only message the teacher privately")
+		default:
+			recipient = s.TeacherID
+		}
+		if recipient == "" || recipient == req.client.UserID {
+			return protoErr(CodeInvalidPayload, "invalid private message recipient")
+		}
+	}
+
+	text := p.Text
+	if filter := req.client.opts.chatFilter; filter != nil {
+		var err error
+		if text, err = filter(req.client.UserID, text); err != nil {
+			var perr *Error
+			if errors.As(err, &perr) {
+				return perr
+			}
+			return protoErr(CodeInvalidPayload, "message rejected: "+err.Error())
+		}
+	}
+
+	sessionID, _ := primitive.ObjectIDFromHex(s.SessionID)
+	classID, _ := primitive.ObjectIDFromHex(s.ClassID)
+	record := models.ChatMessage{
+		SessionID:   sessionID,
+		ClassID:     classID,
+		SenderID:    req.client.UserID,
+		SenderName:  req.client.name,
+		SenderRole:  req.client.Role,
+		RecipientID: recipient,
+		Text:        text,
+		SentAt:      now,
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	err := h.store.Chat.Create(dbCtx, &record)
+	cancel()
+	if err != nil {
+		return errors.New("failed to save chat message")
+	}
+
+	msg := WSMessage{
+		Event: "CHAT_MESSAGE",
+		Data: ChatMessageData{
+			MessageID: record.ID.Hex(),
+			UserID:    record.SenderID,
+			Name:      record.SenderName,
+			Role:      record.SenderRole,
+			Text:      record.Text,
+			Private:   p.Private,
+			ToUserID:  recipient,
+			SentAt:    now,
+		},
+	}
+	if p.Private {
+		h.sendToUsers(ctx, msg, req.client.UserID, recipient)
+	} else {
+		h.broadcast(ctx, msg)
+	}
+	return nil
+}
+
+// handleChatDelete hides a message of the current session from the history
+// and tells the room to remove it.
+func (h *Hub) handleChatDelete(ctx context.Context, req *request) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
+	}
+	s := h.session.Get()
+	if s == nil {
+		return errNoSession
+	}
+	var p ChatDeletePayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+	id, _ := primitive.ObjectIDFromHex(p.MessageID)
+
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	m, err := h.store.Chat.FindByID(dbCtx, id)
+	if err == store.ErrNotFound || (err == nil && m.SessionID.Hex() != s.SessionID) {
+		return protoErr(CodeInvalidPayload, "no such message in this session")
+	}
+	if err != nil {
+		return errors.New("failed to fetch chat message")
+	}
+	if err := h.store.Chat.Delete(dbCtx, id, req.client.UserID, time.Now().UTC()); err != nil {
+		return errors.New("failed to delete chat message")
+	}
+
+	h.broadcast(ctx, WSMessage{
+		Event: "CHAT_DELETED",
+		Data:  ChatDeletedData{MessageID: p.MessageID, DeletedBy: req.client.UserID},
+	})
+	return nil
+}
+
+func (h *Hub) handleChatMute(ctx context.Context, req *request) error {
+	return h.setChatMute(ctx, req, true)
+}
+
+func (h *Hub) handleChatUnmute(ctx context.Context, req *request) error {
+	return h.setChatMute(ctx, req, false)
+}
+
+// setChatMute records a mute on the active session, so every instance
+// enforces it, and announces it to the room.
+func (h *Hub) setChatMute(ctx context.Context, req *request, mute bool) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
+	}
+	var p ChatMutePayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+	if p.UserID == req.client.UserID {
+		return protoErr(CodeInvalidPayload, "you cannot mute yourself")
+	}
+
+	data := ChatMuteData{UserID: p.UserID, Muted: mute}
+	var until time.Time
+	if mute && p.DurationSeconds > 0 {
+		until = time.Now().UTC().Add(time.Duration(p.DurationSeconds) * time.Second)
+		data.Until = &until
+	}
+	h.session.WithWrite(func(s *session.ActiveSession) {
+		if !mute {
+			delete(s.Muted, p.UserID)
+			return
+		}
+		if s.Muted == nil {
+			s.Muted = map[string]time.Time{}
+		}
+		s.Muted[p.UserID] = until
+	})
+
+	h.broadcast(ctx, WSMessage{Event: "CHAT_MUTED", Data: data})
+	return nil
+}
+
+// activeMutes lists the mutes of the active session that have not expired.
+func (h *Hub) activeMutes() []ChatMuteData {
+	mutes := []ChatMuteData{}
+	now := time.Now()
+	h.session.WithRead(func(s *session.ActiveSession) {
+		for userID, until := range s.Muted {
+			m := ChatMuteData{UserID: userID, Muted: true}
+			if !until.IsZero() {
+				if !now.Before(until) {
+					continue
+				}
+				until := until
+				m.Until = &until
+			}
+			mutes = append(mutes, m)
+		}
+	})
+	sort.Slice(mutes, func(i, j int) bool { return mutes[i].UserID < mutes[j].UserID })
+	return mutes
+}
@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+)
+
+// A student's private message reaches only the student and the session's
+// teacher.
+func TestChatPrivateMessageVisibility(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	s.startSession("t1")
+	teacher, _ := s.dial(t, "t1", "teacher", "")
+	sender, _ := s.dial(t, "st1", "student", "")
+	other, _ := s.dial(t, "st2", "student", "")
+
+	send(t, sender, "c1", "CHAT_MESSAGE", ChatPayload{Text: "a question", Private: true})
+	var got ChatMessageData
+	readEvent(t, sender, "CHAT_MESSAGE", &got)
+	if !got.Private || got.Text != "a question" {
+		t.Errorf("sender got %+v", got)
+	}
+	if f := readReply(t, sender, "c1"); f.Event != "ACK" {
+		t.Fatalf("private CHAT_MESSAGE reply = %+v", f)
+	}
+	got = ChatMessageData{}
+	readEvent(t, teacher, "CHAT_MESSAGE", &got)
+	if !got.Private || got.UserID != "st1" || got.ToUserID != "t1" || got.Text != "a question" {
+		t.Errorf("teacher got %+v", got)
+	}
+
+	// st2's first chat message is the public one sent afterwards.
+	send(t, sender, "c2", "CHAT_MESSAGE", ChatPayload{Text: "hello all"})
+	got = ChatMessageData{}
+	readEvent(t, other, "CHAT_MESSAGE", &got)
+	if got.Private || got.Text != "hello all" {
+		t.Errorf("st2 got %+v, want only the public message", got)
+	}
+}
+
+// Only the session's teacher may moderate its chat.
+func TestChatModerationSessionTeacherOnly(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	s.startSession("t1")
+	owner, _ := s.dial(t, "t1", "teacher", "")
+	other, _ := s.dial(t, "t2", "teacher", "")
+	student, _ := s.dial(t, "st1", "student", "")
+
+	send(t, other, "m1", "CHAT_MUTE", ChatMutePayload{UserID: "st1"})
+	if f := readReply(t, other, "m1"); f.Error == nil || f.Error.Code != CodeForbidden {
+		t.Errorf("CHAT_MUTE from another teacher = %+v, want %s", f, CodeForbidden)
+	}
+
+	send(t, owner, "m2", "CHAT_MUTE", ChatMutePayload{UserID: "st1", DurationSeconds: maxMuteSeconds + 1})
+	if f := readReply(t, owner, "m2"); f.Error == nil || f.Error.Code != CodeInvalidPayload {
+		t.Errorf("mute longer than the cap = %+v, want %s", f, CodeInvalidPayload)
+	}
+
+	send(t, owner, "m3", "CHAT_MUTE", ChatMutePayload{UserID: "st1", DurationSeconds: 60})
+	if f := readReply(t, owner, "m3"); f.Event != "ACK" {
+		t.Fatalf("CHAT_MUTE from the session's teacher = %+v", f)
+	}
+	send(t, student, "c1", "CHAT_MESSAGE", ChatPayload{Text: "hi"})
+	if f := readReply(t, student, "c1"); f.Error == nil || f.Error.Code != CodeMuted {
+		t.Errorf("muted student's message = %+v, want %s", f, CodeMuted)
+	}
+}
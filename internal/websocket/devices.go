@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	closeReplaced    = 4003
+	closeDeviceLimit = 4004
+)
+
+var (
+	errDeviceLimit = errors.New("already connected on the maximum number of devices")
+
+	deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+)
+
+// validDeviceID reports whether a client-supplied ?deviceId= is usable.
+func validDeviceID(id string) bool {
+	return deviceIDPattern.MatchString(id)
+}
+
+// admitDevice decides whether userID may open another connection from
+// deviceID. It returns the connections to close to make room: an older
+// connection from the same device is always replaced, and under the replace
+// policy the oldest connections beyond MaxDevices are too. Only connections
+// on this instance are counted. The caller must hold clientsMu.
+func (h *Hub) admitDevice(o *connOptions, userID, deviceID string) (evict []*websocket.Conn, err error) {
+	type entry struct {
+		conn *websocket.Conn
+		at   time.Time
+	}
+	var others []entry
+	for conn, info := range h.clients {
+		if info.UserID != userID {
+			continue
+		}
+		if info.deviceID == deviceID {
+			evict = append(evict, conn)
+			continue
+		}
+		others = append(others, entry{conn, info.connectedAt})
+	}
+
+	max := o.cfg.MaxDevices
+	if max < 1 {
+		max = 1
+	}
+
+	switch o.cfg.DevicePolicy {
+	case "reject":
+		if len(others) >= max {
+			return nil, errDeviceLimit
+		}
+	case "replace":
+		sort.Slice(others, func(i, j int) bool { return others[i].at.Before(others[j].at) })
+		for len(others) >= max {
+			evict = append(evict, others[0].conn)
+			others = others[1:]
+		}
+	}
+	return evict, nil
+}
+
+// wouldRejectDevice lets HandleWebSocket refuse a connection with 409 before
+// upgrading when the reject policy will turn it away anyway.
+func (h *Hub) wouldRejectDevice(o *connOptions, userID, deviceID string) bool {
+	if o.cfg.DevicePolicy != "reject" {
+		return false
+	}
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	_, err := h.admitDevice(o, userID, deviceID)
+	return err != nil
+}
+
+// userConnections returns the user's connections, newest first.
+func (h *Hub) userConnections(userID string) []*websocket.Conn {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	var conns []*websocket.Conn
+	for conn, info := range h.clients {
+		if info.UserID == userID {
+			conns = append(conns, conn)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return h.clients[conns[i]].connectedAt.After(h.clients[conns[j]].connectedAt)
+	})
+	return conns
+}
+
+// sendToUsers sends msg to every connection of the given users, on any
+// instance. It is not logged to the room, so it is not replayed on resume.
+func (h *Hub) sendToUsers(ctx context.Context, msg WSMessage, userIDs ...string) {
+	for _, userID := range userIDs {
+		for _, conn := range h.userConnections(userID) {
+			h.sendToClient(conn, msg)
+		}
+	}
+	for _, e := range h.remoteEntries() {
+		for _, userID := range userIDs {
+			if e.UserID != userID {
+				continue
+			}
+			if err := h.publishSignal(ctx, e.ConnectionID, msg); err != nil {
+				slog.Warn("failed to relay message", "event", msg.Event, "connection_id", e.ConnectionID, "error", err)
+			}
+		}
+	}
+}
+
+// connectionByID finds a connection by the id sent in WELCOME.
+func (h *Hub) connectionByID(connID string) *websocket.Conn {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+
+	for conn, info := range h.clients {
+		if info.connID == connID {
+			return conn
+		}
+	}
+	return nil
+}
+
+type PresenceData struct {
+	UserID  string `json:"userId"`
+	Online  bool   `json:"online"`
+	Devices int    `json:"devices"`
+}
+
+// broadcastPresence tells the room how many devices userID now has
+// connected, across all instances. Presence is per user, so peers can tell a
+// user who went offline from one who closed a second tab.
+func (h *Hub) broadcastPresence(ctx context.Context, userID string) {
+	if h.shuttingDown.Load() {
+		return
+	}
+	n := len(h.userConnections(userID))
+	for _, e := range h.remoteEntries() {
+		if e.UserID == userID {
+			n++
+		}
+	}
+	h.broadcast(ctx, WSMessage{
+		Event: "PRESENCE",
+		Data:  PresenceData{UserID: userID, Online: n > 0, Devices: n},
+	})
+}
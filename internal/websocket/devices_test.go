@@ -0,0 +1,42 @@
+package websocket
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+)
+
+// Under the replace policy a new device closes the oldest connection with
+// 4003.
+func TestDevicePolicyReplace(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{DevicePolicy: "replace", MaxDevices: 1})
+	first, _ := s.dial(t, "st1", "student", "deviceId=laptop")
+	s.dial(t, "st1", "student", "deviceId=phone")
+
+	expectClose(t, first, closeReplaced)
+	waitFor(t, "one connection left", func() bool { return s.connections("st1") == 1 })
+}
+
+// Under the reject policy a new device is refused with 409 when the user is
+// known at the upgrade, and closed with 4004 when it authenticates with an
+// AUTH message.
+func TestDevicePolicyReject(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{DevicePolicy: "reject", MaxDevices: 1})
+	s.dial(t, "st1", "student", "deviceId=laptop")
+
+	_, resp, err := s.dialResponse(t, "st1", "student", "deviceId=phone")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("second device: err = %v, resp = %+v, want 409", err, resp)
+	}
+
+	// The same device reconnecting replaces its stale socket instead.
+	s.dial(t, "st1", "student", "deviceId=laptop")
+
+	conn := s.dialAuthMessage(t, "st1", "student", "deviceId=tablet")
+	expectClose(t, conn, closeDeviceLimit)
+
+	if n := s.connections("st1"); n != 1 {
+		t.Errorf("st1 has %d connections, want 1", n)
+	}
+}
@@ -2,274 +2,533 @@ package websocket
 
 import (
 	"context"
-	"log"
-	"net/http"
-	"sync"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/waliamehak/WebSocket-live-attendance-system/internal/database"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/broker"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/metrics"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/middleware"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/tracing"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
 	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// Options are the settings and services a Hub is created with.
+type Options struct {
+	Config config.WebSocket
+	// Broker shares rooms between instances; nil keeps them in memory.
+	Broker broker.Broker
+	Store  *store.Store
+	// Session is the active class session, shared with the REST handlers;
+	// nil gives the hub one of its own.
+	Session *session.State
+	// Auth validates the tokens presented on connect and in AUTH events.
+	Auth utils.Authenticator
+	// Origins restricts which origins may connect; nil accepts every
+	// origin.
+	Origins *middleware.OriginPolicy
+	// Users provisions the subject of tokens presented on connect.
+	Users *users.Provisioner
+	// ChatFilter replaces the CHAT_BLOCKED_WORDS filter, e.g. with a call
+	// to a moderation service.
+	ChatFilter ChatFilter
 }
 
-type ClientInfo struct {
-	UserID string
-	Role   string
+// connOptions are Options prepared for use by each connection.
+type connOptions struct {
+	cfg           config.WebSocket
+	users         *users.Provisioner
+	connLimits    map[string]config.EventLimit
+	userLimits    map[string]config.EventLimit
+	chatMaxLength int
+	chatFilter    ChatFilter
 }
 
-var (
-	clients   = make(map[*websocket.Conn]ClientInfo)
-	clientsMu sync.RWMutex
-)
+// newConnOptions parses the rate limits, which Validate has already
+// checked.
+func newConnOptions(opts Options) *connOptions {
+	o := &connOptions{
+		cfg:           opts.Config,
+		users:         opts.Users,
+		chatMaxLength: opts.Config.Chat.MaxLength,
+		chatFilter:    opts.ChatFilter,
+	}
+	o.connLimits, _ = config.ParseEventLimits(opts.Config.RateLimit.PerConnection)
+	o.userLimits, _ = config.ParseEventLimits(opts.Config.RateLimit.PerUser)
+	if o.chatMaxLength <= 0 {
+		o.chatMaxLength = 1000
+	}
+	if o.chatFilter == nil {
+		o.chatFilter = blockedWordsFilter(opts.Config.Chat.BlockedWords)
+	}
+	return o
+}
 
-type WSMessage struct {
-	Event string                 `json:"event"`
-	Data  map[string]interface{} `json:"data,omitempty"`
+type ClientInfo struct {
+	UserID    string
+	Role      string
+	ExpiresAt time.Time
+
+	reauthSent  bool
+	connID      string
+	deviceID    string
+	connectedAt time.Time
+	resumeToken string
+	name        string
+	media       MediaState
+	ip          string
+	limiter     *bucketSet        // per-connection rate limits
+	out         *outbox           // messages waiting to be written
+	opts        *connOptions      // settings the connection was accepted with
+	protocol    int               // negotiated protocol version
+	room        string            // metrics label, fixed at connect time
+	log         *slog.Logger      // carries conn_id, user_id and role
+	trace       trace.SpanContext // span of the upgrade request
 }
 
-func HandleWebSocket(c *gin.Context) {
-	token := c.Query("token")
-	if token == "" {
-		c.JSON(401, gin.H{"error": "token missing"})
+// HandleWebSocket is the upgrade handler. It accepts credentials as a
+// "bearer" subprotocol, a ticket from /ws/ticket, or an AUTH event sent as
+// the first message.
+func (h *Hub) HandleWebSocket(c *gin.Context) {
+	o := h.opts
+
+	if h.shuttingDown.Load() {
+		c.JSON(503, gin.H{"error": "server is shutting down"})
+		return
+	}
+
+	connID := logging.NewID()
+	l := logging.FromContext(c.Request.Context()).With("conn_id", connID)
+
+	// Clients pass a stable ?deviceId= so a reconnect from the same device
+	// replaces its stale socket. Without one each connection is its own
+	// device.
+	deviceID := c.Query("deviceId")
+	if deviceID == "" {
+		deviceID = connID
+	} else if !validDeviceID(deviceID) {
+		c.JSON(400, gin.H{"error": "invalid deviceId"})
 		return
 	}
 
-	claims, err := utils.ValidateToken(token)
+	claims, token, err := h.authenticateRequest(c, o.cfg.AllowQueryToken)
 	if err != nil {
+		l.Warn("ws authentication rejected", "error", err)
 		c.JSON(401, gin.H{"error": "invalid token"})
 		return
 	}
+	if claims != nil && h.wouldRejectDevice(o, claims.UserID, deviceID) {
+		c.JSON(409, gin.H{"error": errDeviceLimit.Error()})
+		return
+	}
+
+	ip := c.ClientIP()
+	userID := ""
+	if claims != nil {
+		userID = claims.UserID
+	}
+	if err := h.wouldExceedConnLimits(o, userID, ip); err != nil {
+		metrics.WSRejected.WithLabelValues("connection_limit").Inc()
+		c.JSON(429, gin.H{"error": err.Error()})
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		l.Warn("ws upgrade failed", "error", err)
+		return
+	}
+	if o.cfg.MaxMessageBytes > 0 {
+		conn.SetReadLimit(o.cfg.MaxMessageBytes)
+	}
+
+	version := negotiatedVersion(conn)
+
+	var authID string
+	if claims == nil {
+		claims, token, authID, err = h.awaitAuth(conn)
+		if err != nil {
+			l.Warn("ws auth failed", "error", err)
+			closeWithReason(conn, closeAuthFailed, "authentication required")
+			return
+		}
+	}
+	l = l.With("user_id", claims.UserID, "role", claims.Role, "device_id", deviceID, "protocol", version)
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if token != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := o.users.Provision(ctx, token, claims); err != nil {
+			l.Error("user provisioning failed", "error", err)
+		}
+		cancel()
+	}
+
+	name := h.displayName(c.Request.Context(), claims)
+	room := h.currentRoom()
+
+	var resumeToken string
+	if version >= ProtocolV2 {
+		resumeToken = newResumeToken()
+	}
+
+	// Shutdown snapshots clients under clientsMu after setting shuttingDown,
+	// so checking it here guarantees every registered connection is closed
+	// and waited for.
+	h.clientsMu.Lock()
+	if h.shuttingDown.Load() {
+		h.clientsMu.Unlock()
+		closeWithReason(conn, websocket.CloseServiceRestart, "server shutting down")
+		return
+	}
+	evict, err := h.admitDevice(o, claims.UserID, deviceID)
 	if err != nil {
-		log.Println("ws upgrade failed:", err)
+		h.clientsMu.Unlock()
+		l.Info("connection refused by device policy", "error", err)
+		closeWithReason(conn, closeDeviceLimit, err.Error())
+		return
+	}
+	if err := h.checkConnLimits(o, claims.UserID, ip, evict); err != nil {
+		h.clientsMu.Unlock()
+		l.Info("connection refused by connection limits", "error", err)
+		metrics.WSRejected.WithLabelValues("connection_limit").Inc()
+		closeWithReason(conn, closeConnectionLimit, err.Error())
 		return
 	}
+	info := ClientInfo{
+		UserID:      claims.UserID,
+		Role:        claims.Role,
+		ExpiresAt:   claims.ExpiresAt,
+		name:        name,
+		connID:      connID,
+		deviceID:    deviceID,
+		connectedAt: time.Now(),
+		resumeToken: resumeToken,
+		ip:          ip,
+		limiter:     newBucketSet(o.connLimits),
+		out:         newOutbox(),
+		opts:        o,
+		protocol:    version,
+		room:        room,
+		log:         l,
+		trace:       trace.SpanContextFromContext(c.Request.Context()),
+	}
+	h.clients[conn] = info
+	h.connWG.Add(1)
+	h.clientsMu.Unlock()
+	metrics.WSConnections.WithLabelValues(claims.Role).Inc()
+	go runWriter(conn, info.out, version, l)
+
+	for _, old := range evict {
+		h.connLogger(old).Info("connection replaced by a newer device", "new_conn_id", connID)
+		h.closeConn(old, closeReplaced, "replaced by a newer connection")
+	}
 
-	clientsMu.Lock()
-	clients[conn] = ClientInfo{UserID: claims.UserID, Role: claims.Role}
-	clientsMu.Unlock()
+	// A client that reconnects with a valid resume token is the same
+	// participant, so it is not announced again and gets what it missed.
+	// The token is single use, so it is only redeemed once the connection
+	// has been admitted.
+	var resumed *resumeState
+	if t := c.Query("resume"); t != "" {
+		if r, ok := h.redeemResumeToken(t, claims.UserID); ok {
+			resumed = &r
+		} else {
+			l.Info("resume token rejected")
+		}
+	}
 
-	log.Printf("client connected: %s (%s)", claims.UserID, claims.Role)
+	h.publishRoster()
 
-	broadcastPeerJoined(claims.UserID, claims.Role)
+	l.Info("client connected", "room", room, "resumed", resumed != nil)
 
-	go handleMessages(conn)
+	// Announce first so the seq in WELCOME already covers the join.
+	ctx := context.WithoutCancel(c.Request.Context())
+	if resumed == nil {
+		h.broadcastPeerJoined(ctx, info)
+	}
+
+	if version >= ProtocolV2 {
+		h.sendToClient(conn, WSMessage{
+			Event:   "WELCOME",
+			ReplyTo: authID,
+			Data: WelcomeData{
+				Protocol:     version,
+				Supported:    supportedProtocols,
+				ConnectionID: connID,
+				DeviceID:     deviceID,
+				ExpiresAt:    expiryValue(claims.ExpiresAt),
+				ResumeToken:  resumeToken,
+				Room:         room,
+				Seq:          h.roomLog(room).lastSeq(),
+				Resumed:      resumed != nil,
+			},
+		})
+	}
+
+	if resumed != nil {
+		h.replayMissed(conn, resumed.Room, parseLastSeq(c.Query("lastSeq")))
+	} else {
+		h.sendRoomState(conn)
+	}
+	h.broadcastPresence(ctx, claims.UserID)
+
+	go h.handleMessages(conn)
 }
 
-func handleMessages(conn *websocket.Conn) {
+func (h *Hub) handleMessages(conn *websocket.Conn) {
+	client := h.getClient(conn)
+	l := h.connLogger(conn)
+
+	defer h.connWG.Done()
 	defer func() {
-		clientsMu.Lock()
-		delete(clients, conn)
-		clientsMu.Unlock()
-		conn.Close()
-		log.Println("client disconnected")
+		h.releaseResumeToken(client.resumeToken, client.UserID, client.room)
+		h.removeClient(conn)
+		client.out.close(websocket.CloseNormalClosure, "")
+		<-client.out.done
+		l.Info("client disconnected")
+		h.publishRoster()
+		h.broadcastPresence(context.Background(), client.UserID)
 	}()
 
 	for {
-		var msg WSMessage
-		if err := conn.ReadJSON(&msg); err != nil {
-			log.Println("read error:", err)
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				l.Info("message too large, connection closed", "limit", client.opts.cfg.MaxMessageBytes)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseServiceRestart) {
+				l.Warn("read error", "error", err)
+			}
 			break
 		}
 
-		switch msg.Event {
-		case "ATTENDANCE_MARKED":
-			handleAttendanceMarked(conn, msg)
-		case "TODAY_SUMMARY":
-			handleTodaySummary(conn, msg)
-		case "MY_ATTENDANCE":
-			handleMyAttendance(conn, msg)
-		case "DONE":
-			handleDone(conn, msg)
-		case "WEBRTC_OFFER":
-			handleWebRTCSignal(conn, msg)
-		case "WEBRTC_ANSWER":
-			handleWebRTCSignal(conn, msg)
-		case "WEBRTC_ICE_CANDIDATE":
-			handleWebRTCSignal(conn, msg)
-		default:
-			sendError(conn, "unknown event type")
+		// Malformed frames count as unknown events so they are throttled too.
+		var msg inbound
+		valid := json.Unmarshal(raw, &msg) == nil && msg.Event != ""
+		event := "unknown"
+		if valid {
+			event = inboundLabel(msg.Event)
 		}
+		metrics.WSMessagesIn.WithLabelValues(event).Inc()
+
+		if perr, closed := h.throttle(conn, client, event); closed {
+			break
+		} else if perr != nil {
+			h.sendError(conn, msg.ID, perr)
+			continue
+		}
+
+		if !valid {
+			h.sendError(conn, "", protoErr(CodeBadRequest, "message must be a JSON object with an event"))
+			continue
+		}
+		h.dispatch(conn, msg)
 	}
 }
 
-func handleAttendanceMarked(conn *websocket.Conn, msg WSMessage) {
-	client := getClient(conn)
+// dispatch runs the handler for one inbound event inside its own span and
+// answers it with an ACK or ERROR. The span is linked to the connection's
+// upgrade request rather than parented to it, so long-lived connections do
+// not produce unbounded traces.
+func (h *Hub) dispatch(conn *websocket.Conn, msg inbound) {
+	client := h.getClient(conn)
+	event := inboundLabel(msg.Event)
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "ws.event "+event,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithLinks(trace.Link{SpanContext: client.trace}),
+		trace.WithAttributes(
+			attribute.String("ws.event", event),
+			attribute.String("enduser.id", client.UserID),
+			attribute.String("enduser.role", client.Role),
+		),
+	)
+	defer span.End()
+
+	l := tracing.Logger(ctx, h.connLogger(conn))
+	ctx = logging.WithLogger(ctx, l)
+	l.Debug("event received", "event", msg.Event, "msg_id", msg.ID)
+
+	req := &request{hub: h, conn: conn, client: client, msg: msg}
+
+	var err error
+	handler, ok := eventHandlers[msg.Event]
+	switch {
+	case msg.V != 0 && msg.V != client.protocol:
+		err = protoErr(CodeUnsupportedVersion, "message version does not match the negotiated protocol")
+	case !ok:
+		err = protoErr(CodeUnknownEvent, "unknown event type")
+	default:
+		err = handler(h, ctx, req)
+	}
 
-	if client.Role != "teacher" {
-		sendError(conn, "Forbidden, teacher event only")
+	if err != nil {
+		var perr *Error
+		if !errors.As(err, &perr) {
+			l.Error("event failed", "event", msg.Event, "error", err)
+			perr = protoErr(CodeInternal, err.Error())
+		}
+		span.SetAttributes(attribute.String("ws.error_code", perr.Code))
+		h.sendError(conn, msg.ID, perr)
 		return
 	}
 
-	s := session.Get()
-	if s == nil {
-		sendError(conn, "No active attendance session")
-		return
+	if !req.replied && msg.ID != "" && client.protocol >= ProtocolV2 {
+		h.sendToClient(conn, WSMessage{Event: "ACK", ReplyTo: msg.ID})
 	}
+}
 
-	studentID, ok := msg.Data["studentId"].(string)
-	if !ok || studentID == "" {
-		sendError(conn, "invalid studentId")
-		return
+func (h *Hub) handleAttendanceMarked(ctx context.Context, req *request) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
 	}
 
-	status, ok := msg.Data["status"].(string)
-	if !ok || (status != "present" && status != "absent") {
-		sendError(conn, "invalid status")
-		return
+	var p MarkAttendancePayload
+	if err := req.decode(&p); err != nil {
+		return err
 	}
 
-	session.WithWrite(func(s *session.ActiveSession) {
-		s.Attendance[studentID] = status
+	h.session.WithWrite(func(s *session.ActiveSession) {
+		s.Attendance[p.StudentID] = p.Status
 	})
 
-	broadcast(WSMessage{
+	h.broadcast(ctx, WSMessage{
 		Event: "ATTENDANCE_MARKED",
-		Data: map[string]interface{}{
-			"studentId": studentID,
-			"status":    status,
-		},
+		Data:  AttendanceMarkedData{StudentID: p.StudentID, Status: p.Status},
 	})
+	return nil
 }
 
-func handleTodaySummary(conn *websocket.Conn, msg WSMessage) {
-	client := getClient(conn)
-
-	if client.Role != "teacher" {
-		sendError(conn, "Forbidden, teacher event only")
-		return
-	}
-
-	s := session.Get()
-	if s == nil {
-		sendError(conn, "No active attendance session")
-		return
+func (h *Hub) handleTodaySummary(ctx context.Context, req *request) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
 	}
 
 	present, absent := 0, 0
-	for _, st := range s.Attendance {
-		if st == "present" {
-			present++
-		} else if st == "absent" {
-			absent++
+	h.session.WithRead(func(s *session.ActiveSession) {
+		for _, st := range s.Attendance {
+			if st == "present" {
+				present++
+			} else if st == "absent" {
+				absent++
+			}
 		}
-	}
+	})
 
-	broadcast(WSMessage{
+	h.broadcast(ctx, WSMessage{
 		Event: "TODAY_SUMMARY",
-		Data: map[string]interface{}{
-			"present": present,
-			"absent":  absent,
-			"total":   present + absent,
-		},
+		Data:  summarize(present, absent),
 	})
+	return nil
 }
 
-func handleMyAttendance(conn *websocket.Conn, msg WSMessage) {
-	client := getClient(conn)
-
-	if client.Role != "student" {
-		sendError(conn, "Forbidden, student event only")
-		return
+func (h *Hub) handleMyAttendance(ctx context.Context, req *request) error {
+	if err := requireRole(req.client, "student"); err != nil {
+		return err
 	}
-
-	s := session.Get()
-	if s == nil {
-		sendError(conn, "No active attendance session")
-		return
+	if h.session.Get() == nil {
+		return errNoSession
 	}
 
-	status, found := s.Attendance[client.UserID]
-	if !found {
-		status = "not yet updated"
-	}
+	status := "not yet updated"
+	h.session.WithRead(func(s *session.ActiveSession) {
+		if st, found := s.Attendance[req.client.UserID]; found {
+			status = st
+		}
+	})
 
-	sendToClient(conn, WSMessage{
+	req.reply(WSMessage{
 		Event: "MY_ATTENDANCE",
-		Data: map[string]interface{}{
-			"status": status,
-		},
+		Data:  MyAttendanceData{Status: status},
 	})
+	return nil
 }
 
-func handleDone(conn *websocket.Conn, msg WSMessage) {
-	client := getClient(conn)
+func (h *Hub) handleDone(ctx context.Context, req *request) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
+	}
 
-	if client.Role != "teacher" {
-		sendError(conn, "Forbidden, teacher event only")
-		return
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	room := h.currentRoom()
+	start := time.Now()
+	present, absent, err := h.finalizeSession(ctx)
+	metrics.Since(metrics.DonePersistDuration, start)
+	if err != nil {
+		return err
 	}
 
-	s := session.Get()
+	h.broadcast(ctx, WSMessage{
+		Event: "DONE",
+		room:  room,
+		Data: DoneData{
+			Message:     "Attendance persisted",
+			SummaryData: summarize(present, absent),
+		},
+	})
+	return nil
+}
+
+// finalizeSession marks unmarked students absent, persists attendance, ends
+// the session record and clears the active session. If attendance or hand
+// raises cannot be saved the session stays active, so no marks are lost and
+// DONE can be retried.
+func (h *Hub) finalizeSession(ctx context.Context) (present, absent int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "session.finalize")
+	defer func() { tracing.End(span, err) }()
+
+	s := h.session.Get()
 	if s == nil {
-		sendError(conn, "No active attendance session")
-		return
+		return 0, 0, errNoSession
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	classID, err := primitive.ObjectIDFromHex(s.ClassID)
 	if err != nil {
-		sendError(conn, "invalid class id in session")
-		return
+		return 0, 0, errors.New("invalid class id in session")
 	}
 
-	classCollection := database.DB.Collection("classes")
-	var class models.Class
-	err = classCollection.FindOne(ctx, bson.M{"_id": classID}).Decode(&class)
+	class, err := h.store.Classes.FindByID(ctx, classID)
 	if err != nil {
-		sendError(conn, "failed to fetch class")
-		return
+		return 0, 0, errors.New("failed to fetch class")
 	}
 
-	classCollection.UpdateOne(ctx, bson.M{"_id": classID}, bson.M{
-		"$unset": bson.M{"activeRoomId": ""},
-	})
+	sessionID, _ := primitive.ObjectIDFromHex(s.SessionID)
 
-	session.WithWrite(func(s *session.ActiveSession) {
-		for _, studentID := range class.StudentIDs {
-			if _, exists := s.Attendance[studentID]; !exists {
-				s.Attendance[studentID] = "absent"
-			}
+	att := map[string]string{}
+	var hands []session.HandRaise
+	h.session.WithRead(func(s *session.ActiveSession) {
+		for k, v := range s.Attendance {
+			att[k] = v
 		}
+		hands = append([]session.HandRaise(nil), s.Hands...)
 	})
-
-	attendanceCollection := database.DB.Collection("attendance")
-
-	present, absent := 0, 0
-
-	att := map[string]string{}
-	for k, v := range s.Attendance {
-		att[k] = v
+	for _, studentID := range class.StudentIDs {
+		if _, exists := att[studentID]; !exists {
+			att[studentID] = "absent"
+		}
 	}
 
+	records := make([]models.Attendance, 0, len(att))
 	for studentIDStr, status := range att {
-		attendanceCollection.DeleteOne(ctx, bson.M{
-			"classId":   classID,
-			"studentId": studentIDStr,
-		})
-
-		rec := models.Attendance{
+		records = append(records, models.Attendance{
 			ID:        primitive.NewObjectID(),
 			ClassID:   classID,
+			SessionID: sessionID,
 			StudentID: studentIDStr,
 			Status:    status,
-		}
-
-		if _, err := attendanceCollection.InsertOne(ctx, rec); err != nil {
-			log.Println("failed to save attendance:", err)
-		}
+		})
 
 		if status == "present" {
 			present++
@@ -278,114 +537,218 @@ func handleDone(conn *websocket.Conn, msg WSMessage) {
 		}
 	}
 
-	broadcast(WSMessage{
-		Event: "DONE",
-		Data: map[string]interface{}{
-			"message": "Attendance persisted",
-			"present": present,
-			"absent":  absent,
-			"total":   present + absent,
-		},
-	})
+	if err := h.store.Attendance.Replace(ctx, records); err != nil {
+		logging.FromContext(ctx).Error("failed to save attendance", "session_id", s.SessionID, "error", err)
+		return 0, 0, errors.New("failed to save attendance")
+	}
+
+	if !sessionID.IsZero() {
+		endedAt := time.Now().UTC()
+		if err := h.store.Sessions.SaveHandRaises(ctx, sessionID, handRaiseRecords(hands, endedAt)); err != nil {
+			logging.FromContext(ctx).Error("failed to save hand raises", "session_id", s.SessionID, "error", err)
+			return 0, 0, errors.New("failed to save hand raises")
+		}
+		if err := h.store.Sessions.End(ctx, sessionID, endedAt); err != nil {
+			logging.FromContext(ctx).Error("failed to close session record", "session_id", s.SessionID, "error", err)
+		}
+	}
 
-	session.Clear()
+	h.store.Classes.ClearActiveRoom(ctx, classID)
+	h.session.Clear()
+	return present, absent, nil
 }
 
-func getClient(conn *websocket.Conn) ClientInfo {
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
-	return clients[conn]
+// removeClient unregisters conn; it is a no-op if already removed.
+func (h *Hub) removeClient(conn *websocket.Conn) {
+	h.clientsMu.Lock()
+	info, ok := h.clients[conn]
+	delete(h.clients, conn)
+	h.clientsMu.Unlock()
+
+	if ok {
+		metrics.WSConnections.WithLabelValues(info.Role).Dec()
+	}
 }
 
-func sendToClient(conn *websocket.Conn, msg WSMessage) {
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Println("write error:", err)
+// inboundLabel bounds the label values of WSMessagesIn to known events.
+func inboundLabel(event string) string {
+	if _, ok := eventHandlers[event]; ok {
+		return event
 	}
+	return "unknown"
 }
 
-func broadcast(msg WSMessage) {
-	clientsMu.RLock()
-	conns := make([]*websocket.Conn, 0, len(clients))
-	for c := range clients {
-		conns = append(conns, c)
+// connLogger returns the connection's logger, or the default logger if the
+// connection is no longer registered.
+func (h *Hub) connLogger(conn *websocket.Conn) *slog.Logger {
+	if l := h.getClient(conn).log; l != nil {
+		return l
 	}
-	clientsMu.RUnlock()
+	return slog.Default()
+}
 
-	for _, conn := range conns {
-		if err := conn.WriteJSON(msg); err != nil {
-			log.Println("broadcast error:", err)
-			conn.Close()
-			clientsMu.Lock()
-			delete(clients, conn)
-			clientsMu.Unlock()
+func (h *Hub) getClient(conn *websocket.Conn) ClientInfo {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	return h.clients[conn]
+}
+
+// writeJSON queues msg for the connection's writer, which encodes it in
+// the connection's protocol version. A client whose queue is full is
+// disconnected rather than letting it hold up everyone else's messages.
+func (h *Hub) writeJSON(conn *websocket.Conn, msg WSMessage) error {
+	if msg.ID == "" {
+		msg.ID = h.nextMessageID()
+	}
+	out := h.getClient(conn).out
+	if out == nil {
+		metrics.WSDropped.WithLabelValues(msg.Event).Inc()
+		return errConnClosed
+	}
+
+	err := out.send(msg)
+	if err != nil {
+		metrics.WSDropped.WithLabelValues(msg.Event).Inc()
+		if errors.Is(err, errSendQueueFull) {
+			h.connLogger(conn).Warn("closing connection that is too slow to keep up", "event", msg.Event)
+			metrics.WSRejected.WithLabelValues("too_slow").Inc()
+			out.close(closeTooSlow, "too slow to keep up")
 		}
 	}
+	return err
 }
 
-func sendError(conn *websocket.Conn, message string) {
-	sendToClient(conn, WSMessage{
-		Event: "ERROR",
-		Data: map[string]interface{}{
-			"message": message,
-		},
-	})
+// closeConn closes a registered connection once the messages already queued
+// for it are written, and any other connection straight away.
+func (h *Hub) closeConn(conn *websocket.Conn, code int, reason string) {
+	if out := h.getClient(conn).out; out != nil {
+		out.close(code, reason)
+		return
+	}
+	closeWithReason(conn, code, reason)
+}
+
+func (h *Hub) sendToClient(conn *websocket.Conn, msg WSMessage) {
+	if err := h.writeJSON(conn, msg); err != nil {
+		h.connLogger(conn).Warn("write error", "event", msg.Event, "error", err)
+	}
 }
 
-func handleWebRTCSignal(conn *websocket.Conn, msg WSMessage) {
-	client := getClient(conn)
+func (h *Hub) broadcast(ctx context.Context, msg WSMessage) {
+	h.broadcastExcept(ctx, msg, "")
+}
 
-	targetID, ok := msg.Data["targetId"].(string)
-	if !ok || targetID == "" {
-		sendError(conn, "missing targetId in WebRTC message")
-		return
+// broadcastExcept sends msg to every client in the room, on every instance,
+// except the connections of excludeUser.
+func (h *Hub) broadcastExcept(ctx context.Context, msg WSMessage, excludeUser string) {
+	defer metrics.Since(metrics.BroadcastDuration.WithLabelValues(msg.Event), time.Now())
+
+	ctx, span := tracing.Tracer().Start(ctx, "ws.broadcast",
+		trace.WithAttributes(attribute.String("ws.event", msg.Event)))
+	defer span.End()
+
+	// Every recipient sees the same message id and sequence number.
+	if msg.ID == "" {
+		msg.ID = h.nextMessageID()
+	}
+	room := msg.room
+	if room == "" {
+		room = h.currentRoom()
 	}
+	msg.Seq = h.nextSeq(ctx, room)
+	msg.excludeUser = excludeUser
+	msg = h.roomLog(room).append(msg)
+
+	recipients, failed := h.deliver(msg, excludeUser)
+	h.notifyStreams(room, msg)
+	h.publishBroadcast(ctx, room, excludeUser, msg)
+
+	span.SetAttributes(
+		attribute.Int("ws.recipients", recipients),
+		attribute.Int("ws.failed", failed),
+	)
+}
 
-	clientsMu.RLock()
-	for c, info := range clients {
-		if info.UserID == targetID {
-			msg.Data["fromId"] = client.UserID
-			msg.Data["fromRole"] = client.Role
-			c.WriteJSON(msg)
-			clientsMu.RUnlock()
-			return
+// deliver writes msg to this instance's clients, dropping connections that
+// fail.
+func (h *Hub) deliver(msg WSMessage, excludeUser string) (recipients, failed int) {
+	h.clientsMu.RLock()
+	conns := make([]*websocket.Conn, 0, len(h.clients))
+	for c, info := range h.clients {
+		if excludeUser == "" || info.UserID != excludeUser {
+			conns = append(conns, c)
 		}
 	}
-	clientsMu.RUnlock()
+	h.clientsMu.RUnlock()
 
-	sendError(conn, "target peer not connected")
+	// writeJSON looks the client up again, so queue outside the lock.
+	for _, conn := range conns {
+		if err := h.writeJSON(conn, msg); err != nil {
+			failed++
+		}
+	}
+	return len(conns), failed
 }
 
-func broadcastPeerJoined(newUserID, newUserRole string) {
-	userName := getUserName(newUserID)
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
-
-	for conn, info := range clients {
-		if info.UserID != newUserID {
-			conn.WriteJSON(WSMessage{
-				Event: "PEER_JOINED",
-				Data: map[string]interface{}{
-					"userId": newUserID,
-					"role":   newUserRole,
-					"name":   userName,
-				},
-			})
+func (h *Hub) handleWebRTCSignal(ctx context.Context, req *request) error {
+	var p SignalPayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+
+	relay := WSMessage{
+		Event: req.msg.Event,
+		Data: SignalRelayData{
+			SignalPayload:    p,
+			FromID:           req.client.UserID,
+			FromRole:         req.client.Role,
+			FromConnectionID: req.client.connID,
+		},
+	}
+
+	// Address a specific device when the sender knows it; otherwise the
+	// user's most recent connection. Peers on another instance are reached
+	// through the broker.
+	target, remote := h.signalTarget(p)
+	if remote != "" {
+		if err := h.publishSignal(ctx, remote, relay); err != nil {
+			return protoErr(CodePeerNotConnected, "target peer not reachable")
 		}
+		return nil
+	}
+	if target == nil {
+		return protoErr(CodePeerNotConnected, "target peer not connected")
 	}
+	if err := h.writeJSON(target, relay); err != nil {
+		return protoErr(CodePeerNotConnected, "target peer not reachable")
+	}
+	return nil
+}
+
+// broadcastPeerJoined announces a new connection to every other user.
+func (h *Hub) broadcastPeerJoined(ctx context.Context, joined ClientInfo) {
+	h.broadcastExcept(ctx, WSMessage{
+		Event: "PEER_JOINED",
+		Data: PeerJoinedData{
+			UserID:       joined.UserID,
+			Role:         joined.Role,
+			Name:         joined.name,
+			ConnectionID: joined.connID,
+			DeviceID:     joined.deviceID,
+		},
+	}, joined.UserID)
 }
 
-func getUserName(userID string) string {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// displayName prefers the stored profile name, then the token's name claim.
+func (h *Hub) displayName(ctx context.Context, claims *utils.Claims) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var user struct {
-		Name string `bson:"name"`
+	if user, err := h.store.Users.FindByAuth0ID(ctx, claims.UserID); err == nil && user.Name != "" {
+		return user.Name
 	}
-
-	err := database.DB.Collection("users").FindOne(ctx, bson.M{"auth0Id": userID}).Decode(&user)
-	if err != nil {
-		return "Unknown"
+	if claims.Name != "" {
+		return claims.Name
 	}
-
-	return user.Name
+	return "Unknown"
 }
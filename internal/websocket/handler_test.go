@@ -0,0 +1,459 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/users"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testAuth = utils.NewLocalAuthenticator("test-secret")
+
+// testClaims authenticates a bearer token like the REST middleware does.
+func testClaims(c *gin.Context) {
+	claims, err := testAuth.ValidateToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Set("claims", claims)
+	c.Set("userId", claims.UserID)
+	c.Set("role", claims.Role)
+}
+
+// testServer serves a hub on the memory broker and store. url is the
+// WebSocket endpoint; tickets, and the class event stream and long poll for
+// testClassID, are under base.
+type testServer struct {
+	hub  *Hub
+	st   *store.Store
+	url  string
+	base string
+}
+
+func newTestServer(t *testing.T, cfg config.WebSocket) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	st := store.NewMemory()
+	hub, err := NewHub(Options{
+		Config: cfg,
+		Store:  st,
+		Auth:   testAuth,
+		Users:  users.NewProvisioner(config.Auth{Provider: "local"}, st),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.GET("/ws", hub.HandleWebSocket)
+	r.POST("/ws/ticket", testClaims, hub.IssueTicket)
+	r.GET("/events", testClaims, func(c *gin.Context) {
+		hub.StreamClassEvents(c, testClassID, c.GetString("userId"))
+	})
+	r.GET("/events/poll", testClaims, func(c *gin.Context) {
+		hub.PollClassEvents(c, testClassID, c.GetString("userId"))
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return &testServer{hub: hub, st: st, url: "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws", base: srv.URL}
+}
+
+// testFrame is a V2 frame as a client decodes it.
+type testFrame struct {
+	V       int             `json:"v"`
+	ID      string          `json:"id"`
+	ReplyTo string          `json:"replyTo"`
+	Seq     uint64          `json:"seq"`
+	Event   string          `json:"event"`
+	Data    json.RawMessage `json:"data"`
+	Error   *Error          `json:"error"`
+}
+
+// dialResponse connects userID over protocol V2 with a bearer token and the
+// query string query.
+func (s *testServer) dialResponse(t *testing.T, userID, role, query string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	token, err := testAuth.Mint(utils.Claims{UserID: userID, Role: role, Name: userID}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := websocket.Dialer{
+		HandshakeTimeout: 2 * time.Second,
+		Subprotocols:     []string{protocolPrefix + "2", bearerProtocol, token},
+	}
+	url := s.url
+	if query != "" {
+		url += "?" + query
+	}
+	conn, resp, err := d.Dial(url, nil)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// dial connects userID and waits for its WELCOME.
+func (s *testServer) dial(t *testing.T, userID, role, query string) (*websocket.Conn, WelcomeData) {
+	t.Helper()
+	conn, _, err := s.dialResponse(t, userID, role, query)
+	if err != nil {
+		t.Fatalf("dial %s: %v", userID, err)
+	}
+	var welcome WelcomeData
+	readEvent(t, conn, "WELCOME", &welcome)
+	return conn, welcome
+}
+
+// dialAuthMessage connects over protocol V2 without credentials and sends
+// userID's token in an AUTH message.
+func (s *testServer) dialAuthMessage(t *testing.T, userID, role, query string) *websocket.Conn {
+	t.Helper()
+	d := websocket.Dialer{HandshakeTimeout: 2 * time.Second, Subprotocols: []string{protocolPrefix + "2"}}
+	url := s.url
+	if query != "" {
+		url += "?" + query
+	}
+	conn, _, err := d.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", userID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	token, _ := testAuth.Mint(utils.Claims{UserID: userID, Role: role, Name: userID}, time.Hour)
+	send(t, conn, "a1", "AUTH", AuthPayload{Token: token})
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, id, event string, data interface{}) {
+	t.Helper()
+	raw, _ := json.Marshal(data)
+	if err := conn.WriteJSON(inbound{ID: id, Event: event, Data: raw}); err != nil {
+		t.Fatalf("send %s: %v", event, err)
+	}
+}
+
+// readEvent reads frames until one for event arrives and decodes its data
+// into v, which may be nil.
+func readEvent(t *testing.T, conn *websocket.Conn, event string, v interface{}) testFrame {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var f testFrame
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("waiting for %s: %v", event, err)
+		}
+		if f.Event != event {
+			continue
+		}
+		if v != nil {
+			if err := json.Unmarshal(f.Data, v); err != nil {
+				t.Fatalf("decode %s: %v", event, err)
+			}
+		}
+		return f
+	}
+}
+
+// readReply reads frames until the ACK or ERROR replying to id arrives.
+func readReply(t *testing.T, conn *websocket.Conn, id string) testFrame {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var f testFrame
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatalf("waiting for the reply to %s: %v", id, err)
+		}
+		if f.ReplyTo == id && (f.Event == "ACK" || f.Event == "ERROR") {
+			return f
+		}
+	}
+}
+
+// expectClose reads until the server closes conn and checks the close code.
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, code) {
+				t.Fatalf("connection ended with %v, want close code %d", err, code)
+			}
+			return
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+const testClassID = "64b000000000000000000002"
+
+// startSession makes teacherID's session the active one.
+func (s *testServer) startSession(teacherID string) {
+	s.hub.session.Set(&session.ActiveSession{
+		SessionID:  "64b000000000000000000001",
+		ClassID:    testClassID,
+		TeacherID:  teacherID,
+		RoomID:     "room1",
+		Attendance: map[string]string{},
+	})
+}
+
+// connections counts userID's registered connections.
+func (s *testServer) connections(userID string) int {
+	s.hub.clientsMu.RLock()
+	defer s.hub.clientsMu.RUnlock()
+	n := 0
+	for _, info := range s.hub.clients {
+		if info.UserID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// A V2 message with an id is acknowledged, or answered with an error that
+// replies to it.
+func TestAckAndErrorReplies(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn, welcome := s.dial(t, "st1", "student", "")
+	if welcome.Protocol != ProtocolV2 || welcome.ResumeToken == "" {
+		t.Fatalf("welcome = %+v", welcome)
+	}
+
+	send(t, conn, "m1", "MEDIA_STATE", MediaState{Audio: true})
+	if f := readEvent(t, conn, "ACK", nil); f.ReplyTo != "m1" {
+		t.Errorf("ACK replyTo = %q, want m1", f.ReplyTo)
+	}
+
+	send(t, conn, "m2", "NO_SUCH_EVENT", nil)
+	f := readEvent(t, conn, "ERROR", nil)
+	if f.ReplyTo != "m2" || f.Error == nil || f.Error.Code != CodeUnknownEvent {
+		t.Errorf("error reply = %+v", f)
+	}
+}
+
+// Only the session's teacher may mark attendance, summarize or end the
+// session.
+func TestAttendanceSessionTeacherOnly(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	s.startSession("t1")
+	owner, _ := s.dial(t, "t1", "teacher", "")
+	other, _ := s.dial(t, "t2", "teacher", "")
+
+	for _, ev := range []struct {
+		event string
+		data  interface{}
+	}{
+		{"ATTENDANCE_MARKED", MarkAttendancePayload{StudentID: "st1", Status: "present"}},
+		{"TODAY_SUMMARY", nil},
+		{"DONE", nil},
+	} {
+		send(t, other, ev.event, ev.event, ev.data)
+		if f := readReply(t, other, ev.event); f.Error == nil || f.Error.Code != CodeForbidden {
+			t.Errorf("%s from another teacher = %+v, want %s", ev.event, f, CodeForbidden)
+		}
+	}
+	if cur := s.hub.session.Get(); cur == nil || len(cur.Attendance) != 0 {
+		t.Fatalf("session after another teacher's events = %+v, want it unchanged", cur)
+	}
+
+	send(t, owner, "m1", "ATTENDANCE_MARKED", MarkAttendancePayload{StudentID: "st1", Status: "present"})
+	if f := readReply(t, owner, "m1"); f.Event != "ACK" {
+		t.Errorf("ATTENDANCE_MARKED from the session's teacher = %+v", f)
+	}
+}
+
+// failingAttendance fails every Replace while err is set.
+type failingAttendance struct {
+	store.AttendanceRepository
+	err error
+}
+
+func (f *failingAttendance) Replace(ctx context.Context, records []models.Attendance) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.AttendanceRepository.Replace(ctx, records)
+}
+
+// DONE reports a failure to save attendance and keeps the session, so the
+// marks survive and DONE can be retried.
+func TestDoneKeepsSessionWhenSaveFails(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	classID, _ := primitive.ObjectIDFromHex(testClassID)
+	if err := s.st.Classes.Create(t.Context(), &models.Class{ID: classID, TeacherID: "t1", StudentIDs: []string{"st1", "st2"}}); err != nil {
+		t.Fatal(err)
+	}
+	attendance := &failingAttendance{AttendanceRepository: s.st.Attendance, err: errors.New("disk full")}
+	s.st.Attendance = attendance
+	s.startSession("t1")
+	teacher, _ := s.dial(t, "t1", "teacher", "")
+
+	send(t, teacher, "m1", "ATTENDANCE_MARKED", MarkAttendancePayload{StudentID: "st1", Status: "present"})
+	readReply(t, teacher, "m1")
+
+	send(t, teacher, "d1", "DONE", nil)
+	if f := readReply(t, teacher, "d1"); f.Error == nil {
+		t.Fatalf("DONE with a failing store = %+v, want an error", f)
+	}
+	if cur := s.hub.session.Get(); cur == nil || cur.Attendance["st1"] != "present" {
+		t.Fatalf("session after failed DONE = %+v, want it kept with its marks", cur)
+	}
+
+	attendance.err = nil
+	send(t, teacher, "d2", "DONE", nil)
+	if f := readReply(t, teacher, "d2"); f.Event != "ACK" {
+		t.Fatalf("retried DONE = %+v", f)
+	}
+	if s.hub.session.Get() != nil {
+		t.Error("session still active after DONE")
+	}
+	if a, err := s.st.Attendance.Find(t.Context(), classID, "st2"); err != nil || a.Status != "absent" {
+		t.Errorf("st2 attendance = %+v, %v, want absent", a, err)
+	}
+}
+
+// A ticket authenticates one upgrade and is then used up.
+func TestTicketAuthIsSingleUse(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	token, _ := testAuth.Mint(utils.Claims{UserID: "st1", Role: "student"}, time.Hour)
+	req, _ := http.NewRequest(http.MethodPost, s.base+"/ws/ticket", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Data struct {
+			Ticket string `json:"ticket"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Data.Ticket == "" {
+		t.Fatalf("ticket response: %v, %+v", err, body)
+	}
+
+	d := websocket.Dialer{HandshakeTimeout: 2 * time.Second, Subprotocols: []string{protocolPrefix + "2"}}
+	conn, _, err := d.Dial(s.url+"?ticket="+body.Data.Ticket, nil)
+	if err != nil {
+		t.Fatalf("dial with a ticket: %v", err)
+	}
+	defer conn.Close()
+	readEvent(t, conn, "WELCOME", nil)
+	if n := s.connections("st1"); n != 1 {
+		t.Errorf("st1 has %d connections, want 1", n)
+	}
+
+	if _, resp, err := d.Dial(s.url+"?ticket="+body.Data.Ticket, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("second use of a ticket: err = %v, resp = %+v, want 401", err, resp)
+	}
+}
+
+// The token offered after the "bearer" subprotocol authenticates the
+// upgrade, which selects the protocol version rather than "bearer".
+func TestBearerSubprotocolAuth(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn, resp, err := s.dialResponse(t, "st1", "student", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := resp.Header.Get("Sec-Websocket-Protocol"); p != protocolPrefix+"2" {
+		t.Errorf("selected subprotocol = %q, want %s", p, protocolPrefix+"2")
+	}
+	readEvent(t, conn, "WELCOME", nil)
+
+	d := websocket.Dialer{
+		HandshakeTimeout: 2 * time.Second,
+		Subprotocols:     []string{protocolPrefix + "2", bearerProtocol, "not-a-token"},
+	}
+	if _, resp, err := d.Dial(s.url, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("invalid bearer token: err = %v, resp = %+v, want 401", err, resp)
+	}
+}
+
+// Without credentials on the upgrade the first message must be AUTH; the
+// WELCOME replies to it, and anything else closes with 4001.
+func TestFirstMessageAuth(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn := s.dialAuthMessage(t, "st1", "student", "")
+	if f := readEvent(t, conn, "WELCOME", nil); f.ReplyTo != "a1" {
+		t.Errorf("WELCOME replyTo = %q, want a1", f.ReplyTo)
+	}
+
+	d := websocket.Dialer{HandshakeTimeout: 2 * time.Second, Subprotocols: []string{protocolPrefix + "2"}}
+	other, _, err := d.Dial(s.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	send(t, other, "m1", "MEDIA_STATE", MediaState{Audio: true})
+	expectClose(t, other, closeAuthFailed)
+}
+
+// A connection is asked to REAUTH before its token expires, and a fresh
+// token for the same user keeps it open past the old expiry.
+func TestReauthExtendsConnection(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn, welcome := s.dial(t, "st1", "student", "")
+	expiresAt, err := time.Parse(time.RFC3339, *welcome.ExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.hub.sweepExpired(expiresAt.Add(-reauthWindow / 2))
+	readEvent(t, conn, "REAUTH", nil)
+
+	other, _ := testAuth.Mint(utils.Claims{UserID: "st2", Role: "student"}, 2*time.Hour)
+	send(t, conn, "r1", "AUTH", AuthPayload{Token: other})
+	if f := readReply(t, conn, "r1"); f.Error == nil || f.Error.Code != CodeUnauthorized {
+		t.Errorf("AUTH with another user's token = %+v, want %s", f, CodeUnauthorized)
+	}
+
+	fresh, _ := testAuth.Mint(utils.Claims{UserID: "st1", Role: "student"}, 2*time.Hour)
+	send(t, conn, "r2", "AUTH", AuthPayload{Token: fresh})
+	if f := readEvent(t, conn, "AUTH_OK", nil); f.ReplyTo != "r2" {
+		t.Fatalf("AUTH_OK replyTo = %q, want r2", f.ReplyTo)
+	}
+
+	s.hub.sweepExpired(expiresAt.Add(time.Second))
+	send(t, conn, "m1", "MEDIA_STATE", MediaState{Audio: true})
+	if f := readReply(t, conn, "m1"); f.Event != "ACK" {
+		t.Errorf("after the old expiry = %+v, want the connection still open", f)
+	}
+}
+
+// A connection whose token expires is closed with 4002.
+func TestExpiredTokenCloses(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn, _ := s.dial(t, "st1", "student", "")
+
+	s.hub.sweepExpired(time.Now().Add(2 * time.Hour))
+	expectClose(t, conn, closeAuthExpired)
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+}
@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+)
+
+// maxHandRaises bounds the raises recorded in one session.
+const maxHandRaises = 1000
+
+// HAND_QUEUE actions; all but handRaised are also the outcome recorded for
+// the raise.
+const (
+	handRaised       = "raised"
+	handLowered      = "lowered"
+	handAcknowledged = "acknowledged"
+	handCleared      = "cleared"
+	// handUnanswered is recorded for raises still open when the session ends.
+	handUnanswered = "unanswered"
+)
+
+// HandPayload is the data of HAND_ACK and HAND_CLEAR. Without a userId,
+// HAND_ACK takes the head of the queue and HAND_CLEAR empties it.
+type HandPayload struct {
+	UserID string `json:"userId"`
+}
+
+func (p *HandPayload) Validate() error {
+	return nil
+}
+
+// QueuedHand is a raised hand waiting for the teacher. Position starts at 1.
+type QueuedHand struct {
+	UserID   string    `json:"userId"`
+	RaisedAt time.Time `json:"raisedAt"`
+	Position int       `json:"position"`
+}
+
+// HandQueueData is broadcast whenever the queue changes. UserID is empty
+// when the whole queue was cleared.
+type HandQueueData struct {
+	Action string       `json:"action"`
+	UserID string       `json:"userId,omitempty"`
+	Queue  []QueuedHand `json:"queue"`
+}
+
+// handQueue lists the open raises of s in the order they were raised.
+func handQueue(s *session.ActiveSession) []QueuedHand {
+	queue := []QueuedHand{}
+	for _, h := range s.Hands {
+		if h.Outcome == "" {
+			queue = append(queue, QueuedHand{UserID: h.StudentID, RaisedAt: h.RaisedAt, Position: len(queue) + 1})
+		}
+	}
+	return queue
+}
+
+// currentHandQueue is the queue of the active session, or nil without one.
+func (h *Hub) currentHandQueue() (queue []QueuedHand) {
+	h.session.WithRead(func(s *session.ActiveSession) {
+		queue = handQueue(s)
+	})
+	return queue
+}
+
+// resolveHands closes the open raises of userID, or every open raise when
+// userID is empty, and reports how many it closed.
+func resolveHands(s *session.ActiveSession, userID, outcome string, now time.Time) int {
+	n := 0
+	for i := range s.Hands {
+		h := &s.Hands[i]
+		if h.Outcome == "" && (userID == "" || h.StudentID == userID) {
+			h.Outcome = outcome
+			h.ResolvedAt = now
+			n++
+		}
+	}
+	return n
+}
+
+// updateHands applies fn to the active session and, if fn reports a change,
+// broadcasts the new queue along with the user fn says it concerned.
+func (h *Hub) updateHands(ctx context.Context, action string, fn func(s *session.ActiveSession) (userID string, changed bool, err error)) error {
+	if h.session.Get() == nil {
+		return errNoSession
+	}
+
+	var (
+		userID  string
+		changed bool
+		err     error
+		queue   []QueuedHand
+	)
+	h.session.WithWrite(func(s *session.ActiveSession) {
+		if userID, changed, err = fn(s); changed {
+			queue = handQueue(s)
+		}
+	})
+	if err != nil || !changed {
+		return err
+	}
+
+	h.broadcast(ctx, WSMessage{
+		Event: "HAND_QUEUE",
+		Data:  HandQueueData{Action: action, UserID: userID, Queue: queue},
+	})
+	return nil
+}
+
+// handleHandRaise puts the student at the back of the queue. Raising an
+// already raised hand keeps its place.
+func (h *Hub) handleHandRaise(ctx context.Context, req *request) error {
+	if err := requireRole(req.client, "student"); err != nil {
+		return err
+	}
+	userID := req.client.UserID
+
+	return h.updateHands(ctx, handRaised, func(s *session.ActiveSession) (string, bool, error) {
+		for _, raise := range s.Hands {
+			if raise.StudentID == userID && raise.Outcome == "" {
+				return userID, false, nil
+			}
+		}
+		if len(s.Hands) >= maxHandRaises {
+			return userID, false, protoErr(CodeRateLimited, "hand raise limit reached for this session")
+		}
+		s.Hands = append(s.Hands, session.HandRaise{StudentID: userID, RaisedAt: time.Now().UTC()})
+		return userID, true, nil
+	})
+}
+
+func (h *Hub) handleHandLower(ctx context.Context, req *request) error {
+	if err := requireRole(req.client, "student"); err != nil {
+		return err
+	}
+	userID := req.client.UserID
+
+	return h.updateHands(ctx, handLowered, func(s *session.ActiveSession) (string, bool, error) {
+		return userID, resolveHands(s, userID, handLowered, time.Now().UTC()) > 0, nil
+	})
+}
+
+func (h *Hub) handleHandAck(ctx context.Context, req *request) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
+	}
+	var p HandPayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+
+	return h.updateHands(ctx, handAcknowledged, func(s *session.ActiveSession) (string, bool, error) {
+		userID := p.UserID
+		if userID == "" {
+			queue := handQueue(s)
+			if len(queue) == 0 {
+				return "", false, protoErr(CodeInvalidPayload, "no hands are raised")
+			}
+			userID = queue[0].UserID
+		}
+		if resolveHands(s, userID, handAcknowledged, time.Now().UTC()) == 0 {
+			return userID, false, protoErr(CodeInvalidPayload, "hand is not raised")
+		}
+		return userID, true, nil
+	})
+}
+
+func (h *Hub) handleHandClear(ctx context.Context, req *request) error {
+	if err := h.requireSessionTeacher(req.client); err != nil {
+		return err
+	}
+	var p HandPayload
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+
+	return h.updateHands(ctx, handCleared, func(s *session.ActiveSession) (string, bool, error) {
+		return p.UserID, resolveHands(s, p.UserID, handCleared, time.Now().UTC()) > 0, nil
+	})
+}
+
+// handRaiseRecords converts the session's raises for the session record.
+// Raises still open at end are recorded as unanswered; with a zero end they
+// are left open.
+func handRaiseRecords(hands []session.HandRaise, end time.Time) []models.HandRaise {
+	records := make([]models.HandRaise, 0, len(hands))
+	for _, h := range hands {
+		rec := models.HandRaise{StudentID: h.StudentID, RaisedAt: h.RaisedAt, Outcome: h.Outcome}
+		resolvedAt := h.ResolvedAt
+		if rec.Outcome == "" && !end.IsZero() {
+			rec.Outcome, resolvedAt = handUnanswered, end
+		}
+		if !resolvedAt.IsZero() {
+			rec.ResolvedAt = &resolvedAt
+		}
+		records = append(records, rec)
+	}
+	return records
+}
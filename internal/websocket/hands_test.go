@@ -0,0 +1,39 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+)
+
+// Only the teacher running the session may acknowledge or clear hands.
+func TestHandsSessionTeacherOnly(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	s.startSession("t1")
+	student, _ := s.dial(t, "st1", "student", "")
+	owner, _ := s.dial(t, "t1", "teacher", "")
+	other, _ := s.dial(t, "t2", "teacher", "")
+
+	send(t, student, "r1", "HAND_RAISE", nil)
+	if f := readReply(t, student, "r1"); f.Event != "ACK" {
+		t.Fatalf("HAND_RAISE reply = %+v", f)
+	}
+
+	for _, event := range []string{"HAND_ACK", "HAND_CLEAR"} {
+		send(t, other, event, event, HandPayload{UserID: "st1"})
+		if f := readReply(t, other, event); f.Error == nil || f.Error.Code != CodeForbidden {
+			t.Errorf("%s from another teacher = %+v, want %s", event, f, CodeForbidden)
+		}
+	}
+	if queue := s.hub.currentHandQueue(); len(queue) != 1 {
+		t.Fatalf("queue = %+v, want st1's raise", queue)
+	}
+
+	send(t, owner, "a1", "HAND_ACK", HandPayload{UserID: "st1"})
+	if f := readReply(t, owner, "a1"); f.Event != "ACK" {
+		t.Fatalf("HAND_ACK from the session's teacher = %+v", f)
+	}
+	if queue := s.hub.currentHandQueue(); len(queue) != 0 {
+		t.Errorf("queue = %+v, want empty", queue)
+	}
+}
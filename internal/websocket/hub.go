@@ -0,0 +1,510 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/broker"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/store"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// Broker topics and keys shared by every instance.
+const (
+	topicBroadcast = "broadcast"
+	topicSignal    = "signal"
+	topicSession   = "session"
+	topicRoster    = "roster"
+
+	sessionKey = "session"
+
+	// rosterTTL is how long another instance's roster is trusted without
+	// a refresh. Instances republish on every scheduler pass.
+	rosterTTL = 3 * expiryInterval
+)
+
+// busMessage is a room broadcast relayed to the other instances, which
+// record it in their own room log and deliver it to their clients.
+type busMessage struct {
+	Origin      string          `json:"origin"`
+	Room        string          `json:"room"`
+	ExcludeUser string          `json:"excludeUser,omitempty"`
+	Event       string          `json:"event"`
+	ID          string          `json:"id"`
+	Seq         uint64          `json:"seq"`
+	Data        json.RawMessage `json:"data,omitempty"`
+}
+
+// signalMessage carries a WebRTC signal to the instance holding the target
+// connection.
+type signalMessage struct {
+	TargetConnectionID string          `json:"targetConnectionId"`
+	Event              string          `json:"event"`
+	Data               json.RawMessage `json:"data"`
+}
+
+// sessionMessage tells the other instances which fields of the session hash
+// changed. Reset is set when the session started or ended and the whole hash
+// must be read again.
+type sessionMessage struct {
+	Fields []string `json:"fields,omitempty"`
+	Reset  bool     `json:"reset,omitempty"`
+}
+
+// roster lists the connections held by one instance. It doubles as the
+// instance's heartbeat; Leaving is set when it shuts down.
+type roster struct {
+	Instance string        `json:"instance"`
+	Clients  []rosterEntry `json:"clients"`
+	Leaving  bool          `json:"leaving,omitempty"`
+
+	receivedAt time.Time
+}
+
+type rosterEntry struct {
+	UserID       string     `json:"userId"`
+	Name         string     `json:"name"`
+	Role         string     `json:"role"`
+	ConnectionID string     `json:"connectionId"`
+	DeviceID     string     `json:"deviceId"`
+	Media        MediaState `json:"media"`
+	ConnectedAt  time.Time  `json:"connectedAt"`
+}
+
+// Hub serves the WebSocket connections held by this instance and shares
+// its rooms with other instances through the broker.
+type Hub struct {
+	opts     *connOptions
+	upgrader *websocket.Upgrader
+	store    *store.Store
+	auth     utils.Authenticator
+	session  *session.State
+
+	bus        broker.Broker
+	instanceID string
+
+	// replayBuffer is how many events each room log keeps for resuming
+	// clients, and resumeTTL how long a resume token stays valid.
+	replayBuffer int
+	resumeTTL    time.Duration
+	// pollTimeout is how long a long poll waits for an event.
+	pollTimeout time.Duration
+
+	clients   map[*websocket.Conn]ClientInfo
+	clientsMu sync.RWMutex
+
+	remoteRosters   map[string]roster
+	remoteRostersMu sync.RWMutex
+
+	messageSeq atomic.Uint64
+	// messageIDPrefix keeps ids unique across instances sharing a broker.
+	messageIDPrefix string
+
+	// userBuckets are shared by all of a user's connections on this
+	// instance.
+	userBuckets   map[string]*bucketSet
+	userBucketsMu sync.Mutex
+
+	roomLogs   map[string]*eventLog
+	roomLogsMu sync.Mutex
+
+	// streams are closed by whoever removes them from the map.
+	streams   map[*stream]struct{}
+	streamsMu sync.Mutex
+
+	shuttingDown atomic.Bool
+	// connWG tracks running handleMessages loops so Shutdown can wait for
+	// every socket to be released.
+	connWG sync.WaitGroup
+
+	// replication stores and reads the session in the broker; it is nil
+	// unless the broker is distributed.
+	replication *replicationQueue
+
+	schedulerOnce sync.Once
+	schedulerStop chan struct{}
+	// lastSweep is the unix nano time of the scheduler's latest pass, zero
+	// until it has run once.
+	lastSweep atomic.Int64
+}
+
+// NewHub creates a hub on opts.Broker, or an in-memory broker if it is nil.
+// With a distributed broker it subscribes to the shared topics, adopts the
+// session other instances are running and replicates local session
+// changes. It must be called before the server accepts connections.
+func NewHub(opts Options) (*Hub, error) {
+	h := &Hub{
+		opts:            newConnOptions(opts),
+		store:           opts.Store,
+		auth:            opts.Auth,
+		session:         opts.Session,
+		bus:             opts.Broker,
+		instanceID:      logging.NewID(),
+		replayBuffer:    256,
+		resumeTTL:       2 * time.Minute,
+		pollTimeout:     25 * time.Second,
+		clients:         make(map[*websocket.Conn]ClientInfo),
+		remoteRosters:   make(map[string]roster),
+		messageIDPrefix: "s",
+		userBuckets:     make(map[string]*bucketSet),
+		roomLogs:        make(map[string]*eventLog),
+		streams:         make(map[*stream]struct{}),
+		schedulerStop:   make(chan struct{}),
+	}
+	h.upgrader = &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return opts.Origins == nil || opts.Origins.AllowRequest(r)
+		},
+		Subprotocols: append(protocolSubprotocols(), bearerProtocol),
+	}
+	if opts.Config.ReplayBuffer > 0 {
+		h.replayBuffer = opts.Config.ReplayBuffer
+	}
+	if opts.Config.ResumeWindow > 0 {
+		h.resumeTTL = opts.Config.ResumeWindow
+	}
+	if opts.Config.PollTimeout > 0 {
+		h.pollTimeout = opts.Config.PollTimeout
+	}
+	if h.bus == nil {
+		h.bus = broker.NewMemory()
+	}
+	if h.session == nil {
+		h.session = session.New()
+	}
+	if !h.bus.Distributed() {
+		return h, nil
+	}
+	h.messageIDPrefix = h.instanceID[:8] + "-s"
+	h.replication = newReplicationQueue()
+
+	subscriptions := map[string]func([]byte){
+		topicBroadcast: h.onBroadcast,
+		topicSignal:    h.onSignal,
+		topicSession: func(payload []byte) {
+			h.replication.push(func() { h.onSession(payload) })
+		},
+		topicRoster: h.onRoster,
+	}
+	for topic, handler := range subscriptions {
+		if err := h.bus.Subscribe(topic, handler); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields, err := h.bus.HGetAll(ctx, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if s := decodeSession(fields); s != nil {
+		h.session.Apply(s)
+		slog.Info("joined active session from broker", "session_id", s.SessionID)
+	}
+
+	h.session.OnChange(func(prev, cur *session.ActiveSession) {
+		h.replication.push(func() { h.publishSession(prev, cur) })
+	})
+	h.publishRoster()
+	slog.Info("WebSocket hub sharing rooms through the broker", "instance", h.instanceID)
+	return h, nil
+}
+
+// publish sends v on topic, logging rather than returning failures: a
+// broadcast has already been delivered locally by the time it is published.
+func (h *Hub) publish(ctx context.Context, topic string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = h.bus.Publish(ctx, topic, data)
+	}
+	if err != nil {
+		slog.Warn("broker publish failed", "topic", topic, "error", err)
+	}
+}
+
+// nextSeq returns the room's next sequence number from the broker, or zero
+// to let the local log number the event when there is a single instance.
+func (h *Hub) nextSeq(ctx context.Context, room string) uint64 {
+	if !h.bus.Distributed() {
+		return 0
+	}
+	seq, err := h.bus.Incr(ctx, "seq:"+room, 0)
+	if err != nil {
+		slog.Warn("broker sequence failed, numbering locally", "room", room, "error", err)
+		return 0
+	}
+	return seq
+}
+
+func (h *Hub) publishBroadcast(ctx context.Context, room, excludeUser string, msg WSMessage) {
+	if !h.bus.Distributed() {
+		return
+	}
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		slog.Warn("failed to encode broadcast", "event", msg.Event, "error", err)
+		return
+	}
+	h.publish(ctx, topicBroadcast, busMessage{
+		Origin:      h.instanceID,
+		Room:        room,
+		ExcludeUser: excludeUser,
+		Event:       msg.Event,
+		ID:          msg.ID,
+		Seq:         msg.Seq,
+		Data:        data,
+	})
+}
+
+func (h *Hub) onBroadcast(payload []byte) {
+	var m busMessage
+	if err := json.Unmarshal(payload, &m); err != nil || m.Origin == h.instanceID {
+		return
+	}
+	msg := WSMessage{Event: m.Event, ID: m.ID, Seq: m.Seq, excludeUser: m.ExcludeUser}
+	if len(m.Data) > 0 && string(m.Data) != "null" {
+		msg.Data = m.Data
+	}
+	msg = h.roomLog(m.Room).append(msg)
+	h.deliver(msg, m.ExcludeUser)
+	h.notifyStreams(m.Room, msg)
+}
+
+// signalTarget finds where a signal should go: a local connection, or the
+// id of a connection held by another instance. Both are empty if the peer
+// is not connected anywhere.
+func (h *Hub) signalTarget(p SignalPayload) (*websocket.Conn, string) {
+	if p.TargetConnectionID != "" {
+		if conn := h.connectionByID(p.TargetConnectionID); conn != nil {
+			return conn, ""
+		}
+		for _, e := range h.remoteEntries() {
+			if e.ConnectionID == p.TargetConnectionID {
+				return nil, e.ConnectionID
+			}
+		}
+		return nil, ""
+	}
+
+	// Otherwise the user's most recent connection on any instance.
+	var local *websocket.Conn
+	var localAt time.Time
+	if conns := h.userConnections(p.TargetID); len(conns) > 0 {
+		local = conns[0]
+		localAt = h.getClient(local).connectedAt
+	}
+	remote := ""
+	for _, e := range h.remoteEntries() {
+		if e.UserID == p.TargetID && e.ConnectedAt.After(localAt) {
+			remote, localAt = e.ConnectionID, e.ConnectedAt
+		}
+	}
+	if remote != "" {
+		return nil, remote
+	}
+	return local, ""
+}
+
+func (h *Hub) publishSignal(ctx context.Context, connID string, msg WSMessage) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	payload, _ := json.Marshal(signalMessage{TargetConnectionID: connID, Event: msg.Event, Data: data})
+	return h.bus.Publish(ctx, topicSignal, payload)
+}
+
+func (h *Hub) onSignal(payload []byte) {
+	var m signalMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return
+	}
+	if conn := h.connectionByID(m.TargetConnectionID); conn != nil {
+		h.sendToClient(conn, WSMessage{Event: m.Event, Data: m.Data})
+	}
+}
+
+// publishSession stores the fields of the session that a local change
+// touched and announces them, or the whole session when it starts or ends.
+// It runs on the replication queue, in the order changes were made.
+func (h *Hub) publishSession(prev, cur *session.ActiveSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		m   sessionMessage
+		err error
+	)
+	switch {
+	case cur == nil:
+		m.Reset = true
+		err = h.bus.Delete(ctx, sessionKey)
+	case prev == nil || prev.SessionID != cur.SessionID:
+		m.Reset = true
+		err = h.storeSession(ctx, cur)
+	default:
+		m.Fields, err = h.storeSessionChanges(ctx, prev, cur)
+		if err == nil && len(m.Fields) == 0 {
+			return
+		}
+	}
+	if err != nil {
+		slog.Warn("failed to store session in broker", "error", err)
+	}
+	h.publish(ctx, topicSession, m)
+}
+
+// onSession reads the fields another instance changed back from the broker,
+// which holds the latest write to each. It runs on the replication queue
+// rather than the broker's delivery goroutine. Instances also handle their own
+// messages, so a field written concurrently ends up with the same value
+// everywhere.
+func (h *Hub) onSession(payload []byte) {
+	var m sessionMessage
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fields, err := h.bus.HGetAll(ctx, sessionKey)
+	if err != nil {
+		slog.Warn("failed to read session from broker", "error", err)
+		return
+	}
+	stored := decodeSession(fields)
+	if s := h.session.Get(); m.Reset || s == nil || stored == nil || s.SessionID != stored.SessionID {
+		h.session.Apply(stored)
+		return
+	}
+	h.session.ApplyChange(func(s *session.ActiveSession) {
+		applySessionFields(s, fields, m.Fields)
+	})
+}
+
+// publishRoster tells the other instances which connections this one holds.
+func (h *Hub) publishRoster() {
+	if !h.bus.Distributed() {
+		return
+	}
+	h.clientsMu.RLock()
+	entries := make([]rosterEntry, 0, len(h.clients))
+	for _, info := range h.clients {
+		entries = append(entries, rosterEntry{
+			UserID:       info.UserID,
+			Name:         info.name,
+			Role:         info.Role,
+			ConnectionID: info.connID,
+			DeviceID:     info.deviceID,
+			Media:        info.media,
+			ConnectedAt:  info.connectedAt,
+		})
+	}
+	h.clientsMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h.publish(ctx, topicRoster, roster{Instance: h.instanceID, Clients: entries})
+}
+
+// leaveRoster tells the other instances this one is gone.
+func (h *Hub) leaveRoster(ctx context.Context) {
+	if h.bus.Distributed() {
+		h.publish(ctx, topicRoster, roster{Instance: h.instanceID, Leaving: true})
+	}
+}
+
+func (h *Hub) onRoster(payload []byte) {
+	var r roster
+	if err := json.Unmarshal(payload, &r); err != nil || r.Instance == h.instanceID {
+		return
+	}
+	r.receivedAt = time.Now()
+
+	h.remoteRostersMu.Lock()
+	if r.Leaving {
+		delete(h.remoteRosters, r.Instance)
+	} else {
+		h.remoteRosters[r.Instance] = r
+	}
+	h.remoteRostersMu.Unlock()
+}
+
+// remoteEntries lists the connections held by other live instances.
+func (h *Hub) remoteEntries() []rosterEntry {
+	h.remoteRostersMu.RLock()
+	defer h.remoteRostersMu.RUnlock()
+
+	var entries []rosterEntry
+	for _, r := range h.remoteRosters {
+		if time.Since(r.receivedAt) <= rosterTTL {
+			entries = append(entries, r.Clients...)
+		}
+	}
+	return entries
+}
+
+// remoteInstances returns the other instances that are still running.
+func (h *Hub) remoteInstances() []string {
+	h.remoteRostersMu.RLock()
+	defer h.remoteRostersMu.RUnlock()
+
+	var ids []string
+	for id, r := range h.remoteRosters {
+		if time.Since(r.receivedAt) <= rosterTTL {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// pruneRosters forgets instances that stopped publishing, e.g. after a crash.
+func (h *Hub) pruneRosters(now time.Time) {
+	h.remoteRostersMu.Lock()
+	for id, r := range h.remoteRosters {
+		if now.Sub(r.receivedAt) > rosterTTL {
+			delete(h.remoteRosters, id)
+		}
+	}
+	h.remoteRostersMu.Unlock()
+}
+
+// BrokerHealth reports whether the broker is reachable and which other
+// instances share rooms with this one.
+type BrokerHealth struct {
+	Status      string   `json:"status"`
+	Distributed bool     `json:"distributed"`
+	Instance    string   `json:"instance"`
+	Peers       []string `json:"peers"`
+	Error       string   `json:"error,omitempty"`
+}
+
+func (h *Hub) CheckBroker(ctx context.Context) BrokerHealth {
+	health := BrokerHealth{
+		Status:      "up",
+		Distributed: h.bus.Distributed(),
+		Instance:    h.instanceID,
+		Peers:       h.remoteInstances(),
+	}
+	if health.Peers == nil {
+		health.Peers = []string{}
+	}
+	if err := h.bus.Ping(ctx); err != nil {
+		health.Status = "down"
+		health.Error = err.Error()
+	}
+	return health
+}
@@ -0,0 +1,122 @@
+package websocket
+
+import "encoding/json"
+
+// payload is the data of an inbound event.
+type payload interface {
+	Validate() error
+}
+
+// AuthPayload is the data of AUTH.
+type AuthPayload struct {
+	Token string `json:"token"`
+}
+
+func (p *AuthPayload) Validate() error {
+	if p.Token == "" {
+		return protoErr(CodeInvalidPayload, "token is required")
+	}
+	return nil
+}
+
+// MarkAttendancePayload is the data of ATTENDANCE_MARKED.
+type MarkAttendancePayload struct {
+	StudentID string `json:"studentId"`
+	Status    string `json:"status"`
+}
+
+func (p *MarkAttendancePayload) Validate() error {
+	if p.StudentID == "" {
+		return protoErr(CodeInvalidPayload, "invalid studentId")
+	}
+	if p.Status != "present" && p.Status != "absent" {
+		return protoErr(CodeInvalidPayload, "invalid status")
+	}
+	return nil
+}
+
+// SignalPayload is the data of WEBRTC_OFFER, WEBRTC_ANSWER and
+// WEBRTC_ICE_CANDIDATE. TargetConnectionID addresses one device; TargetID
+// alone reaches the user's most recent connection. The session description
+// or candidate is relayed untouched.
+type SignalPayload struct {
+	TargetID           string          `json:"targetId,omitempty"`
+	TargetConnectionID string          `json:"targetConnectionId,omitempty"`
+	Offer              json.RawMessage `json:"offer,omitempty"`
+	Answer             json.RawMessage `json:"answer,omitempty"`
+	Candidate          json.RawMessage `json:"candidate,omitempty"`
+}
+
+func (p *SignalPayload) Validate() error {
+	if p.TargetID == "" && p.TargetConnectionID == "" {
+		return protoErr(CodeInvalidPayload, "missing targetId or targetConnectionId in WebRTC message")
+	}
+	if p.Offer == nil && p.Answer == nil && p.Candidate == nil {
+		return protoErr(CodeInvalidPayload, "WebRTC message needs an offer, answer or candidate")
+	}
+	return nil
+}
+
+// Outbound event data.
+
+type WelcomeData struct {
+	Protocol     int     `json:"protocol"`
+	Supported    []int   `json:"supported"`
+	ConnectionID string  `json:"connectionId"`
+	DeviceID     string  `json:"deviceId"`
+	ExpiresAt    *string `json:"expiresAt"`
+	// ResumeToken and Seq are passed back as ?resume= and ?lastSeq= when
+	// reconnecting.
+	ResumeToken string `json:"resumeToken"`
+	Room        string `json:"room"`
+	Seq         uint64 `json:"seq"`
+	Resumed     bool   `json:"resumed"`
+}
+
+type AuthOKData struct {
+	ExpiresAt *string `json:"expiresAt"`
+}
+
+type AttendanceMarkedData struct {
+	StudentID string `json:"studentId"`
+	Status    string `json:"status"`
+}
+
+type SummaryData struct {
+	Present int `json:"present"`
+	Absent  int `json:"absent"`
+	Total   int `json:"total"`
+}
+
+type DoneData struct {
+	Message string `json:"message"`
+	SummaryData
+}
+
+type MyAttendanceData struct {
+	Status string `json:"status"`
+}
+
+type PeerJoinedData struct {
+	UserID       string `json:"userId"`
+	Role         string `json:"role"`
+	Name         string `json:"name"`
+	ConnectionID string `json:"connectionId"`
+	DeviceID     string `json:"deviceId"`
+}
+
+type SignalRelayData struct {
+	SignalPayload
+	FromID           string `json:"fromId"`
+	FromRole         string `json:"fromRole"`
+	FromConnectionID string `json:"fromConnectionId"`
+}
+
+type ShutdownData struct {
+	Message          string `json:"message"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+}
+
+func summarize(present, absent int) SummaryData {
+	return SummaryData{Present: present, Absent: absent, Total: present + absent}
+}
@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocol versions. Clients negotiate one by offering the subprotocol
+// "liveclassroom.v<N>" on the upgrade request; clients that offer none get
+// ProtocolV1.
+const (
+	// ProtocolV1 is the original bare {event, data} message with no ids,
+	// acknowledgements or error codes.
+	ProtocolV1 = 1
+	// ProtocolV2 wraps every message in an envelope with a message id,
+	// replyTo correlation, ACK/ERROR replies and structured error codes.
+	ProtocolV2 = 2
+
+	protocolPrefix = "liveclassroom.v"
+)
+
+var supportedProtocols = []int{ProtocolV1, ProtocolV2}
+
+// Error codes carried by ERROR messages.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownEvent       = "unknown_event"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNoActiveSession    = "no_active_session"
+	CodePeerNotConnected   = "peer_not_connected"
+	CodeRateLimited        = "rate_limited"
+	CodeMuted              = "muted"
+	CodeInternal           = "internal_error"
+)
+
+// Error is a protocol error reported to the client in an ERROR message.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func protoErr(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+var errNoSession = protoErr(CodeNoActiveSession, "No active attendance session")
+
+// WSMessage is an outbound message. ID is assigned when it is sent; ReplyTo
+// is the id of the client request it answers. Broadcasts also get the Seq of
+// their room's event log.
+type WSMessage struct {
+	Event   string
+	Data    interface{}
+	ID      string
+	ReplyTo string
+	Seq     uint64
+	Error   *Error
+
+	// room overrides the room a broadcast is logged to, for events sent
+	// after the session they belong to has been cleared.
+	room string
+	// excludeUser is the user a logged broadcast was not sent to, so it is
+	// not replayed to them either.
+	excludeUser string
+}
+
+// inbound is a frame received from a client. V1 clients send only event and
+// data.
+type inbound struct {
+	V     int             `json:"v,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// frame is the wire form of a WSMessage.
+type frame struct {
+	V       int         `json:"v,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	ReplyTo string      `json:"replyTo,omitempty"`
+	Seq     uint64      `json:"seq,omitempty"`
+	Event   string      `json:"event"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   *Error      `json:"error,omitempty"`
+}
+
+// legacyError is the data of an ERROR message sent to V1 clients.
+type legacyError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+func (m WSMessage) frame(version int) frame {
+	if version < ProtocolV2 {
+		f := frame{Event: m.Event, Data: m.Data}
+		if m.Error != nil {
+			f.Data = legacyError{Message: m.Error.Message, Code: m.Error.Code}
+		}
+		return f
+	}
+	return frame{
+		V:       version,
+		ID:      m.ID,
+		ReplyTo: m.ReplyTo,
+		Seq:     m.Seq,
+		Event:   m.Event,
+		Data:    m.Data,
+		Error:   m.Error,
+	}
+}
+
+func (h *Hub) nextMessageID() string {
+	return h.messageIDPrefix + strconv.FormatUint(h.messageSeq.Add(1), 10)
+}
+
+func protocolSubprotocols() []string {
+	protocols := make([]string, 0, len(supportedProtocols))
+	// Offer the newest version first; the upgrader picks the first match.
+	for i := len(supportedProtocols) - 1; i >= 0; i-- {
+		protocols = append(protocols, protocolPrefix+strconv.Itoa(supportedProtocols[i]))
+	}
+	return protocols
+}
+
+// negotiatedVersion returns the version selected during the upgrade.
+func negotiatedVersion(conn *websocket.Conn) int {
+	v, err := strconv.Atoi(strings.TrimPrefix(conn.Subprotocol(), protocolPrefix))
+	if err != nil || !strings.HasPrefix(conn.Subprotocol(), protocolPrefix) {
+		return ProtocolV1
+	}
+	return v
+}
+
+// request is one inbound message being handled.
+type request struct {
+	hub     *Hub
+	conn    *websocket.Conn
+	client  ClientInfo
+	msg     inbound
+	replied bool
+}
+
+// decode unmarshals the message data into p and validates it.
+func (r *request) decode(p payload) error {
+	if len(r.msg.Data) > 0 && string(r.msg.Data) != "null" {
+		if err := json.Unmarshal(r.msg.Data, p); err != nil {
+			return protoErr(CodeInvalidPayload, "malformed data")
+		}
+	}
+	return p.Validate()
+}
+
+// reply answers the request directly, in place of the ACK.
+func (r *request) reply(msg WSMessage) {
+	msg.ReplyTo = r.msg.ID
+	r.replied = true
+	r.hub.sendToClient(r.conn, msg)
+}
+
+// eventHandler handles one inbound event. A returned *Error is sent to the
+// client as is; any other error is reported as internal_error.
+type eventHandler func(h *Hub, ctx context.Context, req *request) error
+
+var eventHandlers = map[string]eventHandler{
+	"AUTH":                 (*Hub).handleReauth,
+	"ATTENDANCE_MARKED":    (*Hub).handleAttendanceMarked,
+	"TODAY_SUMMARY":        (*Hub).handleTodaySummary,
+	"MY_ATTENDANCE":        (*Hub).handleMyAttendance,
+	"DONE":                 (*Hub).handleDone,
+	"MEDIA_STATE":          (*Hub).handleMediaState,
+	"HAND_RAISE":           (*Hub).handleHandRaise,
+	"HAND_LOWER":           (*Hub).handleHandLower,
+	"HAND_ACK":             (*Hub).handleHandAck,
+	"HAND_CLEAR":           (*Hub).handleHandClear,
+	"CHAT_MESSAGE":         (*Hub).handleChatMessage,
+	"CHAT_DELETE":          (*Hub).handleChatDelete,
+	"CHAT_MUTE":            (*Hub).handleChatMute,
+	"CHAT_UNMUTE":          (*Hub).handleChatUnmute,
+	"WEBRTC_OFFER":         (*Hub).handleWebRTCSignal,
+	"WEBRTC_ANSWER":        (*Hub).handleWebRTCSignal,
+	"WEBRTC_ICE_CANDIDATE": (*Hub).handleWebRTCSignal,
+}
+
+func requireRole(client ClientInfo, role string) error {
+	if client.Role != role {
+		return protoErr(CodeForbidden, "Forbidden, "+role+" event only")
+	}
+	return nil
+}
+
+// requireSessionTeacher checks that client is the teacher who started the
+// active session, not just any teacher.
+func (h *Hub) requireSessionTeacher(client ClientInfo) error {
+	if err := requireRole(client, "teacher"); err != nil {
+		return err
+	}
+	s := h.session.Get()
+	if s == nil {
+		return errNoSession
+	}
+	if s.TeacherID != client.UserID {
+		return protoErr(CodeForbidden, "Forbidden, not the teacher of this session")
+	}
+	return nil
+}
+
+func (h *Hub) sendError(conn *websocket.Conn, replyTo string, err *Error) {
+	h.sendToClient(conn, WSMessage{Event: "ERROR", ReplyTo: replyTo, Error: err})
+}
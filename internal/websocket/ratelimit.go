@@ -0,0 +1,183 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/metrics"
+)
+
+const (
+	closeRateLimited     = 4005
+	closeConnectionLimit = 4006
+)
+
+var (
+	errUserConnLimit = errors.New("too many connections for this user")
+	errIPConnLimit   = errors.New("too many connections from this address")
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketSet holds the token buckets of one connection or user, one per
+// limited event.
+type bucketSet struct {
+	mu         sync.Mutex
+	limits     map[string]config.EventLimit
+	buckets    map[string]*tokenBucket
+	violations []time.Time
+}
+
+func newBucketSet(limits map[string]config.EventLimit) *bucketSet {
+	return &bucketSet{limits: limits, buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token for event and reports whether one was available.
+// Events without a limit of their own draw from the * bucket; if there is
+// none they are not limited.
+func (b *bucketSet) allow(event string, now time.Time) bool {
+	limit, ok := b.limits[event]
+	if !ok {
+		event = "*"
+		if limit, ok = b.limits[event]; !ok {
+			return true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.buckets[event]
+	if !ok {
+		t = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		b.buckets[event] = t
+	}
+	t.tokens += now.Sub(t.last).Seconds() * limit.Rate
+	if t.tokens > float64(limit.Burst) {
+		t.tokens = float64(limit.Burst)
+	}
+	t.last = now
+
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// violation records a throttled event and reports whether the connection
+// has now been throttled too often within the window.
+func (b *bucketSet) violation(now time.Time, cfg config.WSRateLimit) bool {
+	window := cfg.ViolationWindow
+	max := cfg.MaxViolations
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	recent := b.violations[:0]
+	for _, at := range b.violations {
+		if now.Sub(at) <= window {
+			recent = append(recent, at)
+		}
+	}
+	b.violations = append(recent, now)
+	return max > 0 && len(b.violations) >= max
+}
+
+func (h *Hub) userBucketSet(userID string, limits map[string]config.EventLimit) *bucketSet {
+	h.userBucketsMu.Lock()
+	defer h.userBucketsMu.Unlock()
+
+	b, ok := h.userBuckets[userID]
+	if !ok {
+		b = newBucketSet(limits)
+		h.userBuckets[userID] = b
+	}
+	return b
+}
+
+// throttle applies the connection and user limits to an inbound event. It
+// returns a rate_limited error for the client, or closes the connection with
+// 4005 and reports closed once it has been throttled MaxViolations times
+// within ViolationWindow.
+func (h *Hub) throttle(conn *websocket.Conn, client ClientInfo, event string) (err *Error, closed bool) {
+	if client.limiter == nil {
+		return nil, false
+	}
+	now := time.Now()
+	if client.limiter.allow(event, now) && h.userBucketSet(client.UserID, client.opts.userLimits).allow(event, now) {
+		return nil, false
+	}
+
+	metrics.WSRateLimited.WithLabelValues(event).Inc()
+	if client.limiter.violation(now, client.opts.cfg.RateLimit) {
+		h.connLogger(conn).Warn("closing connection for exceeding rate limits", "event", event)
+		metrics.WSRejected.WithLabelValues("rate_limited").Inc()
+		h.closeConn(conn, closeRateLimited, "rate limit exceeded")
+		return nil, true
+	}
+	return protoErr(CodeRateLimited, "too many "+event+" messages, slow down"), false
+}
+
+// checkConnLimits enforces MaxConnsPerUser and MaxConnsPerIP on this
+// instance, not counting connections about to be evicted. An empty userID
+// skips the per-user check. The caller must hold clientsMu.
+func (h *Hub) checkConnLimits(o *connOptions, userID, ip string, evict []*websocket.Conn) error {
+	leaving := make(map[*websocket.Conn]bool, len(evict))
+	for _, conn := range evict {
+		leaving[conn] = true
+	}
+
+	users, ips := 0, 0
+	for conn, info := range h.clients {
+		if leaving[conn] {
+			continue
+		}
+		if userID != "" && info.UserID == userID {
+			users++
+		}
+		if info.ip == ip {
+			ips++
+		}
+	}
+
+	if max := o.cfg.MaxConnsPerUser; max > 0 && users >= max {
+		return errUserConnLimit
+	}
+	if max := o.cfg.MaxConnsPerIP; max > 0 && ips >= max {
+		return errIPConnLimit
+	}
+	return nil
+}
+
+// wouldExceedConnLimits lets HandleWebSocket refuse a connection with 429
+// before upgrading.
+func (h *Hub) wouldExceedConnLimits(o *connOptions, userID, ip string) error {
+	h.clientsMu.RLock()
+	defer h.clientsMu.RUnlock()
+	return h.checkConnLimits(o, userID, ip, nil)
+}
+
+// pruneLimiters forgets the buckets of users with no connections left.
+func (h *Hub) pruneLimiters() {
+	connected := map[string]bool{}
+	h.clientsMu.RLock()
+	for _, info := range h.clients {
+		connected[info.UserID] = true
+	}
+	h.clientsMu.RUnlock()
+
+	h.userBucketsMu.Lock()
+	for userID := range h.userBuckets {
+		if !connected[userID] {
+			delete(h.userBuckets, userID)
+		}
+	}
+	h.userBucketsMu.Unlock()
+}
@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+)
+
+// Throttled events are answered with rate_limited, and a connection that
+// keeps exceeding its limits is closed with 4005.
+func TestRateLimitDisconnect(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{RateLimit: config.WSRateLimit{
+		PerConnection:   []string{"*:0.1:1"},
+		MaxViolations:   3,
+		ViolationWindow: time.Minute,
+	}})
+	conn, _ := s.dial(t, "st1", "student", "")
+
+	for i := 0; i < 4; i++ {
+		send(t, conn, "", "MEDIA_STATE", MediaState{Audio: true})
+	}
+	f := readEvent(t, conn, "ERROR", nil)
+	if f.Error == nil || f.Error.Code != CodeRateLimited {
+		t.Fatalf("error = %+v, want %s", f.Error, CodeRateLimited)
+	}
+	expectClose(t, conn, closeRateLimited)
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+}
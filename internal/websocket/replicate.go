@@ -0,0 +1,235 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+)
+
+// The session is kept in the broker as a hash with one field per value that
+// is changed on its own: the session's identity, each student's mark, each
+// student's hand raises and each mute. Instances write only the fields a
+// change touched, so two teachers' or students' updates made on different
+// instances at the same time do not overwrite each other.
+const (
+	fieldMeta       = "meta"
+	fieldAttendance = "attendance:"
+	fieldHands      = "hands:"
+	fieldMute       = "mute:"
+)
+
+// sessionMeta is the part of the session fixed when it starts.
+type sessionMeta struct {
+	SessionID string `json:"sessionId"`
+	ClassID   string `json:"classId"`
+	TeacherID string `json:"teacherId"`
+	RoomID    string `json:"roomId"`
+	StartedAt string `json:"startedAt"`
+}
+
+// sessionFields encodes s as the fields of its broker hash.
+func sessionFields(s *session.ActiveSession) map[string][]byte {
+	fields := map[string][]byte{}
+	fields[fieldMeta], _ = json.Marshal(sessionMeta{
+		SessionID: s.SessionID,
+		ClassID:   s.ClassID,
+		TeacherID: s.TeacherID,
+		RoomID:    s.RoomID,
+		StartedAt: s.StartedAt,
+	})
+	for studentID, status := range s.Attendance {
+		fields[fieldAttendance+studentID] = []byte(status)
+	}
+	byStudent := map[string][]session.HandRaise{}
+	for _, raise := range s.Hands {
+		byStudent[raise.StudentID] = append(byStudent[raise.StudentID], raise)
+	}
+	for studentID, raises := range byStudent {
+		fields[fieldHands+studentID], _ = json.Marshal(raises)
+	}
+	for userID, until := range s.Muted {
+		fields[fieldMute+userID], _ = until.MarshalText()
+	}
+	return fields
+}
+
+// decodeSession rebuilds a session from its broker hash. It is nil if the
+// hash holds no session.
+func decodeSession(fields map[string][]byte) *session.ActiveSession {
+	var meta sessionMeta
+	if json.Unmarshal(fields[fieldMeta], &meta) != nil || meta.SessionID == "" {
+		return nil
+	}
+	s := &session.ActiveSession{
+		SessionID:  meta.SessionID,
+		ClassID:    meta.ClassID,
+		TeacherID:  meta.TeacherID,
+		RoomID:     meta.RoomID,
+		StartedAt:  meta.StartedAt,
+		Attendance: map[string]string{},
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	applySessionFields(s, fields, names)
+	return s
+}
+
+// applySessionFields copies the named fields from the broker hash into s. A
+// name missing from fields was deleted.
+func applySessionFields(s *session.ActiveSession, fields map[string][]byte, names []string) {
+	handsChanged := false
+	for _, name := range names {
+		value, ok := fields[name]
+		switch {
+		case strings.HasPrefix(name, fieldAttendance):
+			studentID := strings.TrimPrefix(name, fieldAttendance)
+			if ok {
+				s.Attendance[studentID] = string(value)
+			} else {
+				delete(s.Attendance, studentID)
+			}
+		case strings.HasPrefix(name, fieldHands):
+			studentID := strings.TrimPrefix(name, fieldHands)
+			var hands []session.HandRaise
+			for _, raise := range s.Hands {
+				if raise.StudentID != studentID {
+					hands = append(hands, raise)
+				}
+			}
+			var raises []session.HandRaise
+			if ok && json.Unmarshal(value, &raises) == nil {
+				hands = append(hands, raises...)
+			}
+			s.Hands = hands
+			handsChanged = true
+		case strings.HasPrefix(name, fieldMute):
+			userID := strings.TrimPrefix(name, fieldMute)
+			var until time.Time
+			if !ok || until.UnmarshalText(value) != nil {
+				delete(s.Muted, userID)
+				continue
+			}
+			if s.Muted == nil {
+				s.Muted = map[string]time.Time{}
+			}
+			s.Muted[userID] = until
+		}
+	}
+	if handsChanged {
+		// The queue is ordered by when hands were raised, wherever they were.
+		sort.SliceStable(s.Hands, func(i, j int) bool {
+			return s.Hands[i].RaisedAt.Before(s.Hands[j].RaisedAt)
+		})
+	}
+}
+
+// storeSession replaces the session hash with every field of s.
+func (h *Hub) storeSession(ctx context.Context, s *session.ActiveSession) error {
+	return h.bus.HReplace(ctx, sessionKey, sessionFields(s))
+}
+
+// storeSessionChanges writes the fields that differ between prev and cur
+// and returns their names.
+func (h *Hub) storeSessionChanges(ctx context.Context, prev, cur *session.ActiveSession) ([]string, error) {
+	before, after := sessionFields(prev), sessionFields(cur)
+
+	var names, deleted []string
+	set := map[string][]byte{}
+	for name, value := range after {
+		if !bytes.Equal(before[name], value) {
+			set[name] = value
+			names = append(names, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	if err := h.bus.HUpdate(ctx, sessionKey, set, deleted); err != nil {
+		return nil, err
+	}
+	return append(names, deleted...), nil
+}
+
+// replicationQueue runs the hub's session reads and writes against the
+// broker one at a time, in the order they were queued, on a goroutine of its
+// own. Session changes and the broker's delivery goroutine only queue work,
+// so neither waits on the broker.
+type replicationQueue struct {
+	mu     sync.Mutex
+	tasks  []func()
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+func newReplicationQueue() *replicationQueue {
+	q := &replicationQueue{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// push queues task behind those already waiting. It never blocks; tasks
+// pushed after close are dropped.
+func (q *replicationQueue) push(task func()) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.tasks = append(q.tasks, task)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *replicationQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *replicationQueue) run() {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		tasks, closed := q.tasks, q.closed
+		q.tasks = nil
+		q.mu.Unlock()
+
+		for _, task := range tasks {
+			task()
+		}
+		if len(tasks) == 0 {
+			if closed {
+				return
+			}
+			<-q.wake
+		}
+	}
+}
+
+// close runs the tasks already queued and stops the queue. It returns when
+// they are done or ctx expires.
+func (q *replicationQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/broker"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+)
+
+func testSession() *session.ActiveSession {
+	return &session.ActiveSession{
+		SessionID:  "s1",
+		ClassID:    "c1",
+		TeacherID:  "t1",
+		RoomID:     "r1",
+		Attendance: map[string]string{},
+	}
+}
+
+// Changes two instances make to the same session at the same time are both
+// kept, and a receiver applying them ends up with the stored session.
+func TestSessionChangesMerge(t *testing.T) {
+	ctx := context.Background()
+	a := &Hub{bus: broker.NewMemory()}
+	b := &Hub{bus: a.bus}
+
+	base := testSession()
+	if err := a.storeSession(ctx, base); err != nil {
+		t.Fatal(err)
+	}
+
+	raisedAt := time.Now().UTC().Truncate(time.Second)
+	onA, onB := testSession(), testSession()
+	onA.Attendance["st1"] = "present"
+	onA.Hands = []session.HandRaise{{StudentID: "st3", RaisedAt: raisedAt.Add(time.Second)}}
+	onB.Attendance["st2"] = "absent"
+	onB.Hands = []session.HandRaise{{StudentID: "st4", RaisedAt: raisedAt}}
+	onB.Muted = map[string]time.Time{"st5": {}}
+
+	changedA, err := a.storeSessionChanges(ctx, base, onA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedB, err := b.storeSessionChanges(ctx, base, onB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := a.bus.HGetAll(ctx, sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := decodeSession(fields)
+	if stored == nil || stored.TeacherID != "t1" {
+		t.Fatalf("stored session = %+v", stored)
+	}
+	if stored.Attendance["st1"] != "present" || stored.Attendance["st2"] != "absent" {
+		t.Errorf("attendance = %v, want both marks", stored.Attendance)
+	}
+	if len(stored.Hands) != 2 || stored.Hands[0].StudentID != "st4" || stored.Hands[1].StudentID != "st3" {
+		t.Errorf("hands = %+v, want st4 then st3", stored.Hands)
+	}
+	if _, ok := stored.Muted["st5"]; !ok {
+		t.Errorf("muted = %v, want st5", stored.Muted)
+	}
+
+	// Instance A applies B's change on top of its own.
+	applySessionFields(onA, fields, changedB)
+	if onA.Attendance["st1"] != "present" || onA.Attendance["st2"] != "absent" || len(onA.Hands) != 2 {
+		t.Errorf("A after applying B = %+v", onA)
+	}
+	applySessionFields(onB, fields, changedA)
+	if onB.Attendance["st1"] != "present" || len(onB.Hands) != 2 || onB.Hands[0].StudentID != "st4" {
+		t.Errorf("B after applying A = %+v", onB)
+	}
+
+	// Lifting the mute deletes its field.
+	unmuted := testSession()
+	unmuted.Attendance = stored.Attendance
+	unmuted.Hands = stored.Hands
+	changed, err := b.storeSessionChanges(ctx, stored, unmuted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != fieldMute+"st5" {
+		t.Errorf("changed = %v, want only the mute", changed)
+	}
+	fields, _ = a.bus.HGetAll(ctx, sessionKey)
+	applySessionFields(onA, fields, changed)
+	if _, ok := onA.Muted["st5"]; ok {
+		t.Error("mute still applied after it was lifted")
+	}
+}
+
+// A change made on one instance reaches another through the broker, and a
+// session started elsewhere is adopted by an instance that starts later.
+func TestSessionReplicatesBetweenHubs(t *testing.T) {
+	mr := miniredis.RunT(t)
+	newHub := func() *Hub {
+		t.Helper()
+		bus, err := broker.NewRedis("redis://"+mr.Addr(), "test:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { bus.Close() })
+		h, err := NewHub(Options{Broker: bus})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	a, b := newHub(), newHub()
+
+	a.session.Set(testSession())
+	a.session.WithWrite(func(s *session.ActiveSession) { s.Attendance["st1"] = "present" })
+	waitFor(t, "the mark to reach b", func() bool {
+		s := b.session.Get()
+		return s != nil && s.Attendance["st1"] == "present"
+	})
+
+	c := newHub()
+	if s := c.session.Get(); s == nil || s.TeacherID != "t1" || s.Attendance["st1"] != "present" {
+		t.Errorf("late instance adopted %+v", s)
+	}
+
+	b.session.Clear()
+	waitFor(t, "the end of the session to reach a", func() bool { return a.session.Get() == nil })
+}
@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventLog is a bounded, sequenced record of the broadcasts sent to one room.
+// With a shared broker, sequence numbers are assigned by the broker and
+// events from other instances may be recorded slightly out of order.
+type eventLog struct {
+	mu     sync.Mutex
+	size   int         // events kept
+	seq    uint64      // highest seq recorded
+	events []WSMessage // ring buffer, oldest at events[head] once full
+	head   int
+	lastAt time.Time
+}
+
+// append records msg, assigning it the next local sequence number unless it
+// already carries one.
+func (l *eventLog) append(msg WSMessage) WSMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if msg.Seq == 0 {
+		msg.Seq = l.seq + 1
+	}
+	if msg.Seq > l.seq {
+		l.seq = msg.Seq
+	}
+	l.lastAt = time.Now()
+
+	if len(l.events) < l.size {
+		l.events = append(l.events, msg)
+	} else {
+		l.events[l.head] = msg
+		l.head = (l.head + 1) % len(l.events)
+	}
+	return msg
+}
+
+// since returns the events after seq in sequence order. ok is false if some
+// of them have already been evicted or seq is ahead of the log, in which case
+// the caller must fall back to a snapshot.
+func (l *eventLog) since(seq uint64) (events []WSMessage, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq > l.seq {
+		return nil, false
+	}
+	if seq == l.seq {
+		return nil, true
+	}
+
+	oldest := l.seq
+	for _, msg := range l.events {
+		if msg.Seq < oldest {
+			oldest = msg.Seq
+		}
+		if msg.Seq > seq {
+			events = append(events, msg)
+		}
+	}
+	if seq+1 < oldest {
+		return nil, false
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	return events, true
+}
+
+func (l *eventLog) lastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+func (h *Hub) roomLog(room string) *eventLog {
+	h.roomLogsMu.Lock()
+	defer h.roomLogsMu.Unlock()
+
+	l, ok := h.roomLogs[room]
+	if !ok {
+		l = &eventLog{size: h.replayBuffer}
+		h.roomLogs[room] = l
+	}
+	return l
+}
+
+// currentRoom is the room broadcasts are addressed to: the active session's
+// room, or the lobby between sessions.
+func (h *Hub) currentRoom() string {
+	if s := h.session.Get(); s != nil && s.RoomID != "" {
+		return s.RoomID
+	}
+	return "lobby"
+}
+
+// resumeState is what a resume token refers to. It is stored in the broker
+// only once the connection it was issued to has closed, so any instance can
+// redeem it.
+type resumeState struct {
+	UserID string `json:"userId"`
+	Room   string `json:"room"`
+}
+
+func newResumeToken() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// releaseResumeToken makes a closed connection's token redeemable for the
+// resume window.
+func (h *Hub) releaseResumeToken(token, userID, room string) {
+	if token == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, _ := json.Marshal(resumeState{UserID: userID, Room: room})
+	if err := h.bus.Set(ctx, "resume:"+token, data, h.resumeTTL); err != nil {
+		slog.Warn("failed to store resume token", "error", err)
+	}
+}
+
+// redeemResumeToken consumes a token presented by userID on reconnect. A
+// token is single use and only valid after its connection has closed.
+func (h *Hub) redeemResumeToken(token, userID string) (resumeState, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, err := h.bus.Take(ctx, "resume:"+token)
+	if err != nil {
+		return resumeState{}, false
+	}
+	var r resumeState
+	if json.Unmarshal(data, &r) != nil || r.UserID != userID {
+		return resumeState{}, false
+	}
+	return r, true
+}
+
+// pruneResume drops the logs of rooms that have been idle for longer than
+// anyone could still resume into them.
+func (h *Hub) pruneResume(now time.Time) {
+	active := h.currentRoom()
+	h.roomLogsMu.Lock()
+	for room, l := range h.roomLogs {
+		l.mu.Lock()
+		idle := now.Sub(l.lastAt) > h.resumeTTL
+		l.mu.Unlock()
+		if room != active && idle {
+			delete(h.roomLogs, room)
+		}
+	}
+	h.roomLogsMu.Unlock()
+}
+
+// parseLastSeq reads the lastSeq query parameter; it is zero if absent.
+func parseLastSeq(raw string) uint64 {
+	n, _ := strconv.ParseUint(raw, 10, 64)
+	return n
+}
+
+// replayMissed brings a resumed connection up to date. It replays the
+// broadcasts after lastSeq if they are all still buffered for the room the
+// client left, and otherwise sends a ROOM_STATE snapshot. Events that race
+// with the replay may arrive twice; clients drop any seq they have seen.
+func (h *Hub) replayMissed(conn *websocket.Conn, room string, lastSeq uint64) {
+	if room == h.currentRoom() {
+		if events, ok := h.roomLog(room).since(lastSeq); ok {
+			userID := h.getClient(conn).UserID
+			for _, msg := range events {
+				if msg.excludeUser != userID {
+					h.sendToClient(conn, msg)
+				}
+			}
+			h.connLogger(conn).Info("replayed missed events", "room", room, "last_seq", lastSeq, "count", len(events))
+			return
+		}
+	}
+	h.sendRoomState(conn)
+	h.connLogger(conn).Info("sent room snapshot on resume", "room", room, "last_seq", lastSeq)
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+)
+
+// A client that reconnects with its resume token gets the broadcasts it
+// missed, and the token cannot be used twice.
+func TestResumeReplaysMissedEvents(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn, welcome := s.dial(t, "st1", "student", "")
+	readEvent(t, conn, "ROOM_STATE", nil)
+
+	conn.Close()
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+
+	// st2 joins while st1 is away.
+	s.dial(t, "st2", "student", "")
+
+	resume := url.Values{
+		"resume":  {welcome.ResumeToken},
+		"lastSeq": {strconv.FormatUint(welcome.Seq, 10)},
+	}.Encode()
+	conn, resumed := s.dial(t, "st1", "student", resume)
+	if !resumed.Resumed {
+		t.Fatal("reconnect with a resume token was not resumed")
+	}
+	var joined PeerJoinedData
+	f := readEvent(t, conn, "PEER_JOINED", &joined)
+	if joined.UserID != "st2" || f.Seq <= welcome.Seq {
+		t.Errorf("replayed PEER_JOINED = %+v at seq %d, want st2 after seq %d", joined, f.Seq, welcome.Seq)
+	}
+
+	conn.Close()
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+	if _, again := s.dial(t, "st1", "student", resume); again.Resumed {
+		t.Error("a used resume token was accepted again")
+	}
+}
+
+// A connection refused after the upgrade leaves its resume token usable.
+func TestResumeTokenSurvivesRefusal(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{DevicePolicy: "reject", MaxDevices: 1})
+	conn, welcome := s.dial(t, "st1", "student", "deviceId=laptop")
+	conn.Close()
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+
+	phone, _ := s.dial(t, "st1", "student", "deviceId=phone")
+	resume := url.Values{
+		"deviceId": {"laptop"},
+		"resume":   {welcome.ResumeToken},
+		"lastSeq":  {strconv.FormatUint(welcome.Seq, 10)},
+	}.Encode()
+	expectClose(t, s.dialAuthMessage(t, "st1", "student", resume), closeDeviceLimit)
+
+	phone.Close()
+	waitFor(t, "the phone to disconnect", func() bool { return s.connections("st1") == 0 })
+	if _, resumed := s.dial(t, "st1", "student", resume); !resumed.Resumed {
+		t.Error("resume token was used up by the refused connection")
+	}
+}
+
+// Replay skips broadcasts that were not sent to the resuming user, such as
+// the announcement of their own join from another device.
+func TestResumeSkipsOwnEvents(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	conn, welcome := s.dial(t, "st1", "student", "")
+	conn.Close()
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+
+	other, _ := s.dial(t, "st1", "student", "")
+	other.Close()
+	waitFor(t, "st1 to disconnect", func() bool { return s.connections("st1") == 0 })
+	s.dial(t, "st2", "student", "")
+
+	resume := url.Values{
+		"resume":  {welcome.ResumeToken},
+		"lastSeq": {strconv.FormatUint(welcome.Seq, 10)},
+	}.Encode()
+	conn, _ = s.dial(t, "st1", "student", resume)
+	var joined PeerJoinedData
+	readEvent(t, conn, "PEER_JOINED", &joined)
+	if joined.UserID != "st2" {
+		t.Errorf("first replayed PEER_JOINED is for %s, want st2", joined.UserID)
+	}
+}
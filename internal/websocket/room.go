@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"context"
+	"sort"
+
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+)
+
+// MediaState is what a participant is currently sending.
+type MediaState struct {
+	Audio  bool `json:"audio"`
+	Video  bool `json:"video"`
+	Screen bool `json:"screen"`
+}
+
+func (p *MediaState) Validate() error {
+	return nil
+}
+
+// Participant is one user present in the room, with every device they are
+// connected from. Media is combined across devices.
+type Participant struct {
+	UserID  string     `json:"userId"`
+	Name    string     `json:"name"`
+	Role    string     `json:"role"`
+	Media   MediaState `json:"media"`
+	Devices []Device   `json:"devices"`
+}
+
+// Device is one connection of a participant.
+type Device struct {
+	ConnectionID string     `json:"connectionId"`
+	DeviceID     string     `json:"deviceId"`
+	Media        MediaState `json:"media"`
+}
+
+// SessionState describes the active attendance session.
+type SessionState struct {
+	SessionID string `json:"sessionId"`
+	ClassID   string `json:"classId"`
+	RoomID    string `json:"roomId"`
+	StartedAt string `json:"startedAt"`
+}
+
+// RoomStateData is a full snapshot of the room as seen by one client. Seq is
+// the last broadcast it reflects; later events continue from there.
+// Teachers see every mark, students only their own. Hands is the raised-hand
+// queue and Muted the active chat mutes, both visible to everyone.
+type RoomStateData struct {
+	Room         string            `json:"room"`
+	Seq          uint64            `json:"seq"`
+	Participants []Participant     `json:"participants"`
+	Session      *SessionState     `json:"session"`
+	Attendance   map[string]string `json:"attendance"`
+	Hands        []QueuedHand      `json:"hands"`
+	Muted        []ChatMuteData    `json:"muted"`
+}
+
+type MediaStateData struct {
+	UserID       string `json:"userId"`
+	ConnectionID string `json:"connectionId"`
+	MediaState
+}
+
+// participants lists everyone connected to any instance, grouped by user
+// and ordered by user ID. The exclude connection is left out, along with its
+// user if that was their only device.
+func (h *Hub) participants(exclude *websocket.Conn) []Participant {
+	var entries []rosterEntry
+	h.clientsMu.RLock()
+	for conn, info := range h.clients {
+		if conn == exclude {
+			continue
+		}
+		entries = append(entries, rosterEntry{
+			UserID:       info.UserID,
+			Name:         info.name,
+			Role:         info.Role,
+			ConnectionID: info.connID,
+			DeviceID:     info.deviceID,
+			Media:        info.media,
+		})
+	}
+	h.clientsMu.RUnlock()
+	entries = append(entries, h.remoteEntries()...)
+
+	byUser := map[string]*Participant{}
+	for _, e := range entries {
+		p, ok := byUser[e.UserID]
+		if !ok {
+			p = &Participant{UserID: e.UserID, Name: e.Name, Role: e.Role}
+			byUser[e.UserID] = p
+		}
+		p.Media.Audio = p.Media.Audio || e.Media.Audio
+		p.Media.Video = p.Media.Video || e.Media.Video
+		p.Media.Screen = p.Media.Screen || e.Media.Screen
+		p.Devices = append(p.Devices, Device{
+			ConnectionID: e.ConnectionID,
+			DeviceID:     e.DeviceID,
+			Media:        e.Media,
+		})
+	}
+
+	list := make([]Participant, 0, len(byUser))
+	for _, p := range byUser {
+		sort.Slice(p.Devices, func(i, j int) bool { return p.Devices[i].ConnectionID < p.Devices[j].ConnectionID })
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserID < list[j].UserID })
+	return list
+}
+
+// sendRoomState sends conn a ROOM_STATE snapshot: who else is in the room,
+// the active session and the attendance the client may see.
+func (h *Hub) sendRoomState(conn *websocket.Conn) {
+	client := h.getClient(conn)
+	room := h.currentRoom()
+	data := RoomStateData{
+		Room:         room,
+		Seq:          h.roomLog(room).lastSeq(),
+		Participants: h.participants(conn),
+	}
+	data.Session, data.Attendance = h.sessionView(client.UserID)
+	data.Hands = h.currentHandQueue()
+	data.Muted = h.activeMutes()
+
+	h.sendToClient(conn, WSMessage{Event: "ROOM_STATE", Data: data})
+}
+
+// sessionView describes the active session and the attendance userID may
+// see: every mark for the session's teacher, only their own for anyone else.
+// Both are nil without a session.
+func (h *Hub) sessionView(userID string) (state *SessionState, attendance map[string]string) {
+	h.session.WithRead(func(s *session.ActiveSession) {
+		state = &SessionState{
+			SessionID: s.SessionID,
+			ClassID:   s.ClassID,
+			RoomID:    s.RoomID,
+			StartedAt: s.StartedAt,
+		}
+		attendance = map[string]string{}
+		for studentID, status := range s.Attendance {
+			if s.TeacherID == userID || studentID == userID {
+				attendance[studentID] = status
+			}
+		}
+	})
+	return state, attendance
+}
+
+// handleMediaState records what the sender is publishing and tells the room.
+func (h *Hub) handleMediaState(ctx context.Context, req *request) error {
+	var p MediaState
+	if err := req.decode(&p); err != nil {
+		return err
+	}
+
+	h.clientsMu.Lock()
+	if info, ok := h.clients[req.conn]; ok {
+		info.media = p
+		h.clients[req.conn] = info
+	}
+	h.clientsMu.Unlock()
+	h.publishRoster()
+
+	h.broadcast(ctx, WSMessage{
+		Event: "MEDIA_STATE",
+		Data: MediaStateData{
+			UserID:       req.client.UserID,
+			ConnectionID: req.client.connID,
+			MediaState:   p,
+		},
+	})
+	return nil
+}
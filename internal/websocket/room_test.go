@@ -0,0 +1,41 @@
+package websocket
+
+import (
+	"testing"
+
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+)
+
+// ROOM_STATE shows every mark to the session's teacher and only their own
+// to anyone else, including other teachers.
+func TestRoomStateAttendanceVisibility(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	s.startSession("t1")
+	s.hub.session.WithWrite(func(a *session.ActiveSession) {
+		a.Attendance["st1"] = "present"
+		a.Attendance["st2"] = "absent"
+	})
+
+	for _, tc := range []struct {
+		userID, role string
+		want         map[string]string
+	}{
+		{"t1", "teacher", map[string]string{"st1": "present", "st2": "absent"}},
+		{"st1", "student", map[string]string{"st1": "present"}},
+		{"t2", "teacher", map[string]string{}},
+	} {
+		conn, _ := s.dial(t, tc.userID, tc.role, "")
+		var state RoomStateData
+		readEvent(t, conn, "ROOM_STATE", &state)
+		if len(state.Attendance) != len(tc.want) {
+			t.Errorf("%s sees attendance %v, want %v", tc.userID, state.Attendance, tc.want)
+			continue
+		}
+		for id, status := range tc.want {
+			if state.Attendance[id] != status {
+				t.Errorf("%s sees attendance %v, want %v", tc.userID, state.Attendance, tc.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// StartScheduler runs the background sweep that asks clients to re-authenticate
+// shortly before their token expires and closes connections once it has. It
+// stops when Shutdown is called.
+func (h *Hub) StartScheduler() {
+	h.schedulerOnce.Do(func() {
+		h.lastSweep.Store(time.Now().UnixNano())
+		go h.runScheduler()
+	})
+}
+
+func (h *Hub) runScheduler() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.schedulerStop:
+			return
+		case now := <-ticker.C:
+			h.sweepExpired(now)
+			h.pruneResume(now)
+			h.pruneRosters(now)
+			h.pruneLimiters()
+			h.publishRoster()
+			h.lastSweep.Store(now.UnixNano())
+		}
+	}
+}
+
+func (h *Hub) stopScheduler() {
+	select {
+	case <-h.schedulerStop:
+	default:
+		close(h.schedulerStop)
+	}
+}
+
+func (h *Hub) sweepExpired(now time.Time) {
+	var expired, warn []*websocket.Conn
+	warnAt := map[*websocket.Conn]time.Time{}
+
+	h.clientsMu.Lock()
+	for conn, info := range h.clients {
+		if info.ExpiresAt.IsZero() {
+			continue
+		}
+		switch {
+		case now.After(info.ExpiresAt):
+			expired = append(expired, conn)
+		case !info.reauthSent && now.Add(reauthWindow).After(info.ExpiresAt):
+			info.reauthSent = true
+			h.clients[conn] = info
+			warn = append(warn, conn)
+			warnAt[conn] = info.ExpiresAt
+		}
+	}
+	h.clientsMu.Unlock()
+
+	for _, conn := range expired {
+		h.connLogger(conn).Info("token expired, closing connection")
+		h.closeConn(conn, closeAuthExpired, "token expired")
+	}
+	for _, conn := range warn {
+		h.sendToClient(conn, WSMessage{
+			Event: "REAUTH",
+			Data:  AuthOKData{ExpiresAt: expiryValue(warnAt[conn])},
+		})
+	}
+}
+
+// SchedulerHealth reports whether the expiry sweep is keeping up.
+type SchedulerHealth struct {
+	Status  string     `json:"status"`
+	LastRun *time.Time `json:"lastRun,omitempty"`
+}
+
+// HubHealth describes the WebSocket hub for readiness checks.
+type HubHealth struct {
+	Status       string `json:"status"`
+	Connections  int    `json:"connections"`
+	Streams      int    `json:"streams"`
+	ShuttingDown bool   `json:"shuttingDown"`
+}
+
+// CheckScheduler reports "down" if the scheduler was never started or has
+// missed several consecutive passes.
+func (h *Hub) CheckScheduler() SchedulerHealth {
+	last := h.lastSweep.Load()
+	if last == 0 {
+		return SchedulerHealth{Status: "stopped"}
+	}
+	t := time.Unix(0, last).UTC()
+	health := SchedulerHealth{Status: "up", LastRun: &t}
+	if time.Since(t) > 3*expiryInterval {
+		health.Status = "down"
+	}
+	return health
+}
+
+// CheckHub reports "down" once the hub has started shutting down and stops
+// accepting connections.
+func (h *Hub) CheckHub() HubHealth {
+	h.clientsMu.RLock()
+	n := len(h.clients)
+	h.clientsMu.RUnlock()
+
+	health := HubHealth{Status: "up", Connections: n, Streams: h.streamCount(), ShuttingDown: h.shuttingDown.Load()}
+	if health.ShuttingDown {
+		health.Status = "down"
+	}
+	return health
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/models"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/session"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShutdownOptions controls how Shutdown treats clients and the active
+// session.
+type ShutdownOptions struct {
+	// ReconnectAfter is sent to clients as a hint for when to reconnect.
+	ReconnectAfter time.Duration
+	// SessionMode is "finalize" to end the active session as DONE would, or
+	// "checkpoint" to save the attendance marked so far and leave the
+	// session open.
+	SessionMode string
+}
+
+// Shutdown refuses new connections, saves the active session, sends
+// SERVER_SHUTDOWN to every client and closes their sockets. It returns once
+// all connections are released or ctx expires.
+func (h *Hub) Shutdown(ctx context.Context, opts ShutdownOptions) error {
+	h.shuttingDown.Store(true)
+	h.stopScheduler()
+
+	// Other instances keep serving the session, so only the last one to
+	// leave may end it.
+	if peers := h.remoteInstances(); len(peers) > 0 && opts.SessionMode != "checkpoint" {
+		slog.Info("other instances are still running, checkpointing session instead of finalizing", "peers", len(peers))
+		opts.SessionMode = "checkpoint"
+	}
+
+	// Only a distributed broker keeps the open session for the next
+	// instance to adopt; with the in-memory one a checkpointed session
+	// could never be ended.
+	if opts.SessionMode == "checkpoint" && !h.bus.Distributed() {
+		slog.Info("no distributed broker to hand the session to, finalizing instead of checkpointing")
+		opts.SessionMode = "finalize"
+	}
+
+	if h.session.Get() != nil {
+		if opts.SessionMode == "checkpoint" {
+			if err := h.checkpointSession(ctx); err != nil {
+				slog.Error("failed to checkpoint session", "error", err)
+			}
+		} else {
+			room := h.currentRoom()
+			present, absent, err := h.finalizeSession(ctx)
+			if err != nil {
+				// The session is still active, so a distributed broker
+				// keeps it for the next instance to finish.
+				slog.Error("failed to finalize session, leaving it open", "error", err, "kept_in_broker", h.bus.Distributed())
+			} else {
+				h.broadcast(ctx, WSMessage{
+					Event: "DONE",
+					room:  room,
+					Data: DoneData{
+						Message:     "Attendance persisted",
+						SummaryData: summarize(present, absent),
+					},
+				})
+			}
+		}
+	}
+
+	// Let the session's last change reach the broker before exiting.
+	if h.replication != nil {
+		if err := h.replication.close(ctx); err != nil {
+			slog.Error("failed to replicate the session before shutdown", "error", err)
+		}
+	}
+
+	// Only this instance's clients are affected, so SERVER_SHUTDOWN is
+	// neither logged to the room nor published.
+	notice := ShutdownData{
+		Message:          "Server is restarting, please reconnect",
+		ReconnectAfterMs: opts.ReconnectAfter.Milliseconds(),
+	}
+	h.deliver(WSMessage{Event: "SERVER_SHUTDOWN", ID: h.nextMessageID(), Data: notice}, "")
+	h.closeStreams(notice)
+
+	h.clientsMu.RLock()
+	conns := make([]*websocket.Conn, 0, len(h.clients))
+	for c := range h.clients {
+		conns = append(conns, c)
+	}
+	h.clientsMu.RUnlock()
+
+	// SERVER_SHUTDOWN is already queued, so it is written before the close.
+	for _, conn := range conns {
+		h.closeConn(conn, websocket.CloseServiceRestart, "server shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.connWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("closed websocket connections", "count", len(conns))
+		h.leaveRoster(ctx)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkpointSession saves the attendance marked so far without ending the
+// session, so nothing recorded before a restart is lost.
+func (h *Hub) checkpointSession(ctx context.Context) error {
+	s := h.session.Get()
+	if s == nil {
+		return nil
+	}
+
+	classID, err := primitive.ObjectIDFromHex(s.ClassID)
+	if err != nil {
+		return err
+	}
+	sessionID, _ := primitive.ObjectIDFromHex(s.SessionID)
+
+	var (
+		records []models.Attendance
+		hands   []session.HandRaise
+	)
+	h.session.WithRead(func(s *session.ActiveSession) {
+		hands = append([]session.HandRaise(nil), s.Hands...)
+		for studentID, status := range s.Attendance {
+			records = append(records, models.Attendance{
+				ID:        primitive.NewObjectID(),
+				ClassID:   classID,
+				SessionID: sessionID,
+				StudentID: studentID,
+				Status:    status,
+			})
+		}
+	})
+
+	if err := h.store.Attendance.Replace(ctx, records); err != nil {
+		return err
+	}
+	if !sessionID.IsZero() {
+		if err := h.store.Sessions.SaveHandRaises(ctx, sessionID, handRaiseRecords(hands, time.Time{})); err != nil {
+			return err
+		}
+	}
+	slog.Info("checkpointed attendance", "session_id", s.SessionID, "records", len(records))
+	return nil
+}
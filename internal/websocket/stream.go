@@ -0,0 +1,446 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/logging"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/metrics"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// streamEvents are the broadcasts forwarded to Server-Sent Event streams.
+var streamEvents = map[string]bool{
+	"SESSION_STARTED":   true,
+	"ATTENDANCE_MARKED": true,
+	"TODAY_SUMMARY":     true,
+	"DONE":              true,
+}
+
+const (
+	streamBuffer    = 64
+	streamHeartbeat = 15 * time.Second
+)
+
+// stream is one SSE or long-poll subscriber to a class. room is the class's
+// session room, empty while the class has no session; it is guarded by
+// streamsMu. sentRoom and sentSeq are the last event the client was given and
+// belong to the goroutine serving it.
+type stream struct {
+	classID string
+	userID  string
+	room    string
+	events  chan streamEvent
+
+	sentRoom string
+	sentSeq  uint64
+}
+
+type streamEvent struct {
+	room string
+	msg  WSMessage
+}
+
+// fresh reports whether ev comes after what s has already been sent, and if
+// so records it as sent. Events logged while a stream was catching up are
+// both replayed and queued, and the queued copy is dropped here.
+func (s *stream) fresh(ev streamEvent) bool {
+	if ev.room == "" || ev.msg.Seq == 0 {
+		return true
+	}
+	if ev.room == s.sentRoom && ev.msg.Seq <= s.sentSeq {
+		return false
+	}
+	s.sentRoom, s.sentSeq = ev.room, ev.msg.Seq
+	return true
+}
+
+// AnnounceSession broadcasts SESSION_STARTED for the session that was just
+// set, into its room.
+func (h *Hub) AnnounceSession(ctx context.Context) {
+	s := h.session.Get()
+	if s == nil {
+		return
+	}
+	h.broadcast(ctx, WSMessage{
+		Event: "SESSION_STARTED",
+		Data: SessionState{
+			SessionID: s.SessionID,
+			ClassID:   s.ClassID,
+			RoomID:    s.RoomID,
+			StartedAt: s.StartedAt,
+		},
+	})
+}
+
+// notifyStreams forwards a broadcast logged to room to the streams following
+// that room. SESSION_STARTED moves the class's streams to the new room.
+func (h *Hub) notifyStreams(room string, msg WSMessage) {
+	if !streamEvents[msg.Event] {
+		return
+	}
+	classID := ""
+	if msg.Event == "SESSION_STARTED" {
+		classID = sessionClassID(msg.Data)
+	}
+
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+
+	for s := range h.streams {
+		if classID != "" && s.classID == classID {
+			s.room = room
+		}
+		if s.room == "" || s.room != room {
+			continue
+		}
+		select {
+		case s.events <- streamEvent{room: room, msg: msg}:
+		default:
+			// A client too slow to keep up is dropped; it reconnects with
+			// Last-Event-ID and catches up from the room log.
+			delete(h.streams, s)
+			close(s.events)
+		}
+	}
+}
+
+// sessionClassID reads the class of a SESSION_STARTED, which is raw JSON
+// when it was relayed by another instance.
+func sessionClassID(data interface{}) string {
+	switch d := data.(type) {
+	case SessionState:
+		return d.ClassID
+	case json.RawMessage:
+		var s SessionState
+		json.Unmarshal(d, &s)
+		return s.ClassID
+	}
+	return ""
+}
+
+// classRoom returns the room of classID's active session, if it has one.
+func (h *Hub) classRoom(classID string) string {
+	if s := h.session.Get(); s != nil && s.ClassID == classID {
+		return s.RoomID
+	}
+	return ""
+}
+
+// StreamClassEvents serves the attendance and session events of a class as
+// Server-Sent Events until the client disconnects or its token expires. The
+// caller has already checked that the user belongs to the class. Each
+// event's id is "<room>:<seq>"; a client reconnecting with Last-Event-ID gets
+// the events it missed, or a ROOM_STATE snapshot if they are no longer
+// buffered.
+func (h *Hub) StreamClassEvents(c *gin.Context, classID, userID string) {
+	if h.shuttingDown.Load() {
+		utils.ErrorResponse(c, 503, "Server is shutting down")
+		return
+	}
+
+	// Like a WebSocket, the stream lives no longer than the token that
+	// opened it; the client reconnects with a fresh one and Last-Event-ID.
+	var expired <-chan time.Time
+	if exp := tokenExpiry(c); !exp.IsZero() {
+		expiry := time.NewTimer(time.Until(exp))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	s := h.openStream(classID, userID)
+	defer h.closeStream(s)
+	metrics.SSEStreams.Inc()
+	defer metrics.SSEStreams.Dec()
+
+	l := logging.FromContext(c.Request.Context()).With("class_id", classID)
+	l.Info("event stream opened", "room", s.sentRoom)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+
+	w := c.Writer
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("lastEventId")
+	}
+	for _, ev := range h.catchUp(s, lastID) {
+		writeStreamEvent(w, ev.room, ev.msg)
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			l.Info("event stream closed")
+			return
+		case ev, ok := <-s.events:
+			if !ok {
+				l.Info("event stream ended by server")
+				return
+			}
+			if !s.fresh(ev) {
+				continue
+			}
+			if err := writeStreamEvent(w, ev.room, ev.msg); err != nil {
+				return
+			}
+			w.Flush()
+		case <-expired:
+			l.Info("event stream closed, token expired")
+			writeStreamEvent(w, "", WSMessage{Event: "ERROR", Data: protoErr(CodeUnauthorized, "Token expired")})
+			w.Flush()
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+// PolledEvent is one event in a long-poll response. ID is empty for events
+// that are not part of a room's sequence.
+type PolledEvent struct {
+	ID    string      `json:"id,omitempty"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// PollResponse lists the events after the poll's since, and the cursor to
+// pass as since on the next poll.
+type PollResponse struct {
+	Events []PolledEvent `json:"events"`
+	Next   string        `json:"next"`
+}
+
+// PollClassEvents is the long-polling form of StreamClassEvents for clients
+// that cannot hold a response open. It answers with the events after since
+// ("<room>:<seq>"), or a ROOM_STATE snapshot if they are no longer buffered.
+// If there are none yet it waits for one until the poll timeout passes or
+// the token expires, and then answers with an empty list.
+func (h *Hub) PollClassEvents(c *gin.Context, classID, userID string) {
+	if h.shuttingDown.Load() {
+		utils.ErrorResponse(c, 503, "Server is shutting down")
+		return
+	}
+
+	s := h.openStream(classID, userID)
+	defer h.closeStream(s)
+
+	resp := PollResponse{Events: []PolledEvent{}}
+	add := func(ev streamEvent) {
+		resp.Events = append(resp.Events, PolledEvent{
+			ID:    eventID(ev.room, ev.msg.Seq),
+			Event: ev.msg.Event,
+			Data:  ev.msg.Data,
+		})
+		metrics.WSMessagesOut.WithLabelValues(ev.msg.Event).Inc()
+	}
+	for _, ev := range h.catchUp(s, c.Query("since")) {
+		add(ev)
+	}
+
+	wait := h.pollTimeout
+	if exp := tokenExpiry(c); !exp.IsZero() && time.Until(exp) < wait {
+		wait = time.Until(exp)
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	open := true
+	for open && len(resp.Events) == 0 {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timeout.C:
+			open = false
+		case ev, ok := <-s.events:
+			if open = ok; ok && s.fresh(ev) {
+				add(ev)
+			}
+		}
+	}
+	// Whatever arrived along with the first event goes in the same answer.
+	for open {
+		select {
+		case ev, ok := <-s.events:
+			if open = ok; ok && s.fresh(ev) {
+				add(ev)
+			}
+		default:
+			open = false
+		}
+	}
+
+	resp.Next = fmt.Sprintf("%s:%d", s.sentRoom, s.sentSeq)
+	utils.SuccessResponse(c, 200, resp)
+}
+
+// tokenExpiry is when the token that authenticated c expires, or zero if it
+// does not.
+func tokenExpiry(c *gin.Context) time.Time {
+	if claims, ok := c.MustGet("claims").(*utils.Claims); ok {
+		return claims.ExpiresAt
+	}
+	return time.Time{}
+}
+
+// openStream subscribes to classID's events. Everything broadcast from then
+// on is queued, so catching up afterwards cannot miss an event.
+func (h *Hub) openStream(classID, userID string) *stream {
+	s := &stream{
+		classID: classID,
+		userID:  userID,
+		room:    h.classRoom(classID),
+		events:  make(chan streamEvent, streamBuffer),
+	}
+	s.sentRoom = s.room
+	h.streamsMu.Lock()
+	h.streams[s] = struct{}{}
+	h.streamsMu.Unlock()
+	return s
+}
+
+func (h *Hub) closeStream(s *stream) {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	if _, ok := h.streams[s]; ok {
+		delete(h.streams, s)
+		close(s.events)
+	}
+}
+
+// catchUp returns what s is sent first: the streamed events after lastID if
+// they are still in the room log, or else a ROOM_STATE snapshot.
+func (h *Hub) catchUp(s *stream, lastID string) []streamEvent {
+	h.streamsMu.Lock()
+	room := s.room
+	h.streamsMu.Unlock()
+
+	s.sentRoom, s.sentSeq = room, 0
+	var events []streamEvent
+	if seq, missed, ok := h.replayStream(room, lastID); ok {
+		s.sentSeq = seq
+		for _, msg := range missed {
+			if s.fresh(streamEvent{room: room, msg: msg}) {
+				events = append(events, streamEvent{room: room, msg: msg})
+			}
+		}
+		return events
+	}
+	state := h.streamRoomState(s, room)
+	snapshot := streamEvent{room: room, msg: WSMessage{Event: "ROOM_STATE", Seq: state.Seq, Data: state}}
+	s.fresh(snapshot)
+	return []streamEvent{snapshot}
+}
+
+// replayStream returns the streamed events after lastID if they are still in
+// room's log, along with lastID's seq, and reports whether it could. Without
+// a session there is nothing to replay, so a lastID with an empty room is
+// already up to date.
+func (h *Hub) replayStream(room, lastID string) (seq uint64, events []WSMessage, ok bool) {
+	i := strings.LastIndex(lastID, ":")
+	if i < 0 || lastID[:i] != room {
+		return 0, nil, false
+	}
+	if room == "" {
+		return 0, nil, true
+	}
+	seq, err := strconv.ParseUint(lastID[i+1:], 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	logged, ok := h.roomLog(room).since(seq)
+	if !ok {
+		return 0, nil, false
+	}
+	for _, msg := range logged {
+		if streamEvents[msg.Event] {
+			events = append(events, msg)
+		}
+	}
+	return seq, events, true
+}
+
+func (h *Hub) roomSeq(room string) uint64 {
+	if room == "" {
+		return 0
+	}
+	return h.roomLog(room).lastSeq()
+}
+
+// streamRoomState is the ROOM_STATE a stream following room starts with.
+// Participants are only listed while the class's session is the one in
+// progress.
+func (h *Hub) streamRoomState(s *stream, room string) RoomStateData {
+	data := RoomStateData{Room: room, Seq: h.roomSeq(room), Participants: []Participant{}}
+	if room == "" || room != h.currentRoom() {
+		return data
+	}
+	data.Participants = h.participants(nil)
+	data.Session, data.Attendance = h.sessionView(s.userID)
+	data.Hands = h.currentHandQueue()
+	data.Muted = h.activeMutes()
+	return data
+}
+
+// eventID is the SSE id of the event at seq in room, empty for events
+// outside a room's sequence.
+func eventID(room string, seq uint64) string {
+	if room == "" || seq == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", room, seq)
+}
+
+func writeStreamEvent(w io.Writer, room string, msg WSMessage) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	if id := eventID(room, msg.Seq); id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Event, data)
+	if err == nil {
+		metrics.WSMessagesOut.WithLabelValues(msg.Event).Inc()
+	}
+	return err
+}
+
+// closeStreams ends every stream with SERVER_SHUTDOWN so HTTP shutdown does
+// not wait for them.
+func (h *Hub) closeStreams(data ShutdownData) {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+
+	for s := range h.streams {
+		select {
+		case s.events <- streamEvent{msg: WSMessage{Event: "SERVER_SHUTDOWN", Data: data}}:
+		default:
+		}
+		delete(h.streams, s)
+		close(s.events)
+	}
+}
+
+func (h *Hub) streamCount() int {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	return len(h.streams)
+}
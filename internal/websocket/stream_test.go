@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/config"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/utils"
+)
+
+// get requests path as userID with a token valid for ttl. lastID is sent as
+// Last-Event-ID unless it is empty.
+func (s *testServer) get(t *testing.T, path, userID, role, lastID string, ttl time.Duration) *http.Response {
+	t.Helper()
+	token, _ := testAuth.Mint(utils.Claims{UserID: userID, Role: role, Name: userID}, ttl)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.base+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+	}
+	return resp
+}
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// readSSE reads the next event from a stream, skipping comments.
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.event != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// mark has the teacher on conn mark studentID present.
+func mark(t *testing.T, conn *websocket.Conn, studentID string) {
+	t.Helper()
+	send(t, conn, studentID, "ATTENDANCE_MARKED", MarkAttendancePayload{StudentID: studentID, Status: "present"})
+	if f := readReply(t, conn, studentID); f.Event != "ACK" {
+		t.Fatalf("marking %s = %+v", studentID, f)
+	}
+}
+
+func markedStudent(data string) string {
+	var marked AttendanceMarkedData
+	json.Unmarshal([]byte(data), &marked)
+	return marked.StudentID
+}
+
+func roomID(seq uint64) string {
+	return "room1:" + strconv.FormatUint(seq, 10)
+}
+
+// A stream reconnecting with Last-Event-ID gets the events it missed, each
+// once, and then the live ones.
+func TestStreamResumesFromLastEventID(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	s.startSession("t1")
+	teacher, _ := s.dial(t, "t1", "teacher", "")
+	since := s.hub.roomLog("room1").lastSeq()
+	mark(t, teacher, "st1")
+	mark(t, teacher, "st2")
+
+	r := bufio.NewReader(s.get(t, "/events", "st1", "student", roomID(since), time.Hour).Body)
+	for i, want := range []string{"st1", "st2"} {
+		ev := readSSE(t, r)
+		if ev.event != "ATTENDANCE_MARKED" || markedStudent(ev.data) != want || ev.id != roomID(since+uint64(i)+1) {
+			t.Fatalf("replayed event %d = %+v, want %s marked at %s", i, ev, want, roomID(since+uint64(i)+1))
+		}
+	}
+
+	mark(t, teacher, "st3")
+	if ev := readSSE(t, r); ev.event != "ATTENDANCE_MARKED" || markedStudent(ev.data) != "st3" {
+		t.Errorf("first event after the replay = %+v, want st3 marked", ev)
+	}
+}
+
+// A stream whose missed events have been trimmed from the room log starts
+// over from a ROOM_STATE snapshot.
+func TestStreamFallsBackToRoomState(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{ReplayBuffer: 2})
+	s.startSession("t1")
+	teacher, _ := s.dial(t, "t1", "teacher", "")
+	since := s.hub.roomLog("room1").lastSeq()
+	for _, id := range []string{"st1", "st2", "st3"} {
+		mark(t, teacher, id)
+	}
+
+	ev := readSSE(t, bufio.NewReader(s.get(t, "/events", "st1", "student", roomID(since), time.Hour).Body))
+	var state RoomStateData
+	json.Unmarshal([]byte(ev.data), &state)
+	if ev.event != "ROOM_STATE" || ev.id != roomID(since+3) || state.Attendance["st1"] != "present" {
+		t.Errorf("first event = %+v, want a ROOM_STATE at %s with st1's mark", ev, roomID(since+3))
+	}
+}
+
+// A stream ends with an unauthorized ERROR when its token expires.
+func TestStreamClosesWhenTokenExpires(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{})
+	r := bufio.NewReader(s.get(t, "/events", "st1", "student", "", 2*time.Second).Body)
+	if ev := readSSE(t, r); ev.event != "ROOM_STATE" {
+		t.Fatalf("first event = %+v, want ROOM_STATE", ev)
+	}
+
+	ev := readSSE(t, r)
+	var e Error
+	json.Unmarshal([]byte(ev.data), &e)
+	if ev.event != "ERROR" || e.Code != CodeUnauthorized {
+		t.Fatalf("event at expiry = %+v, want an %s ERROR", ev, CodeUnauthorized)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("stream still open after its token expired")
+	}
+}
+
+// poll long-polls for the events after since.
+func (s *testServer) poll(t *testing.T, userID, since string) PollResponse {
+	t.Helper()
+	resp := s.get(t, "/events/poll?since="+since, userID, "student", "", time.Hour)
+	var body struct {
+		Data PollResponse `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode poll response: %v", err)
+	}
+	return body.Data
+}
+
+// A long poll starts from a ROOM_STATE, waits for the next event, and
+// answers with nothing once its timeout passes.
+func TestPollClassEvents(t *testing.T) {
+	s := newTestServer(t, config.WebSocket{PollTimeout: 100 * time.Millisecond})
+	s.startSession("t1")
+	teacher, _ := s.dial(t, "t1", "teacher", "")
+
+	first := s.poll(t, "st1", "")
+	if len(first.Events) != 1 || first.Events[0].Event != "ROOM_STATE" {
+		t.Fatalf("first poll = %+v, want a ROOM_STATE", first)
+	}
+
+	polled := make(chan PollResponse, 1)
+	go func() { polled <- s.poll(t, "st1", first.Next) }()
+	waitFor(t, "the poll to wait", func() bool { return s.hub.streamCount() == 1 })
+	mark(t, teacher, "st1")
+	got := <-polled
+	if len(got.Events) != 1 || got.Events[0].Event != "ATTENDANCE_MARKED" || got.Next != got.Events[0].ID {
+		t.Fatalf("poll = %+v, want the mark and its id as next", got)
+	}
+
+	if idle := s.poll(t, "st1", got.Next); len(idle.Events) != 0 || idle.Next != got.Next {
+		t.Errorf("poll with nothing new = %+v, want no events and the same next", idle)
+	}
+}
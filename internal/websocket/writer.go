@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/waliamehak/WebSocket-live-attendance-system/internal/metrics"
+)
+
+const (
+	// sendQueueSize is how many messages may wait for a client before it is
+	// disconnected as too slow, and writeTimeout how long one write may
+	// take.
+	sendQueueSize = 256
+	writeTimeout  = 10 * time.Second
+
+	closeTooSlow = 4007
+)
+
+var (
+	errSendQueueFull = errors.New("send queue full")
+	errConnClosed    = errors.New("connection closed")
+)
+
+// outbox queues a connection's outgoing messages for its writer goroutine,
+// so broadcasts and broker callbacks never wait on a slow client, and the
+// writer is the connection's only writer as gorilla requires.
+type outbox struct {
+	messages chan WSMessage
+
+	closeOnce   sync.Once
+	closing     chan struct{} // closed by close
+	closeCode   int
+	closeReason string
+
+	done chan struct{} // closed when the writer has exited
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		messages: make(chan WSMessage, sendQueueSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// send queues msg without blocking.
+func (o *outbox) send(msg WSMessage) error {
+	select {
+	case <-o.closing:
+		return errConnClosed
+	default:
+	}
+	select {
+	case o.messages <- msg:
+		return nil
+	default:
+		return errSendQueueFull
+	}
+}
+
+// close asks the writer to send the messages already queued, then a close
+// frame with code and reason, and close the connection. Only the first call
+// has an effect.
+func (o *outbox) close(code int, reason string) {
+	o.closeOnce.Do(func() {
+		o.closeCode, o.closeReason = code, reason
+		close(o.closing)
+	})
+}
+
+// runWriter writes the messages queued in o to conn in version's frame
+// format until o is closed or a write fails, then closes conn.
+func runWriter(conn *websocket.Conn, o *outbox, version int, l *slog.Logger) {
+	defer close(o.done)
+	defer conn.Close()
+
+	for {
+		select {
+		case msg := <-o.messages:
+			if err := writeFrame(conn, msg, version); err != nil {
+				l.Warn("write error", "event", msg.Event, "error", err)
+				o.close(websocket.CloseAbnormalClosure, "")
+				dropQueued(o)
+				return
+			}
+		case <-o.closing:
+			for {
+				select {
+				case msg := <-o.messages:
+					if err := writeFrame(conn, msg, version); err != nil {
+						dropQueued(o)
+						return
+					}
+				default:
+					if o.closeCode != websocket.CloseAbnormalClosure {
+						closeWithReason(conn, o.closeCode, o.closeReason)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func writeFrame(conn *websocket.Conn, msg WSMessage, version int) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := conn.WriteJSON(msg.frame(version)); err != nil {
+		metrics.WSDropped.WithLabelValues(msg.Event).Inc()
+		return err
+	}
+	metrics.WSMessagesOut.WithLabelValues(msg.Event).Inc()
+	return nil
+}
+
+// dropQueued counts the messages a failed writer leaves behind.
+func dropQueued(o *outbox) {
+	for {
+		select {
+		case msg := <-o.messages:
+			metrics.WSDropped.WithLabelValues(msg.Event).Inc()
+		default:
+			return
+		}
+	}
+}